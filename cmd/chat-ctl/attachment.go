@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func newCmdAttachmentUpload() *cobra.Command {
+	var (
+		file  string
+		token string
+	)
+	cmd := &cobra.Command{
+		Use:   "upload",
+		Short: "Upload a file to be attached to messages",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return sendWithToken(cmd, http.MethodPost, baseURL+"/attachments", token, f)
+		},
+	}
+	cmd.Flags().StringVar(&file, "file", "", "The file to upload")
+	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token")
+	cmd.MarkFlagRequired("file")
+	cmd.MarkFlagRequired("token")
+	return cmd
+}
+
+func newCmdAttachmentDownload() *cobra.Command {
+	var (
+		id     string
+		output string
+		token  string
+	)
+	cmd := &cobra.Command{
+		Use:   "download",
+		Short: "Download the content of an attachment",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			req, err := http.NewRequest(http.MethodGet, baseURL+"/attachments/"+url.PathEscape(id), nil)
+			if err != nil {
+				return err
+			}
+			req.Header.Add("Authorization", "Bearer "+token)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return printResp(cmd, resp)
+			}
+			f, err := os.Create(output)
+			if err != nil {
+				return err
+			}
+			n, err := io.Copy(f, resp.Body)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(output)
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "saved %d bytes of %s to %s\n", n, resp.Header.Get("Content-Type"), output)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&id, "id", "", "Attachment id")
+	cmd.Flags().StringVar(&output, "output", "", "The file to save the content to")
+	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token")
+	cmd.MarkFlagRequired("id")
+	cmd.MarkFlagRequired("output")
+	cmd.MarkFlagRequired("token")
+	return cmd
+}
+
+func newCmdAttachment() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "attachment",
+		Short: "Upload and download message attachments",
+	}
+	cmd.AddCommand(newCmdAttachmentUpload())
+	cmd.AddCommand(newCmdAttachmentDownload())
+	return cmd
+}
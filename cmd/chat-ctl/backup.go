@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+
+	"github.com/gozssky/groupchat/pkg/backup"
+	"github.com/gozssky/groupchat/pkg/blob"
+	"github.com/gozssky/groupchat/pkg/raftnode"
+)
+
+func newCmdBackup() *cobra.Command {
+	var file string
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Save a consistent backup of the cluster data to a file",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			req, err := newAdminRequest(http.MethodGet, baseURL+"/cluster/backup", nil)
+			if err != nil {
+				return err
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return printResp(cmd, resp)
+			}
+			tmpFile := file + ".part"
+			f, err := os.Create(tmpFile)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, resp.Body); err != nil {
+				f.Close()
+				return err
+			}
+			if err := f.Sync(); err != nil {
+				f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+			if err := os.Rename(tmpFile, file); err != nil {
+				return err
+			}
+			cmd.Printf("backup is saved to %s\n", file)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&file, "file", "", "Path of the backup file")
+	cmd.MarkFlagRequired("file")
+	return cmd
+}
+
+func newCmdRestore() *cobra.Command {
+	var (
+		file     string
+		dataDir  string
+		localURL string
+		peerURLs []string
+	)
+	cmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Seed the data directory of a new cluster member from a backup",
+		Long: "Seed the data directory of a new cluster member from a backup.\n\n" +
+			"Run it on every member of the new cluster with the same backup and peer urls,\n" +
+			"then start chat-server on all of them with the restored data directory.",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if len(localURL) == 0 {
+				return errors.New("url must not be empty")
+			}
+			if len(peerURLs) == 0 {
+				peerURLs = []string{localURL}
+			}
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			b, dec, err := backup.NewDecoder(f)
+			if err != nil {
+				return fmt.Errorf("failed to decode backup: %v", err)
+			}
+			var db io.Reader
+			if b.DBSize > 0 {
+				db = dec
+			}
+			if err := raftnode.Restore(zap.NewNop(), dataDir, localURL, peerURLs, b.Index, b.Term, b.Data, db); err != nil {
+				return err
+			}
+			blobs, err := blob.Open(filepath.Join(dataDir, "blobs"))
+			if err != nil {
+				return err
+			}
+			for {
+				bl, err := dec.DecodeBlob()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return fmt.Errorf("failed to decode attachment of backup: %v", err)
+				}
+				if _, _, err := blobs.Put(bytes.NewReader(bl.Data), int64(len(bl.Data)), bl.ID); err != nil {
+					return fmt.Errorf("failed to restore attachment %s: %v", bl.ID, err)
+				}
+			}
+			cmd.Printf("restored backup at index %d with %d attachments into %s for cluster %s\n",
+				b.Index, b.Blobs, dataDir, strings.Join(peerURLs, ","))
+			if len(b.MissingBlobs) > 0 {
+				cmd.Printf("warning: %d attachments were missing when the backup was taken and can't be downloaded: %s\n",
+					len(b.MissingBlobs), strings.Join(b.MissingBlobs, ","))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&file, "file", "", "Path of the backup file")
+	cmd.Flags().StringVar(&dataDir, "data-dir", "/tmp/groupchat", "Data directory of the new member")
+	cmd.Flags().StringVar(&localURL, "url", "", "URL of the new member, e.g. http://10.0.0.1:8080")
+	cmd.Flags().StringSliceVar(&peerURLs, "peers", nil, "URLs of all members of the new cluster, defaults to the url of the new member")
+	cmd.MarkFlagRequired("file")
+	cmd.MarkFlagRequired("url")
+	return cmd
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+)
+
+type member struct {
+	ID  uint64 `json:"id"`
+	URL string `json:"url"`
+}
+
+type memberStatus struct {
+	ID            uint64 `json:"id"`
+	Leader        uint64 `json:"leader"`
+	State         string `json:"state"`
+	Term          uint64 `json:"term"`
+	CommitIndex   uint64 `json:"commitIndex"`
+	AppliedIndex  uint64 `json:"appliedIndex"`
+	SnapshotIndex uint64 `json:"snapshotIndex"`
+}
+
+// adminToken authorizes the requests which manage the cluster, it is given
+// by the global flag --admin-token.
+var adminToken string
+
+// newAdminRequest returns a request which carries the admin token if it is
+// given.
+func newAdminRequest(method, reqURL string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, reqURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if len(adminToken) > 0 {
+		req.Header.Set("X-Groupchat-Admin-Token", adminToken)
+	}
+	return req, nil
+}
+
+// doJSON sends a request with the optional json body and decodes the json
+// response into out if it is not nil.
+func doJSON(method, reqURL string, body interface{}, out interface{}) error {
+	return doJSONWithToken(method, reqURL, "", body, out)
+}
+
+// doJSONWithToken is like doJSON, the request is authenticated by the user
+// token if it is not empty.
+func doJSONWithToken(method, reqURL, token string, body interface{}, out interface{}) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := newAdminRequest(method, reqURL, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+func listMembers(baseURL string) ([]member, error) {
+	var members []member
+	if err := doJSON(http.MethodGet, baseURL+"/cluster/members", nil, &members); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func newCmdClusterBootstrap() *cobra.Command {
+	var ips []string
+	cmd := &cobra.Command{
+		Use:   "bootstrap",
+		Short: "Bootstrap a new cluster with the given node ips",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if len(ips) == 0 {
+				return errors.New("ips must not be empty")
+			}
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			if err := doJSON(http.MethodPost, baseURL+"/updateCluster", ips, nil); err != nil {
+				return err
+			}
+			cmd.Println("cluster is bootstrapping")
+			return nil
+		},
+	}
+	cmd.Flags().StringSliceVar(&ips, "ips", nil, "IPs of all nodes in the cluster")
+	cmd.MarkFlagRequired("ips")
+	return cmd
+}
+
+func newCmdClusterStatus() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "Show raft status of every node in the cluster",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			members, err := listMembers(baseURL)
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tURL\tSTATE\tLEADER\tTERM\tCOMMIT\tAPPLIED\tSNAPSHOT\tERROR")
+			for _, m := range members {
+				var st memberStatus
+				if err := doJSON(http.MethodGet, m.URL+"/cluster/status", nil, &st); err != nil {
+					fmt.Fprintf(w, "%d\t%s\t-\t-\t-\t-\t-\t-\t%v\n", m.ID, m.URL, err)
+					continue
+				}
+				fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%d\t%d\t%d\t%d\t\n", m.ID, m.URL, st.State,
+					st.Leader, st.Term, st.CommitIndex, st.AppliedIndex, st.SnapshotIndex)
+			}
+			return w.Flush()
+		},
+	}
+}
+
+func newCmdClusterMembers() *cobra.Command {
+	return &cobra.Command{
+		Use:   "members",
+		Short: "List members of the cluster",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			members, err := listMembers(baseURL)
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tURL")
+			for _, m := range members {
+				fmt.Fprintf(w, "%d\t%s\n", m.ID, m.URL)
+			}
+			return w.Flush()
+		},
+	}
+}
+
+func newCmdClusterAddNode() *cobra.Command {
+	var nodeURL string
+	cmd := &cobra.Command{
+		Use:   "add-node",
+		Short: "Add a new node to the cluster and start it",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if len(nodeURL) == 0 {
+				return errors.New("node url must not be empty")
+			}
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			var added struct {
+				ID      uint64   `json:"id"`
+				Members []member `json:"members"`
+			}
+			body := map[string]string{"url": nodeURL}
+			if err := doJSON(http.MethodPost, baseURL+"/cluster/members", body, &added); err != nil {
+				return err
+			}
+			cmd.Printf("added member %d with url %s\n", added.ID, nodeURL)
+			if err := doJSON(http.MethodPost, nodeURL+"/joinCluster", &added, nil); err != nil {
+				return fmt.Errorf("failed to start new node: %v", err)
+			}
+			cmd.Println("new node is joining the cluster")
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&nodeURL, "url", "", "URL of the new node, e.g. http://10.0.0.4:8080")
+	cmd.MarkFlagRequired("url")
+	return cmd
+}
+
+func newCmdClusterRemoveNode() *cobra.Command {
+	var id uint64
+	cmd := &cobra.Command{
+		Use:   "remove-node",
+		Short: "Remove a node from the cluster",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			reqURL := fmt.Sprintf("%s/cluster/members/%d", baseURL, id)
+			if err := doJSON(http.MethodDelete, reqURL, nil, nil); err != nil {
+				return err
+			}
+			cmd.Printf("removed member %d\n", id)
+			return nil
+		},
+	}
+	cmd.Flags().Uint64Var(&id, "id", 0, "ID of the node to remove")
+	cmd.MarkFlagRequired("id")
+	return cmd
+}
+
+func newCmdClusterTransferLeader() *cobra.Command {
+	var id uint64
+	cmd := &cobra.Command{
+		Use:   "transfer-leader",
+		Short: "Transfer the leadership to another node",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			reqURL := fmt.Sprintf("%s/cluster/leader/%d", baseURL, id)
+			if err := doJSON(http.MethodPut, reqURL, nil, nil); err != nil {
+				return err
+			}
+			cmd.Printf("leadership is transferred to member %d\n", id)
+			return nil
+		},
+	}
+	cmd.Flags().Uint64Var(&id, "id", 0, "ID of the new leader")
+	cmd.MarkFlagRequired("id")
+	return cmd
+}
+
+func newCmdClusterSnapshot() *cobra.Command {
+	return &cobra.Command{
+		Use:   "snapshot",
+		Short: "Create a raft snapshot on the node and compact its log",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			req, err := newAdminRequest(http.MethodPost, baseURL+"/cluster/snapshot", nil)
+			if err != nil {
+				return err
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return err
+			}
+			return printResp(cmd, resp)
+		},
+	}
+}
+
+func newCmdCluster() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cluster",
+		Short: "Operate the raft cluster",
+	}
+	cmd.AddCommand(newCmdClusterBootstrap())
+	cmd.AddCommand(newCmdClusterStatus())
+	cmd.AddCommand(newCmdClusterMembers())
+	cmd.AddCommand(newCmdClusterAddNode())
+	cmd.AddCommand(newCmdClusterRemoveNode())
+	cmd.AddCommand(newCmdClusterTransferLeader())
+	cmd.AddCommand(newCmdClusterSnapshot())
+	return cmd
+}
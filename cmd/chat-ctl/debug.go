@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+	"go.etcd.io/etcd/server/v3/etcdserver/api/snap"
+	"go.etcd.io/etcd/server/v3/wal"
+	"go.etcd.io/etcd/server/v3/wal/walpb"
+	"go.uber.org/zap"
+
+	"github.com/gozssky/groupchat/pkg/metadata"
+	"github.com/gozssky/groupchat/pkg/raftnode"
+	"github.com/gozssky/groupchat/pkg/storage"
+)
+
+type debugEntry struct {
+	Index      uint64                       `json:"index"`
+	Term       uint64                       `json:"term"`
+	Type       string                       `json:"type"`
+	Command    *storage.InternalRaftCommand `json:"command,omitempty"`
+	ConfChange *raftpb.ConfChange           `json:"confChange,omitempty"`
+	Error      string                       `json:"error,omitempty"`
+}
+
+func decodeEntry(entry raftpb.Entry) debugEntry {
+	de := debugEntry{
+		Index: entry.Index,
+		Term:  entry.Term,
+		Type:  entry.Type.String(),
+	}
+	switch entry.Type {
+	case raftpb.EntryNormal:
+		if len(entry.Data) == 0 {
+			break
+		}
+		var cmd storage.InternalRaftCommand
+		if err := cmd.UnmarshalGOB(entry.Data); err != nil {
+			de.Error = err.Error()
+			break
+		}
+		de.Command = &cmd
+	case raftpb.EntryConfChange:
+		var cc raftpb.ConfChange
+		if err := cc.Unmarshal(entry.Data); err != nil {
+			de.Error = err.Error()
+			break
+		}
+		de.ConfChange = &cc
+	}
+	return de
+}
+
+func printJSON(cmd *cobra.Command, v interface{}) error {
+	enc := json.NewEncoder(cmd.OutOrStdout())
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func newCmdDebugWAL() *cobra.Command {
+	var (
+		dataDir    string
+		startIndex uint64
+	)
+	cmd := &cobra.Command{
+		Use:   "wal",
+		Short: "Dump metadata, hard state and entries of the WAL",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			walDir := filepath.Join(dataDir, "wal")
+			if !wal.Exist(walDir) {
+				return errors.New("wal not exists in " + walDir)
+			}
+			w, err := wal.OpenForRead(zap.NewNop(), walDir, walpb.Snapshot{})
+			if err != nil {
+				return err
+			}
+			defer w.Close()
+			rawMetadata, st, ents, err := w.ReadAll()
+			if err != nil {
+				return err
+			}
+			var md metadata.Metadata
+			if err := json.Unmarshal(rawMetadata, &md); err != nil {
+				return err
+			}
+			entries := make([]debugEntry, 0, len(ents))
+			for _, entry := range ents {
+				if entry.Index >= startIndex {
+					entries = append(entries, decodeEntry(entry))
+				}
+			}
+			return printJSON(cmd, map[string]interface{}{
+				"metadata":  md,
+				"hardState": st,
+				"entries":   entries,
+			})
+		},
+	}
+	cmd.Flags().StringVar(&dataDir, "data-dir", "/tmp/groupchat", "Data directory of the chat server")
+	cmd.Flags().Uint64Var(&startIndex, "start-index", 0, "Only dump entries whose index is not less than it")
+	return cmd
+}
+
+func newCmdDebugSnapshot() *cobra.Command {
+	var dataDir string
+	cmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Dump the latest snapshot as JSON",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			snapDir := filepath.Join(dataDir, "snap")
+			entries, err := os.ReadDir(snapDir)
+			if err != nil {
+				return err
+			}
+			var names []string
+			for _, entry := range entries {
+				if strings.HasSuffix(entry.Name(), ".snap") {
+					names = append(names, entry.Name())
+				}
+			}
+			if len(names) == 0 {
+				return snap.ErrNoSnapshot
+			}
+			// Snapshot files are named by term and index, so the last one
+			// in lexical order is the latest.
+			sort.Strings(names)
+			raftSnap, err := snap.Read(zap.NewNop(), filepath.Join(snapDir, names[len(names)-1]))
+			if err != nil {
+				return err
+			}
+			members, data, err := raftnode.DecodeSnapshotData(raftSnap.Data)
+			if err != nil {
+				return err
+			}
+			// Snapshots of old versions carry the state machine, the others
+			// are saved with a bbolt database if the memory backend is used,
+			// or the state is only persisted by the bolt backend.
+			var snapshot *storage.Snapshot
+			dbPath := filepath.Join(snapDir, fmt.Sprintf("%016x.snap.db", raftSnap.Metadata.Index))
+			if len(data) > 0 {
+				if snapshot, err = storage.DecodeSnapshot(data); err != nil {
+					return err
+				}
+			} else if _, err := os.Stat(dbPath); err == nil {
+				backend, err := storage.OpenBackend(storage.BackendMemory, dataDir)
+				if err != nil {
+					return err
+				}
+				st := storage.NewStorage()
+				if err := backend.Restore(st, dbPath); err != nil {
+					return err
+				}
+				snapshot = &st.Snapshot
+			}
+			return printJSON(cmd, map[string]interface{}{
+				"metadata": raftSnap.Metadata,
+				"members":  members,
+				"storage":  snapshot,
+			})
+		},
+	}
+	cmd.Flags().StringVar(&dataDir, "data-dir", "/tmp/groupchat", "Data directory of the chat server")
+	return cmd
+}
+
+func newCmdDebug() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "debug",
+		Short: "Inspect the data directory of a stopped chat server",
+	}
+	cmd.AddCommand(newCmdDebugWAL())
+	cmd.AddCommand(newCmdDebugSnapshot())
+	return cmd
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func newCmdDirectSend() *cobra.Command {
+	var (
+		to    []string
+		id    string
+		text  string
+		token string
+	)
+	cmd := &cobra.Command{
+		Use:   "send",
+		Short: "Send a direct message to one or more users",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			body, err := json.Marshal(map[string]string{"id": id, "text": text})
+			if err != nil {
+				return err
+			}
+			reqURL := fmt.Sprintf("%s/dm/%s", baseURL, url.PathEscape(strings.Join(to, ",")))
+			return sendWithToken(cmd, http.MethodPost, reqURL, token, bytes.NewReader(body))
+		},
+	}
+	cmd.Flags().StringSliceVar(&to, "to", nil, "Names of the other participants")
+	cmd.Flags().StringVar(&id, "id", "", "Message id")
+	cmd.Flags().StringVar(&text, "text", "", "message text")
+	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token")
+	cmd.MarkFlagRequired("to")
+	cmd.MarkFlagRequired("text")
+	cmd.MarkFlagRequired("token")
+	return cmd
+}
+
+func newCmdDirectRetrieve() *cobra.Command {
+	var (
+		with      []string
+		pageIndex int
+		pageSize  int
+		token     string
+	)
+	cmd := &cobra.Command{
+		Use:   "retrieve",
+		Short: "Retrieve messages of a direct conversation",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			reqURL := fmt.Sprintf("%s/dm/%s/messages?pageIndex=%d&pageSize=%d",
+				baseURL, url.PathEscape(strings.Join(with, ",")), pageIndex, pageSize)
+			return sendWithToken(cmd, http.MethodGet, reqURL, token, nil)
+		},
+	}
+	cmd.Flags().StringSliceVar(&with, "with", nil, "Names of the other participants")
+	cmd.Flags().IntVar(&pageIndex, "page-index", -1, "The index of page, negative values count from the latest page")
+	cmd.Flags().IntVar(&pageSize, "page-size", 10, "The size of per page")
+	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token")
+	cmd.MarkFlagRequired("with")
+	cmd.MarkFlagRequired("token")
+	return cmd
+}
+
+func newCmdDirect() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dm",
+		Short: "Send and retrieve direct messages",
+	}
+	cmd.AddCommand(newCmdDirectSend())
+	cmd.AddCommand(newCmdDirectRetrieve())
+	return cmd
+}
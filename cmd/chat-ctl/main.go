@@ -140,6 +140,90 @@ func newCmdUserLogin() *cobra.Command {
 	return cmd
 }
 
+func newCmdUserUpdate() *cobra.Command {
+	var (
+		firstname   string
+		lastname    string
+		email       string
+		phone       string
+		oldPassword string
+		newPassword string
+		token       string
+	)
+	cmd := &cobra.Command{
+		Use:   "update",
+		Short: "Update profile or password of the user",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			// Only send the fields given in the command line.
+			infos := make(map[string]string)
+			for flag, field := range map[string]string{
+				"firstname": "firstName",
+				"lastname":  "lastName",
+				"email":     "email",
+				"phone":     "phone",
+			} {
+				if cmd.Flags().Changed(flag) {
+					infos[field] = cmd.Flags().Lookup(flag).Value.String()
+				}
+			}
+			if len(infos) > 0 {
+				data, err := json.Marshal(&infos)
+				if err != nil {
+					return err
+				}
+				if err := sendWithToken(cmd, http.MethodPut, baseURL+"/user", token, bytes.NewReader(data)); err != nil {
+					return err
+				}
+			}
+			if len(newPassword) > 0 {
+				data, err := json.Marshal(map[string]string{
+					"oldPassword": oldPassword,
+					"newPassword": newPassword,
+				})
+				if err != nil {
+					return err
+				}
+				// The response contains a new token, the old ones are revoked.
+				if err := sendWithToken(cmd, http.MethodPut, baseURL+"/user/password", token, bytes.NewReader(data)); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&firstname, "firstname", "", "User's firstname")
+	cmd.Flags().StringVar(&lastname, "lastname", "", "User's lastname")
+	cmd.Flags().StringVar(&email, "email", "", "User's email")
+	cmd.Flags().StringVar(&phone, "phone", "", "User's phone number")
+	cmd.Flags().StringVar(&oldPassword, "old-password", "", "User's current password, required to change password")
+	cmd.Flags().StringVar(&newPassword, "new-password", "", "User's new password")
+	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token")
+	cmd.MarkFlagRequired("token")
+	return cmd
+}
+
+func newCmdUserDelete() *cobra.Command {
+	var token string
+	cmd := &cobra.Command{
+		Use:   "delete",
+		Short: "Delete the user and revoke all its tokens",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			return sendWithToken(cmd, http.MethodDelete, baseURL+"/user", token, nil)
+		},
+	}
+	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token")
+	cmd.MarkFlagRequired("token")
+	return cmd
+}
+
 func newCmdUser() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "user",
@@ -148,13 +232,16 @@ func newCmdUser() *cobra.Command {
 	cmd.AddCommand(newCmdUserCreate())
 	cmd.AddCommand(newCmdUserQuery())
 	cmd.AddCommand(newCmdUserLogin())
+	cmd.AddCommand(newCmdUserUpdate())
+	cmd.AddCommand(newCmdUserDelete())
 	return cmd
 }
 
 func newCmdRoomCreate() *cobra.Command {
 	var (
-		name  string
-		token string
+		name    string
+		private bool
+		token   string
 	)
 	cmd := &cobra.Command{
 		Use:   "create",
@@ -168,8 +255,11 @@ func newCmdRoomCreate() *cobra.Command {
 				return nil
 			}
 			reqURL := baseURL + "/room"
-			body := fmt.Sprintf("{\"name\":\"%s\"}", name)
-			req, err := http.NewRequest(http.MethodPost, reqURL, strings.NewReader(body))
+			body, err := json.Marshal(map[string]interface{}{"name": name, "private": private})
+			if err != nil {
+				return err
+			}
+			req, err := http.NewRequest(http.MethodPost, reqURL, bytes.NewReader(body))
 			if err != nil {
 				return err
 			}
@@ -182,6 +272,7 @@ func newCmdRoomCreate() *cobra.Command {
 		},
 	}
 	cmd.Flags().StringVar(&name, "name", "", "The name of room")
+	cmd.Flags().BoolVar(&private, "private", false, "Create a private room which can only be joined by invitation")
 	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token")
 	cmd.MarkFlagRequired("name")
 	cmd.MarkFlagRequired("token")
@@ -189,7 +280,10 @@ func newCmdRoomCreate() *cobra.Command {
 }
 
 func newCmdRoomQuery() *cobra.Command {
-	var roomID string
+	var (
+		roomID string
+		token  string
+	)
 	cmd := &cobra.Command{
 		Use:   "query",
 		Short: "Query information of a room",
@@ -202,14 +296,11 @@ func newCmdRoomQuery() *cobra.Command {
 				return nil
 			}
 			reqURL := fmt.Sprintf("%s/room/%s", baseURL, roomID)
-			resp, err := http.Get(reqURL)
-			if err != nil {
-				return err
-			}
-			return printResp(cmd, resp)
+			return sendWithToken(cmd, http.MethodGet, reqURL, token, nil)
 		},
 	}
 	cmd.Flags().StringVar(&roomID, "id", "", "The id of room")
+	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token, required for private rooms")
 	cmd.MarkFlagRequired("id")
 	return cmd
 }
@@ -218,6 +309,7 @@ func newCmdRoomList() *cobra.Command {
 	var (
 		pageIndex int
 		pageSize  int
+		token     string
 	)
 	cmd := &cobra.Command{
 		Use:   "list",
@@ -229,24 +321,20 @@ func newCmdRoomList() *cobra.Command {
 			}
 			reqURL := baseURL + "/roomList"
 			body := fmt.Sprintf("{\"pageIndex\":%d,\"pageSize\":%d}", pageIndex, pageSize)
-			req, err := http.NewRequest(http.MethodPost, reqURL, strings.NewReader(body))
-			if err != nil {
-				return err
-			}
-			resp, err := http.DefaultClient.Do(req)
-			if err != nil {
-				return err
-			}
-			return printResp(cmd, resp)
+			return sendWithToken(cmd, http.MethodPost, reqURL, token, strings.NewReader(body))
 		},
 	}
 	cmd.Flags().IntVar(&pageIndex, "page-index", 0, "The index of page")
 	cmd.Flags().IntVar(&pageSize, "page-size", 10, "The size of per page")
+	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token, private rooms are listed for members")
 	return cmd
 }
 
 func newCmdRoomListUsers() *cobra.Command {
-	var roomID string
+	var (
+		roomID string
+		token  string
+	)
 	cmd := &cobra.Command{
 		Use:   "list-users",
 		Short: "List all users of a room",
@@ -259,14 +347,11 @@ func newCmdRoomListUsers() *cobra.Command {
 				return nil
 			}
 			reqURL := fmt.Sprintf("%s/room/%s/users", baseURL, roomID)
-			resp, err := http.Get(reqURL)
-			if err != nil {
-				return err
-			}
-			return printResp(cmd, resp)
+			return sendWithToken(cmd, http.MethodGet, reqURL, token, nil)
 		},
 	}
 	cmd.Flags().StringVar(&roomID, "id", "", "The id of room")
+	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token, required for private rooms")
 	cmd.MarkFlagRequired("id")
 	return cmd
 }
@@ -308,16 +393,22 @@ func newCmdRoomEnter() *cobra.Command {
 }
 
 func newCmdRoomLeave() *cobra.Command {
-	var token string
+	var (
+		roomID string
+		token  string
+	)
 	cmd := &cobra.Command{
 		Use:   "leave",
-		Short: "Leave a room",
+		Short: "Leave a room, defaults to the current room",
 		RunE: func(cmd *cobra.Command, _ []string) error {
 			baseURL, err := verifyBaseURL()
 			if err != nil {
 				return nil
 			}
 			reqURL := baseURL + "/roomLeave"
+			if len(roomID) > 0 {
+				reqURL = fmt.Sprintf("%s/room/%s/leave", baseURL, roomID)
+			}
 			req, err := http.NewRequest(http.MethodPut, reqURL, nil)
 			if err != nil {
 				return err
@@ -330,6 +421,210 @@ func newCmdRoomLeave() *cobra.Command {
 			return printResp(cmd, resp)
 		},
 	}
+	cmd.Flags().StringVar(&roomID, "id", "", "The id of room")
+	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token")
+	cmd.MarkFlagRequired("token")
+	return cmd
+}
+
+func newCmdRoomJoined() *cobra.Command {
+	var token string
+	cmd := &cobra.Command{
+		Use:   "joined",
+		Short: "List the rooms the user is a member of",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			return sendWithToken(cmd, http.MethodGet, baseURL+"/me/rooms", token, nil)
+		},
+	}
+	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token")
+	cmd.MarkFlagRequired("token")
+	return cmd
+}
+
+// sendWithToken sends a request authenticated by the token and prints the
+// response, the request is anonymous if the token is empty. The admin token
+// is sent as well if it is set.
+func sendWithToken(cmd *cobra.Command, method, reqURL, token string, body io.Reader) error {
+	req, err := newAdminRequest(method, reqURL, body)
+	if err != nil {
+		return err
+	}
+	if len(token) > 0 {
+		req.Header.Add("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	return printResp(cmd, resp)
+}
+
+func newCmdRoomRename() *cobra.Command {
+	var (
+		roomID string
+		name   string
+		token  string
+	)
+	cmd := &cobra.Command{
+		Use:   "rename",
+		Short: "Rename a room",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if len(name) == 0 {
+				return errors.New("room name must not be empty")
+			}
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			body, err := json.Marshal(map[string]string{"name": name})
+			if err != nil {
+				return err
+			}
+			reqURL := fmt.Sprintf("%s/room/%s/name", baseURL, roomID)
+			return sendWithToken(cmd, http.MethodPut, reqURL, token, bytes.NewReader(body))
+		},
+	}
+	cmd.Flags().StringVar(&roomID, "id", "", "The id of room")
+	cmd.Flags().StringVar(&name, "name", "", "The new name of room")
+	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token")
+	cmd.MarkFlagRequired("id")
+	cmd.MarkFlagRequired("name")
+	cmd.MarkFlagRequired("token")
+	return cmd
+}
+
+// newCmdRoomAction returns a command which sends a request without body to
+// the path under the room.
+func newCmdRoomAction(use, short, method, path string) *cobra.Command {
+	var (
+		roomID string
+		token  string
+	)
+	cmd := &cobra.Command{
+		Use:   use,
+		Short: short,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			reqURL := fmt.Sprintf("%s/room/%s%s", baseURL, roomID, path)
+			return sendWithToken(cmd, method, reqURL, token, nil)
+		},
+	}
+	cmd.Flags().StringVar(&roomID, "id", "", "The id of room")
+	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token")
+	cmd.MarkFlagRequired("id")
+	cmd.MarkFlagRequired("token")
+	return cmd
+}
+
+func newCmdRoomVisibility() *cobra.Command {
+	var (
+		roomID  string
+		private bool
+		token   string
+	)
+	cmd := &cobra.Command{
+		Use:   "visibility",
+		Short: "Make a room private or public, only the owner can do it",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			body, err := json.Marshal(map[string]bool{"private": private})
+			if err != nil {
+				return err
+			}
+			reqURL := fmt.Sprintf("%s/room/%s/visibility", baseURL, roomID)
+			return sendWithToken(cmd, http.MethodPut, reqURL, token, bytes.NewReader(body))
+		},
+	}
+	cmd.Flags().StringVar(&roomID, "id", "", "The id of room")
+	cmd.Flags().BoolVar(&private, "private", false, "Whether the room is private")
+	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token")
+	cmd.MarkFlagRequired("id")
+	cmd.MarkFlagRequired("token")
+	return cmd
+}
+
+func newCmdRoomInvite() *cobra.Command {
+	var (
+		roomID   string
+		username string
+		token    string
+	)
+	cmd := &cobra.Command{
+		Use:   "invite",
+		Short: "Invite a user to a private room",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			body, err := json.Marshal(map[string]string{"username": username})
+			if err != nil {
+				return err
+			}
+			reqURL := fmt.Sprintf("%s/room/%s/invites", baseURL, roomID)
+			return sendWithToken(cmd, http.MethodPost, reqURL, token, bytes.NewReader(body))
+		},
+	}
+	cmd.Flags().StringVar(&roomID, "id", "", "The id of room")
+	cmd.Flags().StringVar(&username, "username", "", "Name of the invited user")
+	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token")
+	cmd.MarkFlagRequired("id")
+	cmd.MarkFlagRequired("username")
+	cmd.MarkFlagRequired("token")
+	return cmd
+}
+
+// newCmdRoomAnswer returns a command to approve or reject a join request.
+func newCmdRoomAnswer(use, short, method string) *cobra.Command {
+	var (
+		roomID   string
+		username string
+		token    string
+	)
+	cmd := &cobra.Command{
+		Use:   use,
+		Short: short,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			reqURL := fmt.Sprintf("%s/room/%s/requests/%s", baseURL, roomID, url.PathEscape(username))
+			return sendWithToken(cmd, method, reqURL, token, nil)
+		},
+	}
+	cmd.Flags().StringVar(&roomID, "id", "", "The id of room")
+	cmd.Flags().StringVar(&username, "username", "", "Name of the requesting user")
+	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token")
+	cmd.MarkFlagRequired("id")
+	cmd.MarkFlagRequired("username")
+	cmd.MarkFlagRequired("token")
+	return cmd
+}
+
+func newCmdRoomMyInvites() *cobra.Command {
+	var token string
+	cmd := &cobra.Command{
+		Use:   "my-invites",
+		Short: "List the private rooms the user is invited to",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			return sendWithToken(cmd, http.MethodGet, baseURL+"/me/invites", token, nil)
+		},
+	}
 	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token")
 	cmd.MarkFlagRequired("token")
 	return cmd
@@ -346,14 +641,34 @@ func newCmdRoom() *cobra.Command {
 	cmd.AddCommand(newCmdRoomListUsers())
 	cmd.AddCommand(newCmdRoomEnter())
 	cmd.AddCommand(newCmdRoomLeave())
+	cmd.AddCommand(newCmdRoomAction("join", "Join a room without leaving the current room", http.MethodPut, "/join"))
+	cmd.AddCommand(newCmdRoomJoined())
+	cmd.AddCommand(newCmdRoomVisibility())
+	cmd.AddCommand(newCmdRoomInvite())
+	cmd.AddCommand(newCmdRoomAction("invites", "List users invited to a private room", http.MethodGet, "/invites"))
+	cmd.AddCommand(newCmdRoomMyInvites())
+	cmd.AddCommand(newCmdRoomAction("request", "Request to join a private room", http.MethodPost, "/requests"))
+	cmd.AddCommand(newCmdRoomAction("requests", "List join requests of a private room, only the owner can do it", http.MethodGet, "/requests"))
+	cmd.AddCommand(newCmdRoomAnswer("approve", "Approve a join request of a private room", http.MethodPut))
+	cmd.AddCommand(newCmdRoomAnswer("reject", "Reject a join request of a private room", http.MethodDelete))
+	cmd.AddCommand(newCmdRoomRename())
+	cmd.AddCommand(newCmdRoomAction("archive", "Archive a room, it becomes read-only and hidden from the room list", http.MethodPut, "/archive"))
+	cmd.AddCommand(newCmdRoomAction("unarchive", "Restore an archived room", http.MethodDelete, "/archive"))
+	cmd.AddCommand(newCmdRoomAction("delete", "Delete a room with all its messages, only the owner or an admin can do it", http.MethodDelete, ""))
+	addCmdRoomModeration(cmd)
+	addCmdRoomReceipts(cmd)
+	addCmdRoomPresence(cmd)
 	return cmd
 }
 
 func newCmdMessageSend() *cobra.Command {
 	var (
-		id    string
-		text  string
-		token string
+		id          string
+		text        string
+		roomID      int
+		replyTo     string
+		attachments []string
+		token       string
 	)
 	cmd := &cobra.Command{
 		Use:   "send",
@@ -364,8 +679,13 @@ func newCmdMessageSend() *cobra.Command {
 				return nil
 			}
 			reqURL := baseURL + "/message/send"
-			body := fmt.Sprintf("{\"id\":\"%s\",\"text\":\"%s\"}", id, text)
-			req, err := http.NewRequest(http.MethodPost, reqURL, strings.NewReader(body))
+			body, err := json.Marshal(map[string]interface{}{
+				"id": id, "text": text, "roomId": roomID, "replyTo": replyTo, "attachments": attachments,
+			})
+			if err != nil {
+				return err
+			}
+			req, err := http.NewRequest(http.MethodPost, reqURL, bytes.NewReader(body))
 			if err != nil {
 				return err
 			}
@@ -379,6 +699,9 @@ func newCmdMessageSend() *cobra.Command {
 	}
 	cmd.Flags().StringVar(&id, "id", "", "Message id")
 	cmd.Flags().StringVar(&text, "text", "", "message text")
+	cmd.Flags().IntVar(&roomID, "room", 0, "The id of room, defaults to the current room")
+	cmd.Flags().StringVar(&replyTo, "reply-to", "", "The id of the message to reply to")
+	cmd.Flags().StringSliceVar(&attachments, "attachment", nil, "The ids of uploaded attachments")
 	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token")
 	cmd.MarkFlagRequired("id")
 	cmd.MarkFlagRequired("text")
@@ -390,6 +713,7 @@ func newCmdMessageRetrieve() *cobra.Command {
 	var (
 		pageIndex int
 		pageSize  int
+		roomID    int
 		token     string
 	)
 	cmd := &cobra.Command{
@@ -401,7 +725,7 @@ func newCmdMessageRetrieve() *cobra.Command {
 				return nil
 			}
 			reqURL := baseURL + "/message/retrieve"
-			body := fmt.Sprintf("{\"pageIndex\":%d,\"pageSize\":%d}", pageIndex, pageSize)
+			body := fmt.Sprintf("{\"pageIndex\":%d,\"pageSize\":%d,\"roomId\":%d}", pageIndex, pageSize, roomID)
 			req, err := http.NewRequest(http.MethodPost, reqURL, strings.NewReader(body))
 			if err != nil {
 				return err
@@ -416,6 +740,7 @@ func newCmdMessageRetrieve() *cobra.Command {
 	}
 	cmd.Flags().IntVar(&pageIndex, "page-index", 0, "The index of page")
 	cmd.Flags().IntVar(&pageSize, "page-size", 10, "The size of per page")
+	cmd.Flags().IntVar(&roomID, "room", 0, "The id of room, defaults to the current room")
 	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token")
 	cmd.MarkFlagRequired("token")
 	return cmd
@@ -424,10 +749,19 @@ func newCmdMessageRetrieve() *cobra.Command {
 func newCmdMessage() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "message",
-		Short: "Send and retrieve messages",
+		Short: "Send, retrieve and edit messages",
 	}
 	cmd.AddCommand(newCmdMessageSend())
 	cmd.AddCommand(newCmdMessageRetrieve())
+	cmd.AddCommand(newCmdMessageEdit())
+	cmd.AddCommand(newCmdMessageAction("delete", "Delete a message, only the author and moderators can do it", http.MethodDelete, ""))
+	cmd.AddCommand(newCmdMessageAction("history", "Show the edit history of a message", http.MethodGet, "/revisions"))
+	cmd.AddCommand(newCmdMessageReplies())
+	cmd.AddCommand(newCmdMessageReaction("react", "React to a message with an emoji", http.MethodPut))
+	cmd.AddCommand(newCmdMessageReaction("unreact", "Remove a reaction from a message", http.MethodDelete))
+	cmd.AddCommand(newCmdMessageWatch())
+	cmd.AddCommand(newCmdMessageMentions())
+	cmd.AddCommand(newCmdMessageSearch())
 	return cmd
 }
 
@@ -450,7 +784,16 @@ func main() {
 	cmd.AddCommand(newCmdUser())
 	cmd.AddCommand(newCmdRoom())
 	cmd.AddCommand(newCmdMessage())
+	cmd.AddCommand(newCmdDirect())
+	cmd.AddCommand(newCmdCluster())
+	cmd.AddCommand(newCmdDebug())
+	cmd.AddCommand(newCmdBackup())
+	cmd.AddCommand(newCmdRestore())
+	cmd.AddCommand(newCmdRetention())
+	cmd.AddCommand(newCmdAttachment())
 	cmd.PersistentFlags().StringVar(&addr, "addr", "http://127.0.0.1:8080", "Address of server")
+	cmd.PersistentFlags().StringVar(&adminToken, "admin-token", os.Getenv("GROUPCHAT_ADMIN_TOKEN"),
+		"Admin token of the cluster management requests, defaults to $GROUPCHAT_ADMIN_TOKEN")
 	cmd.SetOut(os.Stdout)
 	if err := cmd.Execute(); err != nil {
 		cmd.Println(err)
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/spf13/cobra"
+)
+
+func newCmdMessageMentions() *cobra.Command {
+	var (
+		cursor string
+		limit  int
+		follow bool
+		token  string
+	)
+	cmd := &cobra.Command{
+		Use:   "mentions",
+		Short: "Retrieve messages mentioning the user, latest first",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			if !follow {
+				reqURL := fmt.Sprintf("%s/me/mentions?limit=%d", baseURL, limit)
+				if len(cursor) > 0 {
+					reqURL += "&cursor=" + url.QueryEscape(cursor)
+				}
+				return sendWithToken(cmd, http.MethodGet, reqURL, token, nil)
+			}
+			req, err := http.NewRequest(http.MethodGet, baseURL+"/me/events", nil)
+			if err != nil {
+				return err
+			}
+			req.Header.Add("Authorization", "Bearer "+token)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return printResp(cmd, resp)
+			}
+			_, err = io.Copy(cmd.OutOrStdout(), resp.Body)
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&cursor, "cursor", "", "Retrieve mentions before the cursor")
+	cmd.Flags().IntVar(&limit, "limit", 20, "The max number of mentions")
+	cmd.Flags().BoolVar(&follow, "follow", false, "Print new mentions as they happen")
+	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token")
+	cmd.MarkFlagRequired("token")
+	return cmd
+}
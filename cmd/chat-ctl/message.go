@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/spf13/cobra"
+)
+
+func newCmdMessageEdit() *cobra.Command {
+	var (
+		roomID string
+		id     string
+		text   string
+		token  string
+	)
+	cmd := &cobra.Command{
+		Use:   "edit",
+		Short: "Edit a message, only the author and moderators can do it",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			body, err := json.Marshal(map[string]string{"text": text})
+			if err != nil {
+				return err
+			}
+			reqURL := fmt.Sprintf("%s/room/%s/messages/%s", baseURL, roomID, url.PathEscape(id))
+			return sendWithToken(cmd, http.MethodPut, reqURL, token, bytes.NewReader(body))
+		},
+	}
+	cmd.Flags().StringVar(&roomID, "room", "", "The id of room")
+	cmd.Flags().StringVar(&id, "id", "", "Message id")
+	cmd.Flags().StringVar(&text, "text", "", "New message text")
+	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token")
+	cmd.MarkFlagRequired("room")
+	cmd.MarkFlagRequired("id")
+	cmd.MarkFlagRequired("text")
+	cmd.MarkFlagRequired("token")
+	return cmd
+}
+
+// newCmdMessageAction returns a command which sends a request without body to
+// the path under the message.
+func newCmdMessageAction(use, short, method, path string) *cobra.Command {
+	var (
+		roomID string
+		id     string
+		token  string
+	)
+	cmd := &cobra.Command{
+		Use:   use,
+		Short: short,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			reqURL := fmt.Sprintf("%s/room/%s/messages/%s%s", baseURL, roomID, url.PathEscape(id), path)
+			return sendWithToken(cmd, method, reqURL, token, nil)
+		},
+	}
+	cmd.Flags().StringVar(&roomID, "room", "", "The id of room")
+	cmd.Flags().StringVar(&id, "id", "", "Message id")
+	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token")
+	cmd.MarkFlagRequired("room")
+	cmd.MarkFlagRequired("id")
+	cmd.MarkFlagRequired("token")
+	return cmd
+}
+
+func newCmdMessageWatch() *cobra.Command {
+	var (
+		roomID string
+		token  string
+	)
+	cmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Print events of a room as they happen",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/room/%s/events", baseURL, roomID), nil)
+			if err != nil {
+				return err
+			}
+			req.Header.Add("Authorization", "Bearer "+token)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return printResp(cmd, resp)
+			}
+			_, err = io.Copy(cmd.OutOrStdout(), resp.Body)
+			return err
+		},
+	}
+	cmd.Flags().StringVar(&roomID, "room", "", "The id of room")
+	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token")
+	cmd.MarkFlagRequired("room")
+	cmd.MarkFlagRequired("token")
+	return cmd
+}
+
+func newCmdMessageReplies() *cobra.Command {
+	var (
+		roomID    string
+		id        string
+		pageIndex int
+		pageSize  int
+		token     string
+	)
+	cmd := &cobra.Command{
+		Use:   "replies",
+		Short: "Retrieve replies in the thread of a message",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			reqURL := fmt.Sprintf("%s/room/%s/messages/%s/replies?pageIndex=%d&pageSize=%d",
+				baseURL, roomID, url.PathEscape(id), pageIndex, pageSize)
+			return sendWithToken(cmd, http.MethodGet, reqURL, token, nil)
+		},
+	}
+	cmd.Flags().StringVar(&roomID, "room", "", "The id of room")
+	cmd.Flags().StringVar(&id, "id", "", "The id of the root message")
+	cmd.Flags().IntVar(&pageIndex, "page-index", -1, "The index of page, negative values count from the latest page")
+	cmd.Flags().IntVar(&pageSize, "page-size", 10, "The size of per page")
+	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token")
+	cmd.MarkFlagRequired("room")
+	cmd.MarkFlagRequired("id")
+	cmd.MarkFlagRequired("token")
+	return cmd
+}
+
+// newCmdMessageReaction returns a command to add or remove a reaction.
+func newCmdMessageReaction(use, short, method string) *cobra.Command {
+	var (
+		roomID string
+		id     string
+		emoji  string
+		token  string
+	)
+	cmd := &cobra.Command{
+		Use:   use,
+		Short: short,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			reqURL := fmt.Sprintf("%s/room/%s/messages/%s/reactions/%s",
+				baseURL, roomID, url.PathEscape(id), url.PathEscape(emoji))
+			return sendWithToken(cmd, method, reqURL, token, nil)
+		},
+	}
+	cmd.Flags().StringVar(&roomID, "room", "", "The id of room")
+	cmd.Flags().StringVar(&id, "id", "", "Message id")
+	cmd.Flags().StringVar(&emoji, "emoji", "", "The reaction emoji")
+	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token")
+	cmd.MarkFlagRequired("room")
+	cmd.MarkFlagRequired("id")
+	cmd.MarkFlagRequired("emoji")
+	cmd.MarkFlagRequired("token")
+	return cmd
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/spf13/cobra"
+)
+
+// newCmdRoomModerate returns a command which moderates a user of the room by
+// sending a request to the path under the room followed by the user name.
+// Restrictions accept an optional duration.
+func newCmdRoomModerate(use, short, method, path string, restriction bool) *cobra.Command {
+	var (
+		roomID   string
+		username string
+		duration string
+		token    string
+	)
+	cmd := &cobra.Command{
+		Use:   use,
+		Short: short,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			reqURL := fmt.Sprintf("%s/room/%s%s/%s", baseURL, roomID, path, url.PathEscape(username))
+			if len(duration) > 0 {
+				reqURL += "?duration=" + url.QueryEscape(duration)
+			}
+			return sendWithToken(cmd, method, reqURL, token, nil)
+		},
+	}
+	cmd.Flags().StringVar(&roomID, "id", "", "The id of room")
+	cmd.Flags().StringVar(&username, "username", "", "Name of the moderated user")
+	if restriction {
+		cmd.Flags().StringVar(&duration, "duration", "", "How long the restriction lasts, e.g. 1h, it never expires if not given")
+	}
+	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token")
+	cmd.MarkFlagRequired("id")
+	cmd.MarkFlagRequired("username")
+	cmd.MarkFlagRequired("token")
+	return cmd
+}
+
+func addCmdRoomModeration(cmd *cobra.Command) {
+	cmd.AddCommand(newCmdRoomAction("moderators", "List the owner and moderators of a room", http.MethodGet, "/moderators"))
+	cmd.AddCommand(newCmdRoomModerate("promote", "Make a user a moderator of a room, only the owner can do it", http.MethodPut, "/moderators", false))
+	cmd.AddCommand(newCmdRoomModerate("demote", "Revoke a moderator of a room, only the owner can do it", http.MethodDelete, "/moderators", false))
+	cmd.AddCommand(newCmdRoomModerate("kick", "Remove a user from a room", http.MethodPut, "/kick", false))
+	cmd.AddCommand(newCmdRoomModerate("ban", "Remove a user from a room and keep it from joining again", http.MethodPut, "/bans", true))
+	cmd.AddCommand(newCmdRoomModerate("unban", "Lift the ban of a user", http.MethodDelete, "/bans", false))
+	cmd.AddCommand(newCmdRoomAction("bans", "List users banned from a room", http.MethodGet, "/bans"))
+	cmd.AddCommand(newCmdRoomModerate("mute", "Keep a user from sending messages to a room", http.MethodPut, "/mutes", true))
+	cmd.AddCommand(newCmdRoomModerate("unmute", "Lift the mute of a user", http.MethodDelete, "/mutes", false))
+	cmd.AddCommand(newCmdRoomAction("mutes", "List users muted in a room", http.MethodGet, "/mutes"))
+}
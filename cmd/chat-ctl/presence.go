@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/spf13/cobra"
+)
+
+func newCmdRoomPresence() *cobra.Command {
+	var (
+		roomID string
+		token  string
+	)
+	cmd := &cobra.Command{
+		Use:   "presence",
+		Short: "Show which users of a room are online and typing",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			reqURL := fmt.Sprintf("%s/room/%s/presence", baseURL, roomID)
+			return sendWithToken(cmd, http.MethodGet, reqURL, token, nil)
+		},
+	}
+	cmd.Flags().StringVar(&roomID, "id", "", "The id of room")
+	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token, required for private rooms")
+	cmd.MarkFlagRequired("id")
+	return cmd
+}
+
+func addCmdRoomPresence(cmd *cobra.Command) {
+	cmd.AddCommand(newCmdRoomPresence())
+	cmd.AddCommand(newCmdRoomAction("typing", "Tell the members of a room that the user is typing", http.MethodPost, "/typing"))
+}
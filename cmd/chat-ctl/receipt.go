@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/spf13/cobra"
+)
+
+func newCmdRoomRead() *cobra.Command {
+	var (
+		roomID    string
+		messageID string
+		token     string
+	)
+	cmd := &cobra.Command{
+		Use:   "read",
+		Short: "Mark messages of a room as read, up to the latest one by default",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			var body io.Reader
+			if len(messageID) > 0 {
+				b, err := json.Marshal(map[string]string{"id": messageID})
+				if err != nil {
+					return err
+				}
+				body = bytes.NewReader(b)
+			}
+			reqURL := fmt.Sprintf("%s/room/%s/read", baseURL, roomID)
+			return sendWithToken(cmd, http.MethodPost, reqURL, token, body)
+		},
+	}
+	cmd.Flags().StringVar(&roomID, "id", "", "The id of room")
+	cmd.Flags().StringVar(&messageID, "message", "", "The id of the last read message")
+	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token")
+	cmd.MarkFlagRequired("id")
+	cmd.MarkFlagRequired("token")
+	return cmd
+}
+
+func addCmdRoomReceipts(cmd *cobra.Command) {
+	cmd.AddCommand(newCmdRoomRead())
+	cmd.AddCommand(newCmdRoomAction("receipts", "List the last message read by each member of a room", http.MethodGet, "/read"))
+}
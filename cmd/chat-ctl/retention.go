@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/spf13/cobra"
+)
+
+type retention struct {
+	MaxAge    string `json:"maxAge"`
+	MaxCount  int    `json:"maxCount"`
+	Inherited bool   `json:"inherited,omitempty"`
+}
+
+// retentionURL returns the url of the global retention policy, or the one of
+// the room if roomID is not empty.
+func retentionURL(baseURL string, roomID string) string {
+	if len(roomID) == 0 {
+		return baseURL + "/retention"
+	}
+	return fmt.Sprintf("%s/room/%s/retention", baseURL, roomID)
+}
+
+func newCmdRetentionShow() *cobra.Command {
+	var roomID string
+	cmd := &cobra.Command{
+		Use:   "show",
+		Short: "Show the global retention policy or the one of a room",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			var r retention
+			if err := doJSON(http.MethodGet, retentionURL(baseURL, roomID), nil, &r); err != nil {
+				return err
+			}
+			cmd.Printf("max age: %s\nmax count: %d\n", r.MaxAge, r.MaxCount)
+			if r.Inherited {
+				cmd.Println("inherited from the global retention policy")
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&roomID, "room", "", "The id of room")
+	return cmd
+}
+
+func newCmdRetentionSet() *cobra.Command {
+	var (
+		roomID string
+		r      retention
+		token  string
+	)
+	cmd := &cobra.Command{
+		Use:   "set",
+		Short: "Set the global retention policy or the one of a room",
+		Long: "Set the global retention policy or the one of a room.\n\n" +
+			"The global policy requires the admin token. The policy of a room requires the\n" +
+			"token of its owner or a moderator, or of any user together with the admin token.",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			if err := doJSONWithToken(http.MethodPut, retentionURL(baseURL, roomID), token, &r, nil); err != nil {
+				return err
+			}
+			cmd.Println("retention policy is updated")
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&roomID, "room", "", "The id of room")
+	cmd.Flags().StringVar(&r.MaxAge, "max-age", "", "Drop messages older than it, e.g. 720h, empty means no limit")
+	cmd.Flags().IntVar(&r.MaxCount, "max-count", 0, "Drop the oldest messages beyond it, 0 means no limit")
+	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token, required for the policy of a room")
+	return cmd
+}
+
+func newCmdRetentionClear() *cobra.Command {
+	var (
+		roomID string
+		token  string
+	)
+	cmd := &cobra.Command{
+		Use:   "clear",
+		Short: "Clear the retention policy of a room to follow the global one",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			if err := doJSONWithToken(http.MethodDelete, retentionURL(baseURL, roomID), token, nil, nil); err != nil {
+				return err
+			}
+			cmd.Println("retention policy is cleared")
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&roomID, "room", "", "The id of room")
+	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token")
+	cmd.MarkFlagRequired("room")
+	return cmd
+}
+
+func newCmdRetention() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "retention",
+		Short: "Manage message retention policies",
+	}
+	cmd.AddCommand(newCmdRetentionShow())
+	cmd.AddCommand(newCmdRetentionSet())
+	cmd.AddCommand(newCmdRetentionClear())
+	return cmd
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/spf13/cobra"
+)
+
+func newCmdMessageSearch() *cobra.Command {
+	var (
+		query     string
+		roomID    string
+		author    string
+		from      string
+		before    string
+		pageIndex int
+		pageSize  int
+		token     string
+	)
+	cmd := &cobra.Command{
+		Use:   "search",
+		Short: "Search messages in the rooms the user is a member of",
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			baseURL, err := verifyBaseURL()
+			if err != nil {
+				return err
+			}
+			params := url.Values{}
+			params.Set("q", query)
+			params.Set("pageIndex", fmt.Sprint(pageIndex))
+			params.Set("pageSize", fmt.Sprint(pageSize))
+			if len(roomID) > 0 {
+				params.Set("room", roomID)
+			}
+			if len(author) > 0 {
+				params.Set("author", author)
+			}
+			if len(from) > 0 {
+				params.Set("from", from)
+			}
+			if len(before) > 0 {
+				params.Set("before", before)
+			}
+			reqURL := fmt.Sprintf("%s/search?%s", baseURL, params.Encode())
+			return sendWithToken(cmd, http.MethodGet, reqURL, token, nil)
+		},
+	}
+	cmd.Flags().StringVar(&query, "query", "", "The words to search for")
+	cmd.Flags().StringVar(&roomID, "room", "", "Only search the room with the id")
+	cmd.Flags().StringVar(&author, "author", "", "Only search messages sent by the user")
+	cmd.Flags().StringVar(&from, "from", "", "Only search messages sent at or after the unix timestamp")
+	cmd.Flags().StringVar(&before, "before", "", "Only search messages sent before the unix timestamp")
+	cmd.Flags().IntVar(&pageIndex, "page-index", 0, "The index of page")
+	cmd.Flags().IntVar(&pageSize, "page-size", 10, "The size of per page")
+	cmd.Flags().StringVar(&token, "token", "", "User's authenticated token")
+	cmd.MarkFlagRequired("query")
+	cmd.MarkFlagRequired("token")
+	return cmd
+}
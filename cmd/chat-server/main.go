@@ -3,6 +3,9 @@ package main
 import (
 	_ "net/http/pprof"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 
 	"go.etcd.io/etcd/client/pkg/v3/fileutil"
 	"go.uber.org/zap"
@@ -10,22 +13,128 @@ import (
 	"gopkg.in/alecthomas/kingpin.v2"
 
 	"github.com/gozssky/groupchat/pkg/app"
+	"github.com/gozssky/groupchat/pkg/config"
+	"github.com/gozssky/groupchat/pkg/raftnode"
+	"github.com/gozssky/groupchat/pkg/storage"
 )
 
 var (
-	flagPort     = kingpin.Flag("port", "Port to listen for both client requests and peer raft messages").Default("8080").Int()
-	flagDataDir  = kingpin.Flag("data-dir", "Data directory to store snapshot and WAL logs.").Default("/tmp/groupchat").String()
-	flagLogLevel = kingpin.Flag("log-level", "Log level.").Default("info").Enum("debug", "info", "warn", "error")
+	defaultCfg = config.Default()
+
+	flagConfigFile  = kingpin.Flag("config", "Path of the config file, see --print-config for its format.").String()
+	flagPrintConfig = kingpin.Flag("print-config", "Print the effective config and exit.").Bool()
+
+	flagPort     = kingpin.Flag("port", "Port to listen for both client requests and peer raft messages").Default(strconv.Itoa(defaultCfg.Port)).Int()
+	flagDataDir  = kingpin.Flag("data-dir", "Data directory to store snapshot and WAL logs.").Default(defaultCfg.DataDir).String()
+	flagBackend  = kingpin.Flag("backend", "Storage backend of the state machine.").Default(defaultCfg.Backend).Enum(storage.BackendBolt, storage.BackendMemory)
+	flagLogLevel = kingpin.Flag("log-level", "Log level, can be reloaded from the config file by SIGHUP.").Default(defaultCfg.LogLevel).Enum("debug", "info", "warn", "error")
+
+	flagForceNewCluster = kingpin.Flag("force-new-cluster", "Force to create a new one-member cluster from the existing data dir.").Bool()
+
+	flagClusterID                 = kingpin.Flag("cluster-id", "ID of the raft cluster, must be the same on all members.").Default(strconv.FormatUint(defaultCfg.Raft.ClusterID, 10)).Uint64()
+	flagTickInterval              = kingpin.Flag("tick-interval", "Interval of a raft tick.").Default(defaultCfg.Raft.TickInterval.String()).Duration()
+	flagElectionTick              = kingpin.Flag("election-tick", "Number of ticks without hearing from a leader before starting an election.").Default(strconv.Itoa(defaultCfg.Raft.ElectionTick)).Int()
+	flagHeartbeatTick             = kingpin.Flag("heartbeat-tick", "Number of ticks between heartbeats of a leader.").Default(strconv.Itoa(defaultCfg.Raft.HeartbeatTick)).Int()
+	flagMaxSizePerMsg             = kingpin.Flag("max-size-per-msg", "Max byte size of each raft append message.").Default(strconv.FormatUint(defaultCfg.Raft.MaxSizePerMsg, 10)).Uint64()
+	flagMaxInflightMsgs           = kingpin.Flag("max-inflight-msgs", "Max number of in-flight raft append messages.").Default(strconv.Itoa(defaultCfg.Raft.MaxInflightMsgs)).Int()
+	flagMaxUncommittedEntriesSize = kingpin.Flag("max-uncommitted-entries-size", "Max byte size of uncommitted raft entries of a leader, 0 means no limit.").Default(strconv.FormatUint(defaultCfg.Raft.MaxUncommittedEntriesSize, 10)).Uint64()
+	flagReadOnlyOption            = kingpin.Flag("read-only-option", "How raft serves read-only queries.").Default(defaultCfg.Raft.ReadOnlyOption).Enum(raftnode.ReadOnlySafe, raftnode.ReadOnlyLeaseBased)
 )
 
+// flagsSetByUser returns the names of flags given in the command line.
+func flagsSetByUser() map[string]bool {
+	set := make(map[string]bool)
+	ctx, err := kingpin.CommandLine.ParseContext(os.Args[1:])
+	if err != nil {
+		return set
+	}
+	for _, elem := range ctx.Elements {
+		if flag, ok := elem.Clause.(*kingpin.FlagClause); ok {
+			set[flag.Model().Name] = true
+		}
+	}
+	return set
+}
+
+// loadConfig loads the config file and environment variables, then applies
+// the flags given in the command line, which take the highest precedence.
+func loadConfig() (config.Config, error) {
+	cfg, err := config.Load(*flagConfigFile)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.ForceNewCluster = *flagForceNewCluster
+	for name := range flagsSetByUser() {
+		switch name {
+		case "port":
+			cfg.Port = *flagPort
+		case "data-dir":
+			cfg.DataDir = *flagDataDir
+		case "backend":
+			cfg.Backend = *flagBackend
+		case "log-level":
+			cfg.LogLevel = *flagLogLevel
+		case "cluster-id":
+			cfg.Raft.ClusterID = *flagClusterID
+		case "tick-interval":
+			cfg.Raft.TickInterval = *flagTickInterval
+		case "election-tick":
+			cfg.Raft.ElectionTick = *flagElectionTick
+		case "heartbeat-tick":
+			cfg.Raft.HeartbeatTick = *flagHeartbeatTick
+		case "max-size-per-msg":
+			cfg.Raft.MaxSizePerMsg = *flagMaxSizePerMsg
+		case "max-inflight-msgs":
+			cfg.Raft.MaxInflightMsgs = *flagMaxInflightMsgs
+		case "max-uncommitted-entries-size":
+			cfg.Raft.MaxUncommittedEntriesSize = *flagMaxUncommittedEntriesSize
+		case "read-only-option":
+			cfg.Raft.ReadOnlyOption = *flagReadOnlyOption
+		}
+	}
+	return cfg, cfg.Validate()
+}
+
+// reloadOnSIGHUP reloads the config and applies the settings which can be
+// changed at runtime. Currently only the log level is reloadable.
+func reloadOnSIGHUP(logger *zap.Logger, level zap.AtomicLevel) {
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, syscall.SIGHUP)
+	for range sigC {
+		cfg, err := loadConfig()
+		if err != nil {
+			logger.Warn("failed to reload config", zap.Error(err))
+			continue
+		}
+		if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+			logger.Warn("failed to reload log level", zap.Error(err))
+			continue
+		}
+		logger.Info("config is reloaded", zap.String("log-level", cfg.LogLevel))
+	}
+}
+
 func main() {
 	kingpin.Parse()
 
+	cfg, err := loadConfig()
+	if err != nil {
+		kingpin.Fatalf("invalid config: %v", err)
+	}
+	if *flagPrintConfig {
+		data, err := cfg.Encode()
+		if err != nil {
+			kingpin.Fatalf("failed to encode config: %v", err)
+		}
+		os.Stdout.Write(data)
+		return
+	}
+
 	logEncCfg := zap.NewProductionEncoderConfig()
 	logEncCfg.EncodeTime = zapcore.ISO8601TimeEncoder
 	logCfg := zap.NewProductionConfig()
 	logCfg.EncoderConfig = logEncCfg
-	if err := logCfg.Level.UnmarshalText([]byte(*flagLogLevel)); err != nil {
+	if err := logCfg.Level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
 		panic(err)
 	}
 	logger, err := logCfg.Build()
@@ -33,19 +142,23 @@ func main() {
 		panic(err)
 	}
 	zap.ReplaceGlobals(logger)
+	go reloadOnSIGHUP(logger, logCfg.Level)
 
-	logger.Info("starting chat server", zap.Int("port", *flagPort), zap.String("data-dir", *flagDataDir))
+	logger.Info(
+		"starting chat server",
+		zap.Int("port", cfg.Port),
+		zap.String("data-dir", cfg.DataDir),
+		zap.String("backend", cfg.Backend),
+		zap.Duration("election-timeout", cfg.Raft.ElectionTimeout()),
+	)
 
-	if err := os.MkdirAll(*flagDataDir, 0755); err != nil {
+	if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
 		logger.Fatal("failed to create data dir", zap.Error(err))
 	}
-	if err := fileutil.IsDirWriteable(*flagDataDir); err != nil {
+	if err := fileutil.IsDirWriteable(cfg.DataDir); err != nil {
 		logger.Fatal("data dir is not writable", zap.Error(err))
 	}
-	if err := os.MkdirAll(*flagDataDir, 0755); err != nil {
-		logger.Fatal("failed create data dir", zap.Error(err))
-	}
-	srv := app.NewServer(logger, *flagPort, *flagDataDir)
+	srv := app.NewServer(logger, cfg.Config)
 	if err := srv.Run(); err != nil {
 		logger.Fatal("failed run server", zap.Error(err))
 	}
@@ -0,0 +1,198 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/gozssky/groupchat/pkg/blob"
+	"github.com/gozssky/groupchat/pkg/storage"
+)
+
+// sniffLen is the number of bytes used to detect the MIME type of content.
+const sniffLen = 512
+
+// detectMIMEType returns the MIME type of the content without parameters.
+func detectMIMEType(head []byte) string {
+	t, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return t
+}
+
+// handleAttachmentUpload stores the request body in the local blob store and
+// records it through raft, only the reference goes through raft. Other
+// members fetch the content once they apply the record.
+func (s *Server) handleAttachmentUpload(c *gin.Context) {
+	r := bufio.NewReaderSize(c.Request.Body, sniffLen)
+	head, err := r.Peek(sniffLen)
+	if err != nil && len(head) == 0 {
+		writeError(c, errors.New("attachment must not be empty"))
+		return
+	}
+	mimeType := detectMIMEType(head)
+	if !s.cfg.attachmentTypes()[mimeType] {
+		writeError(c, fmt.Errorf("attachment type %s is not allowed", mimeType))
+		return
+	}
+	id, size, err := s.blobs.Put(r, s.cfg.MaxAttachmentSize, "")
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	username, _ := c.Get("username")
+	if _, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
+		CreateAttachment: &storage.CreateAttachmentCommand{
+			UserName: username.(string),
+			ID:       id,
+			Size:     size,
+			MIMEType: mimeType,
+			TS:       int(time.Now().Unix()),
+		},
+	}); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": id, "size": size, "mimeType": mimeType})
+}
+
+// handleAttachmentDownload writes the content of the attachment to its
+// uploader or members of the rooms it is sent to, it is fetched from other
+// members first if this member doesn't have it yet.
+func (s *Server) handleAttachmentDownload(c *gin.Context) {
+	id := c.Param("id")
+	s.rwm.RLock()
+	attachment, ok := s.storage.Attachments[id]
+	allowed := s.storage.CanDownload(s.currentUser(c), id)
+	s.rwm.RUnlock()
+	if !ok {
+		writeError(c, storage.ErrAttachmentNotExists)
+		return
+	}
+	if !allowed {
+		writeError(c, storage.ErrPermissionDenied)
+		return
+	}
+	if !s.blobs.Has(id) {
+		// The blob may have been lost with the members which had it, or
+		// left out of the backup the cluster was restored from.
+		if err := s.fetchBlob(c.Request.Context(), attachment); err != nil {
+			c.Data(http.StatusNotFound, "text/plain", []byte(fmt.Sprintf("Error: %v", err)))
+			return
+		}
+	}
+	f, err := s.blobs.Get(id)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	defer f.Close()
+	disposition := "attachment"
+	if strings.HasPrefix(attachment.MIMEType, "image/") {
+		disposition = "inline"
+	}
+	c.Header("Content-Type", attachment.MIMEType)
+	c.Header("Content-Disposition", disposition)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "private, max-age=31536000, immutable")
+	http.ServeContent(c.Writer, c.Request, "", time.Unix(int64(attachment.TS), 0), f)
+}
+
+// handleClusterBlob writes the content of a blob stored on this member, it
+// is fetched by the other members only.
+func (s *Server) handleClusterBlob(c *gin.Context) {
+	f, err := s.blobs.Get(c.Param("id"))
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	c.Header("Content-Type", "application/octet-stream")
+	http.ServeContent(c.Writer, c.Request, "", time.Time{}, f)
+}
+
+// fetchBlob copies the content of the attachment from the first member
+// which has it.
+func (s *Server) fetchBlob(ctx context.Context, attachment *storage.Attachment) error {
+	for _, peer := range s.node.Members() {
+		if peer.ID == s.node.ID() {
+			continue
+		}
+		err := s.fetchBlobFrom(ctx, peer.URL, attachment)
+		if err == nil {
+			return nil
+		}
+		s.lg.Debug("failed to fetch blob", zap.String("url", peer.URL), zap.String("id", attachment.ID), zap.Error(err))
+	}
+	return fmt.Errorf("content of attachment %s is not available on any member", attachment.ID)
+}
+
+func (s *Server) fetchBlobFrom(ctx context.Context, url string, attachment *storage.Attachment) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/cluster/blobs/"+attachment.ID, nil)
+	if err != nil {
+		return err
+	}
+	s.signPeerRequest(req, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+	_, _, err = s.blobs.Put(resp.Body, attachment.Size, attachment.ID)
+	return err
+}
+
+// replicateBlobs fetches the content of the attachments this member doesn't
+// have. Failures are only logged, they are fetched again on download.
+func (s *Server) replicateBlobs(ids []string) {
+	for _, id := range ids {
+		if s.blobs.Has(id) {
+			continue
+		}
+		s.rwm.RLock()
+		attachment, ok := s.storage.Attachments[id]
+		s.rwm.RUnlock()
+		if !ok {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		err := s.fetchBlob(ctx, attachment)
+		cancel()
+		if err != nil {
+			s.lg.Warn("failed to replicate attachment", zap.Error(err))
+		}
+	}
+}
+
+// allAttachments returns the ids of all attachments, it must be called with
+// rwm held.
+func (s *Server) allAttachments() []string {
+	ids := make([]string, 0, len(s.storage.Attachments))
+	for id := range s.storage.Attachments {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// openBlobs opens the blob store under the data dir.
+func (s *Server) openBlobs() error {
+	blobs, err := blob.Open(filepath.Join(s.cfg.DataDir, "blobs"))
+	if err != nil {
+		return fmt.Errorf("failed to open blob store: %v", err)
+	}
+	s.blobs = blobs
+	return nil
+}
@@ -0,0 +1,137 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gozssky/groupchat/pkg/backup"
+	"github.com/gozssky/groupchat/pkg/storage"
+)
+
+func TestAttachmentAccess(t *testing.T) {
+	s := newTestServer(t, testConfig(t))
+	alice, bob, carol := s.login(t, "alice"), s.login(t, "bob"), s.login(t, "carol")
+	w := s.request(http.MethodPost, "/room", bearer(alice), strings.NewReader(`{"name":"lobby"}`))
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to create room: %s", w.Body)
+	}
+	roomID := w.Body.String()
+	for _, token := range []string{alice, bob} {
+		if w := s.request(http.MethodPut, "/room/"+roomID+"/enter", bearer(token), nil); w.Code != http.StatusOK {
+			t.Fatalf("failed to enter room: %s", w.Body)
+		}
+	}
+	w = s.request(http.MethodPost, "/attachments", bearer(alice), strings.NewReader("hello"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to upload attachment: %s", w.Body)
+	}
+	var uploaded struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &uploaded); err != nil {
+		t.Fatal(err)
+	}
+	download := "/attachments/" + uploaded.ID
+	canDownload := func(token string) bool {
+		w := s.request(http.MethodGet, download, bearer(token), nil)
+		return w.Code == http.StatusOK && w.Body.String() == "hello"
+	}
+	if !canDownload(alice) || canDownload(bob) {
+		t.Fatal("only the uploader should download attachments which are not sent")
+	}
+
+	body := fmt.Sprintf(`{"id":"m1","text":"see this","attachments":[%q]}`, uploaded.ID)
+	if w := s.request(http.MethodPost, "/message/send", bearer(alice), strings.NewReader(body)); w.Code != http.StatusOK {
+		t.Fatalf("failed to send message: %s", w.Body)
+	}
+	if !canDownload(bob) || canDownload(carol) {
+		t.Fatal("only members of the room should download sent attachments")
+	}
+
+	blob := "/cluster/blobs/" + uploaded.ID
+	if w := s.request(http.MethodGet, blob, nil, nil); w.Code == http.StatusOK {
+		t.Fatal("blobs should not be served to clients")
+	}
+	if w := s.request(http.MethodGet, blob, s.signed(http.MethodGet, blob, ""), nil); w.Code != http.StatusOK || w.Body.String() != "hello" {
+		t.Fatalf("blobs should be served to members: %d %s", w.Code, w.Body)
+	}
+}
+
+func TestBackupAttachments(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.AdminToken = "admin"
+	s := newTestServer(t, cfg)
+	alice := s.login(t, "alice")
+	var ids []string
+	for _, content := range []string{"kept", "lost"} {
+		w := s.request(http.MethodPost, "/attachments", bearer(alice), strings.NewReader(content))
+		if w.Code != http.StatusOK {
+			t.Fatalf("failed to upload attachment: %s", w.Body)
+		}
+		var uploaded struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &uploaded); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, uploaded.ID)
+	}
+	f, err := s.blobs.Get(ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if w := s.request(http.MethodGet, "/attachments/"+ids[1], bearer(alice), nil); w.Code != http.StatusNotFound {
+		t.Fatalf("download of a lost blob should fail with 404, got %d %s", w.Code, w.Body)
+	}
+
+	w := s.request(http.MethodGet, "/cluster/backup", http.Header{adminHeader: []string{"admin"}}, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to backup: %s", w.Body)
+	}
+	b, dec, err := backup.NewDecoder(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Blobs != 1 || len(b.MissingBlobs) != 1 || b.MissingBlobs[0] != ids[1] {
+		t.Fatalf("unexpected blobs %d, missing %v", b.Blobs, b.MissingBlobs)
+	}
+	db, err := io.ReadAll(dec)
+	if err != nil || int64(len(db)) != b.DBSize {
+		t.Fatalf("failed to read the database of %d bytes: %v", b.DBSize, err)
+	}
+	path := filepath.Join(t.TempDir(), "backup.db")
+	if err := os.WriteFile(path, db, 0600); err != nil {
+		t.Fatal(err)
+	}
+	backend, err := storage.OpenBackend(storage.BackendMemory, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := storage.NewStorage()
+	if err := backend.Restore(restored, path); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Index != b.Index || len(restored.Attachments) != 2 {
+		t.Fatalf("unexpected restored state at %d with %d attachments", restored.Index, len(restored.Attachments))
+	}
+	blob, err := dec.DecodeBlob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blob.ID != ids[0] || string(blob.Data) != "kept" {
+		t.Fatalf("unexpected blob %s %q", blob.ID, blob.Data)
+	}
+	if _, err := dec.DecodeBlob(); err != io.EOF {
+		t.Fatalf("expect the end of backup, got %v", err)
+	}
+}
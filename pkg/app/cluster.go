@@ -0,0 +1,218 @@
+package app
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/client/pkg/v3/types"
+	"go.uber.org/zap"
+
+	"github.com/gozssky/groupchat/pkg/backup"
+	"github.com/gozssky/groupchat/pkg/metadata"
+	"github.com/gozssky/groupchat/pkg/raftnode"
+	"github.com/gozssky/groupchat/pkg/storage"
+)
+
+type respMember struct {
+	ID  uint64 `json:"id"`
+	URL string `json:"url"`
+}
+
+func toRespMembers(peers []metadata.Peer) []respMember {
+	members := make([]respMember, 0, len(peers))
+	for _, peer := range peers {
+		members = append(members, respMember{ID: uint64(peer.ID), URL: peer.URL})
+	}
+	return members
+}
+
+func parseMemberID(c *gin.Context) (types.ID, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		return 0, errors.New("invalid member id")
+	}
+	return types.ID(id), nil
+}
+
+func (s *Server) handleClusterJoin(c *gin.Context) {
+	var req struct {
+		ID      uint64       `json:"id"`
+		Members []respMember `json:"members"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, err)
+		return
+	}
+	if req.ID == 0 {
+		writeError(c, errors.New("invalid member id"))
+		return
+	}
+	var peers []metadata.Peer
+	for _, m := range req.Members {
+		peers = append(peers, metadata.Peer{ID: types.ID(m.ID), URL: m.URL})
+	}
+	s.lg.Info("start to join an existing raft cluster", zap.Uint64("id", req.ID))
+	go s.bootstrap(func() *raftnode.Node {
+		return raftnode.JoinRaftNode(s.lg, s.cfg.Raft, types.ID(req.ID), peers, s.cfg.DataDir)
+	})
+}
+
+func (s *Server) handleClusterStatus(c *gin.Context) {
+	st := s.node.Status()
+	var snapshotIndex uint64
+	if snap, err := s.node.Snapshot(); err == nil {
+		snapshotIndex = snap.Metadata.Index
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":            uint64(s.node.ID()),
+		"leader":        st.Lead,
+		"state":         st.RaftState.String(),
+		"term":          st.Term,
+		"commitIndex":   st.Commit,
+		"appliedIndex":  s.appliedIndex.Load(),
+		"snapshotIndex": snapshotIndex,
+	})
+}
+
+func (s *Server) handleClusterMembers(c *gin.Context) {
+	c.JSON(http.StatusOK, toRespMembers(s.node.Members()))
+}
+
+func (s *Server) handleClusterAddMember(c *gin.Context) {
+	var req struct {
+		URL string `json:"url"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, err)
+		return
+	}
+	if len(req.URL) == 0 {
+		writeError(c, errors.New("member url must not be empty"))
+		return
+	}
+	id, err := s.node.AddMember(c.Request.Context(), req.URL)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":      uint64(id),
+		"members": toRespMembers(s.node.Members()),
+	})
+}
+
+func (s *Server) handleClusterRemoveMember(c *gin.Context) {
+	id, err := parseMemberID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	if err := s.node.RemoveMember(c.Request.Context(), id); err != nil {
+		writeError(c, err)
+	}
+}
+
+func (s *Server) handleClusterTransferLeader(c *gin.Context) {
+	id, err := parseMemberID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	if err := s.node.TransferLeadership(c.Request.Context(), id); err != nil {
+		writeError(c, err)
+	}
+}
+
+func (s *Server) handleClusterSnapshot(c *gin.Context) {
+	index, err := s.createSnapshot(c.Request.Context())
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.Data(http.StatusOK, "text/plain", []byte(strconv.FormatUint(index, 10)))
+}
+
+// handleClusterBackup streams a backup of the state followed by the blobs of
+// all attachments. Blobs this member doesn't have are fetched from the
+// others first, the ones found nowhere are listed as missing.
+func (s *Server) handleClusterBackup(c *gin.Context) {
+	if err := s.linearizableReadNotify(c.Request.Context()); err != nil {
+		writeError(c, err)
+		return
+	}
+	b := &backup.Backup{Members: s.node.Members()}
+	s.rwm.RLock()
+	db, size, index, err := s.backend.Snapshot(s.storage)
+	if err != nil {
+		s.rwm.RUnlock()
+		writeError(c, err)
+		return
+	}
+	var attachments []*storage.Attachment
+	for _, attachment := range s.storage.Attachments {
+		attachments = append(attachments, attachment)
+	}
+	s.rwm.RUnlock()
+	defer db.Close()
+	b.Index, b.DBSize = index, size
+	term, err := s.node.Term(b.Index)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	b.Term = term
+
+	sort.Slice(attachments, func(i, j int) bool {
+		return attachments[i].ID < attachments[j].ID
+	})
+	var ids []string
+	for _, attachment := range attachments {
+		if !s.blobs.Has(attachment.ID) {
+			if err := s.fetchBlob(c.Request.Context(), attachment); err != nil {
+				s.lg.Warn("attachment is missing from backup", zap.String("id", attachment.ID), zap.Error(err))
+				b.MissingBlobs = append(b.MissingBlobs, attachment.ID)
+				continue
+			}
+		}
+		ids = append(ids, attachment.ID)
+	}
+	b.Blobs = len(ids)
+
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Disposition", "attachment; filename=groupchat.backup")
+	c.Status(http.StatusOK)
+	enc, err := backup.NewEncoder(c.Writer, b)
+	if err != nil {
+		s.lg.Warn("failed to stream backup", zap.Error(err))
+		return
+	}
+	if err := enc.EncodeDB(db); err != nil {
+		s.lg.Warn("failed to stream backup", zap.Error(err))
+		return
+	}
+	// Blobs are never deleted, so they are still stored. A failure leaves
+	// the backup truncated, which is detected when it is restored.
+	for _, id := range ids {
+		if err := s.encodeBlob(enc, id); err != nil {
+			s.lg.Warn("failed to stream backup", zap.String("blob", id), zap.Error(err))
+			return
+		}
+	}
+}
+
+func (s *Server) encodeBlob(enc *backup.Encoder, id string) error {
+	f, err := s.blobs.Get(id)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	return enc.EncodeBlob(&backup.Blob{ID: id, Data: data})
+}
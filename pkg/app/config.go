@@ -0,0 +1,137 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gozssky/groupchat/pkg/raftnode"
+	"github.com/gozssky/groupchat/pkg/storage"
+)
+
+type Config struct {
+	// Port to listen for both client requests and peer raft messages.
+	Port int `yaml:"port"`
+	// DataDir is the directory to store snapshot and WAL logs.
+	DataDir string `yaml:"data-dir"`
+	// ForceNewCluster forces to create a new one-member cluster from the
+	// existing data dir. It is a one-off operation, so it can't be set in
+	// the config file.
+	ForceNewCluster bool `yaml:"-"`
+	// Backend is the storage backend of the state machine, see
+	// storage.BackendBolt and others.
+	Backend string `yaml:"backend"`
+	// ReadTimeout is the timeout of a linearizable read.
+	ReadTimeout time.Duration `yaml:"read-timeout"`
+	// ReadConsistency is the read consistency level of requests which don't
+	// choose one, see ConsistencyLinearizable and others.
+	ReadConsistency string `yaml:"read-consistency"`
+	// MaxReadLag is the default max lag of applied index for the
+	// bounded-staleness read consistency.
+	MaxReadLag uint64 `yaml:"max-read-lag"`
+	// SecretKeyTimeout is the timeout of each attempt to get or initialize
+	// the secret key when the cluster is starting.
+	SecretKeyTimeout time.Duration `yaml:"secret-key-timeout"`
+	// SecretKeyRetryInterval is the interval between the attempts to get or
+	// initialize the secret key.
+	SecretKeyRetryInterval time.Duration `yaml:"secret-key-retry-interval"`
+	// PruneInterval is the interval at which the leader prunes the messages
+	// exceeding the retention policies.
+	PruneInterval time.Duration `yaml:"prune-interval"`
+	// PresenceTTL is how long a user stays online after the last request
+	// when the user has no streaming connection.
+	PresenceTTL time.Duration `yaml:"presence-ttl"`
+	// PresenceInterval is the interval at which members fetch the presence
+	// of users on the other members.
+	PresenceInterval time.Duration `yaml:"presence-interval"`
+	// MaxAttachmentSize is the max size of an attachment in bytes.
+	MaxAttachmentSize int64 `yaml:"max-attachment-size"`
+	// AttachmentTypes are the comma separated MIME types allowed for
+	// attachments, they are detected from the content.
+	AttachmentTypes string `yaml:"attachment-types"`
+	// SnapshotCount is the number of applied entries after which a raft
+	// snapshot is created and the log is compacted, 0 disables automatic
+	// snapshots.
+	SnapshotCount uint64 `yaml:"snapshot-count"`
+	// AdminToken authorizes the cluster management requests which carry it
+	// in the header X-Groupchat-Admin-Token. They are refused if it is
+	// empty.
+	AdminToken string `yaml:"admin-token"`
+
+	Raft raftnode.Config `yaml:"raft"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Port:                   8080,
+		DataDir:                "/tmp/groupchat",
+		Backend:                storage.BackendBolt,
+		ReadTimeout:            time.Second * 5,
+		ReadConsistency:        ConsistencyLinearizable,
+		MaxReadLag:             100,
+		SecretKeyTimeout:       time.Second * 5,
+		SecretKeyRetryInterval: time.Second,
+		PruneInterval:          time.Minute,
+		PresenceTTL:            time.Minute,
+		PresenceInterval:       time.Second * 5,
+		MaxAttachmentSize:      10 << 20,
+		AttachmentTypes:        "image/png,image/jpeg,image/gif,image/webp,application/pdf,text/plain",
+		SnapshotCount:          10000,
+		Raft:                   raftnode.DefaultConfig(),
+	}
+}
+
+func (cfg *Config) Validate() error {
+	if cfg.Port <= 0 || cfg.Port > 65535 {
+		return fmt.Errorf("invalid port %d", cfg.Port)
+	}
+	if len(cfg.DataDir) == 0 {
+		return errors.New("data dir must not be empty")
+	}
+	if err := storage.ValidateBackend(cfg.Backend); err != nil {
+		return err
+	}
+	if cfg.ReadTimeout <= 0 {
+		return errors.New("read timeout must be greater than 0")
+	}
+	if err := validateConsistency(cfg.ReadConsistency); err != nil {
+		return err
+	}
+	if cfg.SecretKeyTimeout <= 0 {
+		return errors.New("secret key timeout must be greater than 0")
+	}
+	if cfg.SecretKeyRetryInterval <= 0 {
+		return errors.New("secret key retry interval must be greater than 0")
+	}
+	if cfg.PruneInterval <= 0 {
+		return errors.New("prune interval must be greater than 0")
+	}
+	if cfg.PresenceTTL <= 0 {
+		return errors.New("presence ttl must be greater than 0")
+	}
+	if cfg.PresenceInterval <= 0 {
+		return errors.New("presence interval must be greater than 0")
+	}
+	if cfg.MaxAttachmentSize <= 0 {
+		return errors.New("max attachment size must be greater than 0")
+	}
+	if len(cfg.attachmentTypes()) == 0 {
+		return errors.New("attachment types must not be empty")
+	}
+	if err := cfg.Raft.Validate(); err != nil {
+		return fmt.Errorf("invalid raft config: %v", err)
+	}
+	return nil
+}
+
+// attachmentTypes returns the set of MIME types allowed for attachments.
+func (cfg *Config) attachmentTypes() map[string]bool {
+	types := make(map[string]bool)
+	for _, t := range strings.Split(cfg.AttachmentTypes, ",") {
+		if t = strings.TrimSpace(t); len(t) > 0 {
+			types[t] = true
+		}
+	}
+	return types
+}
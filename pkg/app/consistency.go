@@ -0,0 +1,122 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Read consistency levels which can be chosen per request by the header
+// X-Read-Consistency or the query parameter consistency.
+const (
+	// ConsistencyLinearizable confirms the read index with a quorum of the
+	// cluster and waits for it to be applied.
+	ConsistencyLinearizable = "linearizable"
+	// ConsistencyLease serves the read locally if the node is the leader and
+	// holds the leader lease, which is renewed by linearizable reads. It may
+	// be stale if the clock drift between members is too large. It falls
+	// back to linearizable read on followers or when the lease expires.
+	ConsistencyLease = "lease"
+	// ConsistencyBoundedStaleness serves the read locally if the applied
+	// index lags behind the commit index known by the node by no more than
+	// the max lag given by the header X-Max-Lag or the query parameter
+	// max-lag, otherwise it waits until the lag is small enough.
+	ConsistencyBoundedStaleness = "bounded-staleness"
+	// ConsistencyLocal serves the read from the local state immediately.
+	ConsistencyLocal = "local"
+)
+
+func validateConsistency(level string) error {
+	switch level {
+	case ConsistencyLinearizable, ConsistencyLease, ConsistencyBoundedStaleness, ConsistencyLocal:
+		return nil
+	default:
+		return fmt.Errorf("unknown read consistency %q", level)
+	}
+}
+
+func requestParam(c *gin.Context, header, query string) string {
+	if v := c.GetHeader(header); len(v) > 0 {
+		return v
+	}
+	return c.Query(query)
+}
+
+func (s *Server) waitApplied(ctx context.Context, index uint64) error {
+	if s.appliedIndex.Load() >= index {
+		return nil
+	}
+	select {
+	case <-s.applyWait.Wait(index):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Server) leaseReadNotify(ctx context.Context) error {
+	if !s.node.HasLease() {
+		return s.linearizableReadNotify(ctx)
+	}
+	return s.waitApplied(ctx, s.node.Status().Commit)
+}
+
+func (s *Server) boundedStalenessReadNotify(ctx context.Context, maxLag uint64) error {
+	commit := s.node.Status().Commit
+	if commit <= maxLag {
+		return nil
+	}
+	return s.waitApplied(ctx, commit-maxLag)
+}
+
+func (s *Server) readNotify(ctx context.Context, level string, maxLag uint64) error {
+	switch level {
+	case ConsistencyLinearizable:
+		return s.linearizableReadNotify(ctx)
+	case ConsistencyLease:
+		return s.leaseReadNotify(ctx)
+	case ConsistencyBoundedStaleness:
+		return s.boundedStalenessReadNotify(ctx, maxLag)
+	case ConsistencyLocal:
+		return nil
+	default:
+		return validateConsistency(level)
+	}
+}
+
+// readConsistencyRequired ensures the local state is consistent enough for
+// the read consistency level chosen by the request before serving it.
+func (s *Server) readConsistencyRequired(c *gin.Context) {
+	level := requestParam(c, "X-Read-Consistency", "consistency")
+	if len(level) == 0 {
+		level = s.cfg.ReadConsistency
+	}
+	maxLag := s.cfg.MaxReadLag
+	if v := requestParam(c, "X-Max-Lag", "max-lag"); len(v) > 0 {
+		lag, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writeError(c, errors.New("invalid max lag"))
+			c.Abort()
+			return
+		}
+		maxLag = lag
+	}
+	if err := s.readNotify(c.Request.Context(), level, maxLag); err != nil {
+		writeError(c, err)
+		c.Abort()
+	}
+}
+
+// linearizableReadRequired ensures the local state is up to date regardless
+// of the read consistency level chosen by the request. It is used by login,
+// so that a changed password or deleted user is never accepted by a lagging
+// member.
+func (s *Server) linearizableReadRequired(c *gin.Context) {
+	if err := s.linearizableReadNotify(c.Request.Context()); err != nil {
+		writeError(c, err)
+		c.Abort()
+	}
+}
@@ -0,0 +1,137 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gozssky/groupchat/pkg/storage"
+)
+
+func TestReadConsistencyLevels(t *testing.T) {
+	s := newTestServer(t, testConfig(t))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	for _, level := range []string{ConsistencyLinearizable, ConsistencyLease, ConsistencyBoundedStaleness, ConsistencyLocal} {
+		if err := s.readNotify(ctx, level, 0); err != nil {
+			t.Fatalf("%s read should be served: %v", level, err)
+		}
+	}
+	if !s.node.HasLease() {
+		t.Fatal("linearizable reads should renew the leader lease")
+	}
+	if err := s.readNotify(ctx, "stale", 0); err == nil {
+		t.Fatal("unknown level should be rejected")
+	}
+
+	// Block the apply of a new entry, so that the applied index lags behind
+	// the commit index by one.
+	s.rwm.Lock()
+	locked := true
+	defer func() {
+		if locked {
+			s.rwm.Unlock()
+		}
+	}()
+	proposed := make(chan error, 1)
+	go func() {
+		_, err := s.proposeRaftCommand(ctx, storage.InternalRaftCommand{
+			CreateUser: &storage.CreateUserCommand{UserName: "alice", Password: "secret"},
+		})
+		proposed <- err
+	}()
+	applied := s.appliedIndex.Load()
+	for s.node.Status().Commit <= applied {
+		if ctx.Err() != nil {
+			t.Fatal("timed out waiting for the entry to be committed")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if err := s.readNotify(ctx, ConsistencyLocal, 0); err != nil {
+		t.Fatalf("local read should be served: %v", err)
+	}
+	if err := s.readNotify(ctx, ConsistencyBoundedStaleness, 1); err != nil {
+		t.Fatalf("bounded-staleness read within max lag should be served: %v", err)
+	}
+	shortCtx, shortCancel := context.WithTimeout(ctx, time.Millisecond*100)
+	err := s.readNotify(shortCtx, ConsistencyBoundedStaleness, 0)
+	shortCancel()
+	if err != context.DeadlineExceeded {
+		t.Fatalf("bounded-staleness read beyond max lag should wait, got %v", err)
+	}
+	if err := s.readNotify(ctx, ConsistencyLinearizable, 0); err == nil {
+		t.Fatal("linearizable read should wait for the entry to be applied")
+	}
+	shortCtx, shortCancel = context.WithTimeout(ctx, time.Millisecond*100)
+	err = s.readNotify(shortCtx, ConsistencyLease, 0)
+	shortCancel()
+	if err != context.DeadlineExceeded {
+		t.Fatalf("lease read should wait for the commit index to be applied, got %v", err)
+	}
+
+	s.rwm.Unlock()
+	locked = false
+	if err := <-proposed; err != nil {
+		t.Fatal(err)
+	}
+	for _, level := range []string{ConsistencyLinearizable, ConsistencyLease, ConsistencyBoundedStaleness} {
+		if err := s.readNotify(ctx, level, 0); err != nil {
+			t.Fatalf("%s read should be served after apply: %v", level, err)
+		}
+	}
+}
+
+func TestLoginIsLinearizable(t *testing.T) {
+	s := newTestServer(t, testConfig(t))
+	w := s.request(http.MethodPost, "/user", nil,
+		strings.NewReader(`{"username":"alice","password":"secret"}`))
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to create user: %s", w.Body)
+	}
+	login := "/userLogin?username=alice&password=secret"
+	if w := s.request(http.MethodGet, login, nil, nil); w.Code != http.StatusOK {
+		t.Fatalf("failed to login: %s", w.Body)
+	}
+
+	// Add a member which never starts, the local member then loses the
+	// quorum and no read can be confirmed.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if _, err := s.node.AddMember(ctx, "http://127.0.0.1:1"); err != nil {
+		t.Fatal(err)
+	}
+	local := http.Header{"X-Read-Consistency": []string{ConsistencyLocal}}
+	if w := s.request(http.MethodGet, "/retention", local, nil); w.Code != http.StatusOK {
+		t.Fatalf("local read should be served without quorum: %s", w.Body)
+	}
+	if w := s.request(http.MethodGet, "/user/alice", local, nil); w.Code != http.StatusOK {
+		t.Fatalf("local user query should be served without quorum: %s", w.Body)
+	}
+	if w := s.request(http.MethodGet, login, local, nil); w.Code == http.StatusOK {
+		t.Fatal("login should not be served without quorum")
+	}
+}
+
+func TestOverlappingReadIndex(t *testing.T) {
+	s := newTestServer(t, testConfig(t))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	// Concurrent ReadIndex requests are answered by read states of the
+	// same Ready, each of them must be delivered to its own request.
+	const n = 100
+	errC := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			errC <- s.applyToLatest(ctx)
+		}()
+	}
+	for i := 0; i < n; i++ {
+		if err := <-errC; err != nil {
+			t.Fatalf("read index request should get its read state: %v", err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/gozssky/groupchat/pkg/storage"
+)
+
+// parseDirectParticipants returns the participants given in the path, they
+// are separated by commas for group conversations.
+func parseDirectParticipants(c *gin.Context) []string {
+	var names []string
+	for _, name := range strings.Split(c.Param("username"), ",") {
+		if len(name) > 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (s *Server) handleDirectSend(c *gin.Context) {
+	var msg struct {
+		ID   string `json:"id"`
+		Text string `json:"text"`
+	}
+	if err := c.ShouldBindJSON(&msg); err != nil {
+		writeError(c, err)
+		return
+	}
+	username, _ := c.Get("username")
+	now := time.Now()
+	result, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
+		SendDirectMessage: &storage.SendDirectMessageCommand{
+			ID:           msg.ID,
+			TS:           int(now.Unix()),
+			TSMilli:      now.UnixMilli(),
+			Text:         msg.Text,
+			UserName:     username.(string),
+			Participants: parseDirectParticipants(c),
+		},
+	})
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"conversationId": result.(string)})
+}
+
+func (s *Server) handleDirectRetrieve(c *gin.Context) {
+	pageIndex, err := strconv.Atoi(c.DefaultQuery("pageIndex", "-1"))
+	if err != nil {
+		writeError(c, errors.New("invalid page index"))
+		return
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", "10"))
+	if err != nil {
+		writeError(c, errors.New("invalid page size"))
+		return
+	}
+	username, _ := c.Get("username")
+	participants := append(parseDirectParticipants(c), username.(string))
+
+	type RespMsg struct {
+		ID          string `json:"id"`
+		From        string `json:"from"`
+		Text        string `json:"text"`
+		Timestamp   string `json:"timestamp"`
+		TimestampMs string `json:"timestampMs,omitempty"`
+	}
+	s.rwm.RLock()
+	defer s.rwm.RUnlock()
+	// The conversation is looked up by the participants including the
+	// caller, so only participants can read it. Tombstones left by deleted
+	// participants are checked to still include the caller, as a user with
+	// the same name may have been created since.
+	room, ok := s.storage.Direct(participants)
+	if !ok {
+		room, ok = s.storage.DirectTombstone(participants, username.(string))
+	}
+	if !ok {
+		c.JSON(http.StatusOK, []RespMsg{})
+		return
+	}
+	start, end := convertPageToRange(room.MessageCount(), pageIndex, pageSize)
+	respMsgs := make([]RespMsg, end-start)
+	for i := end - 1; i >= start; i-- {
+		msg := room.MessageAt(i)
+		respMsgs[end-1-i] = RespMsg{
+			ID:          msg.ID,
+			From:        msg.Author,
+			Text:        msg.Text,
+			Timestamp:   strconv.Itoa(msg.TS),
+			TimestampMs: formatTimestampMs(msg.TSMilli),
+		}
+	}
+	c.JSON(http.StatusOK, respMsgs)
+}
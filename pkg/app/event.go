@@ -0,0 +1,180 @@
+package app
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/gozssky/groupchat/pkg/storage"
+)
+
+// eventBufferSize is the number of events buffered for a subscriber, slow
+// subscribers are dropped once the buffer is full.
+const eventBufferSize = 256
+
+// eventTopic is what subscribers subscribe to, either the events of a room
+// or the mentions of a user.
+type eventTopic struct {
+	roomID   int
+	userName string
+}
+
+func roomTopic(roomID int) eventTopic { return eventTopic{roomID: roomID} }
+
+func userTopic(name string) eventTopic { return eventTopic{userName: name} }
+
+// topicOf returns the topic an event is published to.
+func topicOf(e storage.Event) eventTopic {
+	if e.Type == storage.EventMention {
+		return userTopic(e.UserName)
+	}
+	return roomTopic(e.RoomID)
+}
+
+// eventHub fans out the events of applied commands to the subscribers on
+// this node.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[eventTopic]map[chan storage.Event]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[eventTopic]map[chan storage.Event]struct{})}
+}
+
+// subscribe returns a channel receiving events of the topic, it is closed by
+// unsubscribe or when the subscriber falls behind.
+func (h *eventHub) subscribe(topic eventTopic) chan storage.Event {
+	ch := make(chan storage.Event, eventBufferSize)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subs[topic] == nil {
+		h.subs[topic] = make(map[chan storage.Event]struct{})
+	}
+	h.subs[topic][ch] = struct{}{}
+	return ch
+}
+
+func (h *eventHub) unsubscribe(topic eventTopic, ch chan storage.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(topic, ch)
+}
+
+// remove must be called with mu held.
+func (h *eventHub) remove(topic eventTopic, ch chan storage.Event) {
+	subs, ok := h.subs[topic]
+	if !ok {
+		return
+	}
+	if _, ok := subs[ch]; !ok {
+		return
+	}
+	delete(subs, ch)
+	close(ch)
+	if len(subs) == 0 {
+		delete(h.subs, topic)
+	}
+}
+
+func (h *eventHub) publish(events []storage.Event) {
+	if len(events) == 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, e := range events {
+		topic := topicOf(e)
+		for ch := range h.subs[topic] {
+			select {
+			case ch <- e:
+			default:
+				h.remove(topic, ch)
+			}
+		}
+	}
+}
+
+// handleRoomEvents streams events of the room as server-sent events until
+// the client disconnects.
+func (s *Server) handleRoomEvents(c *gin.Context) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	s.rwm.RLock()
+	_, err = s.memberRoom(c, id)
+	s.rwm.RUnlock()
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	username, _ := c.Get("username")
+	defer s.presence.connect(username.(string))()
+	ch := s.hub.subscribe(roomTopic(id))
+	defer s.hub.unsubscribe(roomTopic(id), ch)
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return false
+			}
+			if e.Type == storage.EventTyping {
+				if !s.isRoomMember(c, id) {
+					return false
+				}
+				c.SSEvent(string(e.Type), gin.H{"username": e.UserName})
+				return true
+			}
+			msg, ok := s.renderEvent(c, e)
+			if !ok {
+				return false
+			}
+			c.SSEvent(string(e.Type), msg)
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// memberRoom returns the room if the user of the request is a member of it,
+// the same check as /message/retrieve. It must be called with rwm held.
+func (s *Server) memberRoom(c *gin.Context, id int) (*storage.Room, error) {
+	user := s.currentUser(c)
+	if user == nil {
+		return nil, storage.ErrUserNotExists
+	}
+	if !user.IsMember(id) {
+		return nil, storage.ErrNotRoomMember
+	}
+	room, ok := s.storage.Rooms[id]
+	if !ok {
+		return nil, errors.New("room not exists")
+	}
+	return room, nil
+}
+
+// isRoomMember returns whether the user of the request is still a member of
+// the room.
+func (s *Server) isRoomMember(c *gin.Context, id int) bool {
+	s.rwm.RLock()
+	defer s.rwm.RUnlock()
+	_, err := s.memberRoom(c, id)
+	return err == nil
+}
+
+// renderEvent returns the message of the event, or false if the user of the
+// request is not a member of the room any more, e.g. it has left the room.
+func (s *Server) renderEvent(c *gin.Context, e storage.Event) (respMessage, bool) {
+	s.rwm.RLock()
+	defer s.rwm.RUnlock()
+	room, err := s.memberRoom(c, e.RoomID)
+	if err != nil {
+		return respMessage{}, false
+	}
+	return toRespMessage(room, &e.Message), true
+}
@@ -0,0 +1,68 @@
+package app
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoomEventsMembersOnly(t *testing.T) {
+	s := newTestServer(t, testConfig(t))
+	alice := s.login(t, "alice")
+	bob := s.login(t, "bob")
+	w := s.request(http.MethodPost, "/room", bearer(alice), strings.NewReader(`{"name":"lobby"}`))
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to create room: %s", w.Body)
+	}
+	events := "/room/" + w.Body.String() + "/events"
+	if w := s.request(http.MethodPut, "/room/"+w.Body.String()+"/enter", bearer(alice), nil); w.Code != http.StatusOK {
+		t.Fatalf("failed to enter room: %s", w.Body)
+	}
+
+	if w := s.request(http.MethodGet, events, nil, nil); w.Code == http.StatusOK {
+		t.Fatal("anonymous users should not watch the room")
+	}
+	if w := s.request(http.MethodGet, events, bearer(bob), nil); w.Code == http.StatusOK {
+		t.Fatal("users out of the room should not watch it")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url+events, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+alice)
+	respC := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Error(err)
+		}
+		respC <- resp
+	}()
+	// Keep sending until the subscription is set up and the event arrives.
+	var resp *http.Response
+	for received := false; !received; {
+		w := s.request(http.MethodPost, "/message/send", bearer(alice), strings.NewReader(`{"text":"hello"}`))
+		if w.Code != http.StatusOK {
+			t.Fatalf("failed to send message: %s", w.Body)
+		}
+		select {
+		case resp = <-respC:
+			received = true
+		case <-time.After(time.Millisecond * 50):
+		}
+	}
+	if resp == nil {
+		t.FailNow()
+	}
+	defer resp.Body.Close()
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if resp.StatusCode != http.StatusOK || err != nil || line != "event:message\n" {
+		t.Fatalf("members should watch the room, got %d %q: %v", resp.StatusCode, line, err)
+	}
+}
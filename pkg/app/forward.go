@@ -0,0 +1,82 @@
+package app
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// forwardedHeader marks requests forwarded from another member, so that they
+// are never forwarded again. It is only trusted if the request is signed by
+// the member, see signPeerRequest.
+const forwardedHeader = "X-Groupchat-Forwarded"
+
+// leaderURL returns the url of the leader, or an empty string if the leader
+// is unknown.
+func (s *Server) leaderURL() string {
+	lead := s.node.Lead()
+	for _, peer := range s.node.Members() {
+		if peer.ID == lead {
+			return peer.URL
+		}
+	}
+	return ""
+}
+
+// forwardToLeader forwards the request to the leader if this member is a
+// follower, so that timestamps of the proposed command are assigned by the
+// clock of the leader. The request is handled locally if the leader is
+// unknown, e.g. during an election. It fails if the leader can't be reached
+// rather than being handled locally, since the leader may have applied it.
+func (s *Server) forwardToLeader(c *gin.Context) {
+	if len(c.GetHeader(forwardedHeader)) > 0 {
+		if s.isPeer(c) {
+			return
+		}
+		// Clients must not skip the forwarding by themselves.
+		c.Request.Header.Del(forwardedHeader)
+	}
+	if s.node.IsLead() {
+		return
+	}
+	leaderURL := s.leaderURL()
+	if len(leaderURL) == 0 {
+		return
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		writeError(c, err)
+		c.Abort()
+		return
+	}
+	logger := s.lg.With(zap.String("leader-url", leaderURL), zap.String("path", c.Request.URL.Path))
+	req, err := http.NewRequestWithContext(c.Request.Context(), c.Request.Method,
+		leaderURL+c.Request.URL.RequestURI(), bytes.NewReader(body))
+	if err != nil {
+		writeError(c, err)
+		c.Abort()
+		return
+	}
+	req.Header = c.Request.Header.Clone()
+	req.Header.Set(forwardedHeader, s.node.ID().String())
+	s.signPeerRequest(req, body)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		logger.Warn("failed to forward request to leader", zap.Error(err))
+		writeError(c, err)
+		c.Abort()
+		return
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		writeError(c, err)
+		c.Abort()
+		return
+	}
+	c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), data)
+	c.Abort()
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestForwardToLeader(t *testing.T) {
+	leader := newTestServer(t, testConfig(t))
+	follower := joinTestServer(t, leader, testConfig(t))
+	token := leader.login(t, "alice")
+	w := leader.request(http.MethodPost, "/room", bearer(token), strings.NewReader(`{"name":"lobby"}`))
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to create room: %s", w.Body)
+	}
+	if w := leader.request(http.MethodPut, "/room/"+w.Body.String()+"/enter", bearer(token), nil); w.Code != http.StatusOK {
+		t.Fatalf("failed to enter room: %s", w.Body)
+	}
+
+	const body = `{"text":"hi"}`
+	send := func(header http.Header) {
+		t.Helper()
+		header.Set("Authorization", "Bearer "+token)
+		if w := follower.request(http.MethodPost, "/message/send", header, strings.NewReader(body)); w.Code != http.StatusOK {
+			t.Fatalf("failed to send message: %s", w.Body)
+		}
+	}
+	send(http.Header{})
+	if n := leader.receivedOf("/message/send"); n != 1 {
+		t.Fatalf("request should be forwarded to the leader, the leader received %d", n)
+	}
+	// Clients can't mark their requests as forwarded.
+	send(http.Header{forwardedHeader: []string{"1"}})
+	if n := leader.receivedOf("/message/send"); n != 2 {
+		t.Fatalf("request with forged header should be forwarded, the leader received %d", n)
+	}
+	send(http.Header{forwardedHeader: []string{"1"}, peerHeader: []string{strings.Repeat("00", 32)}})
+	if n := leader.receivedOf("/message/send"); n != 3 {
+		t.Fatalf("request with forged signature should be forwarded, the leader received %d", n)
+	}
+
+	// Requests signed by a member are handled by the receiver.
+	header := leader.signed(http.MethodPost, "/message/send", body)
+	header.Set(forwardedHeader, "1")
+	send(header.Clone())
+	if n := leader.receivedOf("/message/send"); n != 3 {
+		t.Fatalf("forwarded request should be handled locally, the leader received %d", n)
+	}
+
+	// Replayed, tampered and stale requests are not trusted.
+	send(header.Clone())
+	tampered := leader.signed(http.MethodPost, "/message/send", `{"text":"bye"}`)
+	tampered.Set(forwardedHeader, "1")
+	send(tampered)
+	stale := leader.signed(http.MethodPost, "/message/send", body)
+	stale.Set(forwardedHeader, "1")
+	stale.Set(peerTimestampHeader, strconv.FormatInt(time.Now().Add(-peerRequestMaxAge*2).UnixMilli(), 10))
+	send(stale)
+	if n := leader.receivedOf("/message/send"); n != 6 {
+		t.Fatalf("untrusted requests should be forwarded, the leader received %d", n)
+	}
+}
@@ -2,6 +2,7 @@ package app
 
 import (
 	"bytes"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -53,14 +54,14 @@ outer:
 		return
 	}
 	//goland:noinspection HttpUrlsUsage
-	localURL := fmt.Sprintf("http://%s:%d", localIP, s.port)
+	localURL := fmt.Sprintf("http://%s:%d", localIP, s.cfg.Port)
 	var remoteURLs []string
 	for _, ip := range clusterIPs {
 		if ip == localIP {
 			continue
 		}
 		//goland:noinspection HttpUrlsUsage
-		remoteURL := fmt.Sprintf("http://%s:%d", ip, s.port)
+		remoteURL := fmt.Sprintf("http://%s:%d", ip, s.cfg.Port)
 		remoteURLs = append(remoteURLs, remoteURL)
 		if len(c.GetHeader("Referer")) == 0 {
 			go func() {
@@ -96,7 +97,7 @@ outer:
 		zap.Strings("remote-urls", remoteURLs),
 	)
 	go s.bootstrap(func() *raftnode.Node {
-		return raftnode.NewRaftNode(s.lg, localURL, remoteURLs, s.dataDir)
+		return raftnode.NewRaftNode(s.lg, s.cfg.Raft, localURL, remoteURLs, s.cfg.DataDir)
 	})
 }
 
@@ -163,20 +164,86 @@ func (s *Server) handleUserLogin(c *gin.Context) {
 		writeError(c, errors.New("password is wrong"))
 		return
 	}
-	token := generateToken(username, s.aead)
+	token := generateToken(username, user.TokenEpoch, s.aead)
 	c.Data(http.StatusOK, "text/plain", []byte(token))
 }
 
+func (s *Server) handleUserUpdate(c *gin.Context) {
+	var user struct {
+		FirstName *string `json:"firstName"`
+		LastName  *string `json:"lastName"`
+		Email     *string `json:"email"`
+		Phone     *string `json:"phone"`
+	}
+	if err := c.ShouldBindJSON(&user); err != nil {
+		writeError(c, err)
+		return
+	}
+	username, _ := c.Get("username")
+	if _, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
+		UpdateUser: &storage.UpdateUserCommand{
+			UserName:  username.(string),
+			FirstName: user.FirstName,
+			LastName:  user.LastName,
+			Email:     user.Email,
+			Phone:     user.Phone,
+		},
+	}); err != nil {
+		writeError(c, err)
+	}
+}
+
+func (s *Server) handleUserChangePassword(c *gin.Context) {
+	var req struct {
+		OldPassword string `json:"oldPassword"`
+		NewPassword string `json:"newPassword"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, err)
+		return
+	}
+	username, _ := c.Get("username")
+	result, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
+		ChangePassword: &storage.ChangePasswordCommand{
+			UserName:    username.(string),
+			OldPassword: req.OldPassword,
+			NewPassword: req.NewPassword,
+		},
+	})
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	// All tokens of the user are revoked, return a new one.
+	token := generateToken(username.(string), result.(uint64), s.aead)
+	c.Data(http.StatusOK, "text/plain", []byte(token))
+}
+
+func (s *Server) handleUserDelete(c *gin.Context) {
+	username, _ := c.Get("username")
+	if _, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
+		DeleteUser: &storage.DeleteUserCommand{UserName: username.(string)},
+	}); err != nil {
+		writeError(c, err)
+	}
+}
+
 func (s *Server) handleRoomCreate(c *gin.Context) {
 	var room struct {
-		Name string `json:"name"`
+		Name    string `json:"name"`
+		Private bool   `json:"private"`
 	}
 	if err := c.ShouldBindJSON(&room); err != nil {
 		writeError(c, err)
 		return
 	}
+	username, _ := c.Get("username")
 	result, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
-		CreateRoom: &storage.CreateRoomCommand{Name: room.Name},
+		CreateRoom: &storage.CreateRoomCommand{
+			Name:    room.Name,
+			Owner:   username.(string),
+			Private: room.Private,
+		},
 	})
 	if err != nil {
 		writeError(c, err)
@@ -195,8 +262,8 @@ func (s *Server) handleRoomQuery(c *gin.Context) {
 	}
 	s.rwm.RLock()
 	defer s.rwm.RUnlock()
-	room, ok := s.storage.Rooms[int(id)]
-	if !ok {
+	room, ok := s.storage.Room(int(id))
+	if !ok || !room.CanAccess(s.currentUser(c)) {
 		writeError(c, errors.New("room not exists"))
 		return
 	}
@@ -211,7 +278,15 @@ func (s *Server) handleRoomList(c *gin.Context) {
 	}
 	s.rwm.RLock()
 	defer s.rwm.RUnlock()
-	size := len(s.storage.RoomList)
+	// Private rooms are only listed for their members.
+	user := s.currentUser(c)
+	rooms := make([]*storage.Room, 0, len(s.storage.RoomList))
+	for _, room := range s.storage.RoomList {
+		if room.CanAccess(user) {
+			rooms = append(rooms, room)
+		}
+	}
+	size := len(rooms)
 	start, end := convertPageToRange(size, pageIndex, pageSize)
 	type RespRoom struct {
 		Name string `json:"name"`
@@ -219,7 +294,7 @@ func (s *Server) handleRoomList(c *gin.Context) {
 	}
 	respRooms := make([]RespRoom, end-start)
 	for i := end - 1; i >= start; i-- {
-		room := s.storage.RoomList[i]
+		room := rooms[i]
 		respRooms[end-1-i] = RespRoom{
 			Name: room.Name,
 			ID:   strconv.Itoa(room.ID),
@@ -237,8 +312,8 @@ func (s *Server) handleRoomListUsers(c *gin.Context) {
 	}
 	s.rwm.RLock()
 	defer s.rwm.RUnlock()
-	room, ok := s.storage.Rooms[int(id)]
-	if !ok {
+	room, ok := s.storage.Room(int(id))
+	if !ok || !room.CanAccess(s.currentUser(c)) {
 		writeError(c, errors.New("room not exists"))
 		return
 	}
@@ -258,7 +333,11 @@ func (s *Server) handleRoomEnter(c *gin.Context) {
 		return
 	}
 	if _, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
-		EnterRoom: &storage.EnterRoomCommand{UserName: username.(string), RoomID: int(id)},
+		EnterRoom: &storage.EnterRoomCommand{
+			UserName: username.(string),
+			RoomID:   int(id),
+			TS:       int(time.Now().Unix()),
+		},
 	}); err != nil {
 		writeError(c, err)
 	}
@@ -273,22 +352,169 @@ func (s *Server) handleRoomLeave(c *gin.Context) {
 	}
 }
 
+func (s *Server) handleRoomJoin(c *gin.Context) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	username, _ := c.Get("username")
+	if _, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
+		JoinRoom: &storage.JoinRoomCommand{
+			UserName: username.(string),
+			RoomID:   id,
+			TS:       int(time.Now().Unix()),
+		},
+	}); err != nil {
+		writeError(c, err)
+	}
+}
+
+func (s *Server) handleRoomLeaveByID(c *gin.Context) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	username, _ := c.Get("username")
+	if _, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
+		LeaveRoom: &storage.LeaveRoomCommand{UserName: username.(string), RoomID: id},
+	}); err != nil {
+		writeError(c, err)
+	}
+}
+
+func (s *Server) handleMyRooms(c *gin.Context) {
+	username, _ := c.Get("username")
+	s.rwm.RLock()
+	defer s.rwm.RUnlock()
+	user, ok := s.storage.Users[username.(string)]
+	if !ok {
+		writeError(c, storage.ErrUserNotExists)
+		return
+	}
+	type RespRoom struct {
+		Name    string `json:"name"`
+		ID      string `json:"id"`
+		Current bool   `json:"current"`
+		Unread  int    `json:"unread"`
+	}
+	respRooms := make([]RespRoom, 0, len(user.Rooms))
+	for _, id := range user.Rooms {
+		if room, ok := s.storage.Rooms[id]; ok {
+			respRooms = append(respRooms, RespRoom{
+				Name:    room.Name,
+				ID:      strconv.Itoa(room.ID),
+				Current: room.ID == user.RoomID,
+				Unread:  room.UnreadCount(user),
+			})
+		}
+	}
+	c.JSON(http.StatusOK, respRooms)
+}
+
+func parseRoomID(c *gin.Context) (int, error) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return 0, errors.New("room not exists")
+	}
+	return int(id), nil
+}
+
+func (s *Server) handleRoomRename(c *gin.Context) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	var room struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&room); err != nil {
+		writeError(c, err)
+		return
+	}
+	username, _ := c.Get("username")
+	if _, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
+		RenameRoom: &storage.RenameRoomCommand{
+			UserName: username.(string),
+			Admin:    s.isAdmin(c),
+			RoomID:   id,
+			Name:     room.Name,
+		},
+	}); err != nil {
+		writeError(c, err)
+	}
+}
+
+func (s *Server) handleRoomArchive(c *gin.Context) {
+	s.archiveRoom(c, true)
+}
+
+func (s *Server) handleRoomUnarchive(c *gin.Context) {
+	s.archiveRoom(c, false)
+}
+
+func (s *Server) archiveRoom(c *gin.Context, archived bool) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	username, _ := c.Get("username")
+	if _, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
+		ArchiveRoom: &storage.ArchiveRoomCommand{
+			UserName: username.(string),
+			Admin:    s.isAdmin(c),
+			RoomID:   id,
+			Archived: archived,
+		},
+	}); err != nil {
+		writeError(c, err)
+	}
+}
+
+func (s *Server) handleRoomDelete(c *gin.Context) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	username, _ := c.Get("username")
+	if _, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
+		DeleteRoom: &storage.DeleteRoomCommand{UserName: username.(string), Admin: s.isAdmin(c), RoomID: id},
+	}); err != nil {
+		writeError(c, err)
+	}
+}
+
 func (s *Server) handleMessageSend(c *gin.Context) {
 	var msg struct {
 		ID   string `json:"id"`
 		Text string `json:"text"`
+		// RoomID defaults to the current room of the user.
+		RoomID int `json:"roomId"`
+		// ReplyTo is the id of the message to reply to.
+		ReplyTo string `json:"replyTo"`
+		// Attachments are the ids of uploaded attachments.
+		Attachments []string `json:"attachments"`
 	}
 	if err := c.ShouldBindJSON(&msg); err != nil {
 		writeError(c, err)
 		return
 	}
 	username, _ := c.Get("username")
+	now := time.Now()
 	if _, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
 		SendMessage: &storage.SendMessageCommand{
-			ID:       msg.ID,
-			TS:       int(time.Now().Unix()),
-			Text:     msg.Text,
-			UserName: username.(string),
+			ID:          msg.ID,
+			TS:          int(now.Unix()),
+			TSMilli:     now.UnixMilli(),
+			Text:        msg.Text,
+			UserName:    username.(string),
+			RoomID:      msg.RoomID,
+			ReplyTo:     msg.ReplyTo,
+			Attachments: msg.Attachments,
 		},
 	}); err != nil {
 		writeError(c, err)
@@ -296,17 +522,33 @@ func (s *Server) handleMessageSend(c *gin.Context) {
 }
 
 func (s *Server) handleMessageRetrieve(c *gin.Context) {
-	pageIndex, pageSize, err := parseRequestPage(c)
-	if err != nil {
+	var req struct {
+		PageIndex int `json:"pageIndex"`
+		PageSize  int `json:"pageSize"`
+		// RoomID defaults to the current room of the user.
+		RoomID int `json:"roomId"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
 		writeError(c, err)
 		return
 	}
 	username, _ := c.Get("username")
 	s.rwm.RLock()
 	defer s.rwm.RUnlock()
-	roomID := s.storage.Users[username.(string)].RoomID
-	if roomID <= 0 {
-		writeError(c, errors.New("user out of room"))
+	user, ok := s.storage.Users[username.(string)]
+	if !ok {
+		writeError(c, storage.ErrUserNotExists)
+		return
+	}
+	roomID := req.RoomID
+	if roomID == 0 {
+		if user.RoomID <= 0 {
+			writeError(c, storage.ErrUserOutOfRoom)
+			return
+		}
+		roomID = user.RoomID
+	} else if !user.IsMember(roomID) {
+		writeError(c, storage.ErrNotRoomMember)
 		return
 	}
 	room, ok := s.storage.Rooms[roomID]
@@ -314,45 +556,81 @@ func (s *Server) handleMessageRetrieve(c *gin.Context) {
 		writeError(c, errors.New("room not exists"))
 		return
 	}
-	size := len(room.Messages)
-	start, end := convertPageToRange(size, pageIndex, pageSize)
-	type RespMsg struct {
-		ID        string `json:"id"`
-		Text      string `json:"text"`
-		Timestamp string `json:"timestamp"`
-	}
-	respMsgs := make([]RespMsg, end-start)
+	size := room.MessageCount()
+	start, end := convertPageToRange(size, req.PageIndex, req.PageSize)
+	respMsgs := make([]respMessage, end-start)
 	for i := end - 1; i >= start; i-- {
-		msg := room.Messages[i]
-		respMsgs[end-1-i] = RespMsg{
-			ID:        msg.ID,
-			Text:      msg.Text,
-			Timestamp: strconv.Itoa(msg.TS),
-		}
+		respMsgs[end-1-i] = toRespMessage(room, room.MessageAt(i))
 	}
 	c.JSON(http.StatusOK, respMsgs)
 }
 
-func (s *Server) authRequired(c *gin.Context) {
+// authenticate returns the name of the user authenticated by the token in
+// the Authorization header.
+func (s *Server) authenticate(c *gin.Context) (string, error) {
 	fields := strings.Fields(c.GetHeader("Authorization"))
 	if len(fields) == 0 {
-		writeError(c, errors.New("token is missing"))
-		c.Abort()
-		return
+		return "", errors.New("token is missing")
 	}
 	token := fields[len(fields)-1]
-	if username, ok := parseUserNameFromToken(token, s.aead); ok {
-		c.Set("username", username)
-	} else {
-		writeError(c, errors.New("token is invalid"))
-		c.Abort()
+	username, epoch, ok := parseToken(token, s.aead)
+	if ok {
+		s.rwm.RLock()
+		user, exists := s.storage.Users[username]
+		ok = exists && user.TokenEpoch == epoch
+		s.rwm.RUnlock()
+	}
+	if !ok {
+		return "", errors.New("token is invalid")
 	}
+	return username, nil
 }
 
-func (s *Server) linearizableReadRequired(c *gin.Context) {
-	if err := s.linearizableReadNotify(c.Request.Context()); err != nil {
+func (s *Server) authRequired(c *gin.Context) {
+	username, err := s.authenticate(c)
+	if err != nil {
 		writeError(c, err)
 		c.Abort()
+		return
+	}
+	c.Set("username", username)
+	s.presence.touch(username)
+}
+
+// authOptional authenticates the user if a token is given, it is used by
+// requests which show more to members of private rooms.
+func (s *Server) authOptional(c *gin.Context) {
+	if len(c.GetHeader("Authorization")) == 0 {
+		return
+	}
+	s.authRequired(c)
+}
+
+// currentUser returns the authenticated user, or nil for anonymous requests.
+// It must be called with s.rwm held.
+func (s *Server) currentUser(c *gin.Context) *storage.User {
+	username, ok := c.Get("username")
+	if !ok {
+		return nil
+	}
+	return s.storage.Users[username.(string)]
+}
+
+// adminHeader carries the admin token of cluster management requests.
+const adminHeader = "X-Groupchat-Admin-Token"
+
+// isAdmin returns whether the request carries the admin token, no request
+// does if the admin token is not configured.
+func (s *Server) isAdmin(c *gin.Context) bool {
+	token := c.GetHeader(adminHeader)
+	return len(s.cfg.AdminToken) > 0 &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.AdminToken)) == 1
+}
+
+func (s *Server) adminRequired(c *gin.Context) {
+	if !s.isAdmin(c) {
+		writeError(c, errors.New("admin token is invalid"))
+		c.Abort()
 	}
 }
 
@@ -368,28 +646,98 @@ func (s *Server) newChatRouter() *gin.Engine {
 	router.Use(gin.Recovery())
 
 	router.POST("/updateCluster", s.handleClusterUpdate)
+	router.POST("/joinCluster", s.adminRequired, s.handleClusterJoin)
 
 	// The follow requests must be sent after the cluster is started.
 	router.Use(s.clusterStartedRequired)
 
 	router.GET("/checkCluster", s.handleClusterCheck)
 
+	// Cluster API.
+	router.GET("/cluster/status", s.handleClusterStatus)
+	router.GET("/cluster/members", s.handleClusterMembers)
+	router.POST("/cluster/members", s.adminRequired, s.handleClusterAddMember)
+	router.DELETE("/cluster/members/:id", s.adminRequired, s.handleClusterRemoveMember)
+	router.PUT("/cluster/leader/:id", s.adminRequired, s.handleClusterTransferLeader)
+	router.POST("/cluster/snapshot", s.adminRequired, s.handleClusterSnapshot)
+	router.GET("/cluster/presence", s.peerRequired, s.handleClusterPresence)
+	router.POST("/cluster/typing", s.peerRequired, s.handleClusterTyping)
+	router.GET("/cluster/blobs/:id", s.peerRequired, s.handleClusterBlob)
+	router.GET("/cluster/backup", s.adminRequired, s.handleClusterBackup)
+
 	// User API.
 	router.POST("/user", s.handleUserCreate)
-	router.GET("/user/:name", s.linearizableReadRequired, s.handleUserQuery)
+	router.GET("/user/:name", s.readConsistencyRequired, s.handleUserQuery)
 	router.GET("/userLogin", s.linearizableReadRequired, s.handleUserLogin)
+	router.PUT("/user", s.authRequired, s.handleUserUpdate)
+	router.PUT("/user/password", s.authRequired, s.handleUserChangePassword)
+	router.DELETE("/user", s.authRequired, s.handleUserDelete)
 
 	// Room API.
 	router.POST("/room", s.authRequired, s.handleRoomCreate)
-	router.GET("/room/:id", s.linearizableReadRequired, s.handleRoomQuery)
-	router.POST("/roomList", s.linearizableReadRequired, s.handleRoomList)
-	router.GET("/room/:id/users", s.linearizableReadRequired, s.handleRoomListUsers)
+	router.GET("/room/:id", s.readConsistencyRequired, s.authOptional, s.handleRoomQuery)
+	router.POST("/roomList", s.readConsistencyRequired, s.authOptional, s.handleRoomList)
+	router.GET("/room/:id/users", s.readConsistencyRequired, s.authOptional, s.handleRoomListUsers)
 	router.PUT("/room/:id/enter", s.authRequired, s.handleRoomEnter)
 	router.PUT("/roomLeave", s.authRequired, s.handleRoomLeave)
+	router.PUT("/room/:id/join", s.authRequired, s.handleRoomJoin)
+	router.PUT("/room/:id/leave", s.authRequired, s.handleRoomLeaveByID)
+	router.GET("/me/rooms", s.readConsistencyRequired, s.authRequired, s.handleMyRooms)
+	router.GET("/search", s.readConsistencyRequired, s.authRequired, s.handleSearch)
+	router.GET("/me/mentions", s.readConsistencyRequired, s.authRequired, s.handleMyMentions)
+	router.GET("/me/events", s.authRequired, s.handleMyEvents)
+	router.POST("/room/:id/read", s.authRequired, s.handleRoomRead)
+	router.GET("/room/:id/read", s.readConsistencyRequired, s.authRequired, s.handleRoomReceipts)
+	router.PUT("/room/:id/visibility", s.authRequired, s.handleRoomVisibility)
+	router.POST("/room/:id/invites", s.authRequired, s.handleRoomInvite)
+	router.GET("/room/:id/invites", s.readConsistencyRequired, s.authRequired, s.handleRoomListInvites)
+	router.POST("/room/:id/requests", s.authRequired, s.handleRoomRequestJoin)
+	router.GET("/room/:id/requests", s.readConsistencyRequired, s.authRequired, s.handleRoomListJoinRequests)
+	router.PUT("/room/:id/requests/:username", s.authRequired, s.handleRoomApproveJoinRequest)
+	router.DELETE("/room/:id/requests/:username", s.authRequired, s.handleRoomRejectJoinRequest)
+	router.GET("/me/invites", s.readConsistencyRequired, s.authRequired, s.handleMyInvites)
+	router.PUT("/room/:id/name", s.authRequired, s.handleRoomRename)
+	router.PUT("/room/:id/archive", s.authRequired, s.handleRoomArchive)
+	router.DELETE("/room/:id/archive", s.authRequired, s.handleRoomUnarchive)
+	router.DELETE("/room/:id", s.authRequired, s.handleRoomDelete)
+
+	// Moderation API.
+	router.GET("/room/:id/moderators", s.readConsistencyRequired, s.authOptional, s.handleRoomListModerators)
+	router.PUT("/room/:id/moderators/:username", s.authRequired, s.handleRoomAddModerator)
+	router.DELETE("/room/:id/moderators/:username", s.authRequired, s.handleRoomRemoveModerator)
+	router.PUT("/room/:id/kick/:username", s.authRequired, s.handleRoomKick)
+	router.GET("/room/:id/bans", s.readConsistencyRequired, s.authRequired, s.handleRoomListBans)
+	router.PUT("/room/:id/bans/:username", s.authRequired, s.handleRoomBan)
+	router.DELETE("/room/:id/bans/:username", s.authRequired, s.handleRoomUnban)
+	router.GET("/room/:id/mutes", s.readConsistencyRequired, s.authRequired, s.handleRoomListMutes)
+	router.PUT("/room/:id/mutes/:username", s.authRequired, s.handleRoomMute)
+	router.DELETE("/room/:id/mutes/:username", s.authRequired, s.handleRoomUnmute)
+
+	// Direct message API.
+	router.POST("/dm/:username", s.authRequired, s.forwardToLeader, s.handleDirectSend)
+	router.GET("/dm/:username/messages", s.readConsistencyRequired, s.authRequired, s.handleDirectRetrieve)
+
+	// Retention API.
+	router.GET("/retention", s.readConsistencyRequired, s.handleRetentionQuery)
+	router.PUT("/retention", s.adminRequired, s.handleRetentionUpdate)
+	router.GET("/room/:id/retention", s.readConsistencyRequired, s.handleRoomRetentionQuery)
+	router.PUT("/room/:id/retention", s.authRequired, s.handleRoomRetentionUpdate)
+	router.DELETE("/room/:id/retention", s.authRequired, s.handleRoomRetentionDelete)
 
 	// Message API.
-	router.POST("/message/send", s.authRequired, s.handleMessageSend)
-	router.POST("/message/retrieve", s.linearizableReadRequired, s.authRequired, s.handleMessageRetrieve)
+	router.POST("/message/send", s.authRequired, s.forwardToLeader, s.handleMessageSend)
+	router.POST("/attachments", s.authRequired, s.handleAttachmentUpload)
+	router.GET("/attachments/:id", s.readConsistencyRequired, s.authRequired, s.handleAttachmentDownload)
+	router.POST("/message/retrieve", s.readConsistencyRequired, s.authRequired, s.handleMessageRetrieve)
+	router.PUT("/room/:id/messages/:msgid", s.authRequired, s.handleMessageEdit)
+	router.DELETE("/room/:id/messages/:msgid", s.authRequired, s.handleMessageDelete)
+	router.GET("/room/:id/messages/:msgid/revisions", s.readConsistencyRequired, s.authRequired, s.handleMessageRevisions)
+	router.GET("/room/:id/messages/:msgid/replies", s.readConsistencyRequired, s.authRequired, s.handleMessageReplies)
+	router.PUT("/room/:id/messages/:msgid/reactions/:emoji", s.authRequired, s.handleReactionAdd)
+	router.DELETE("/room/:id/messages/:msgid/reactions/:emoji", s.authRequired, s.handleReactionRemove)
+	router.GET("/room/:id/events", s.authRequired, s.handleRoomEvents)
+	router.POST("/room/:id/typing", s.authRequired, s.handleRoomTyping)
+	router.GET("/room/:id/presence", s.readConsistencyRequired, s.authOptional, s.handleRoomPresence)
 
 	return router
 }
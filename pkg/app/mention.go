@@ -0,0 +1,119 @@
+package app
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/gozssky/groupchat/pkg/storage"
+)
+
+// maxMentionsLimit limits the number of mentions returned by a request.
+const maxMentionsLimit = 100
+
+type respMention struct {
+	// Cursor is passed back to get the mentions before this one.
+	Cursor  string      `json:"cursor"`
+	RoomID  string      `json:"roomId"`
+	Message respMessage `json:"message"`
+}
+
+// toRespMention must be called with rwm held, it returns false if the user
+// can't access the room any more or the message is pruned or deleted.
+func (s *Server) toRespMention(user *storage.User, seq uint64, roomID int, msgSeq uint64) (respMention, bool) {
+	room, ok := s.storage.Room(roomID)
+	if !ok || !room.CanAccess(user) {
+		return respMention{}, false
+	}
+	msg := room.MessageBySeq(msgSeq)
+	if msg == nil || msg.Deleted {
+		return respMention{}, false
+	}
+	return respMention{
+		Cursor:  strconv.FormatUint(seq, 10),
+		RoomID:  strconv.Itoa(room.ID),
+		Message: toRespMessage(room, msg),
+	}, true
+}
+
+// handleMyMentions writes the messages mentioning the user, latest first.
+// Mentions of messages which are no longer visible are skipped.
+func (s *Server) handleMyMentions(c *gin.Context) {
+	var cursor uint64
+	if v := c.Query("cursor"); len(v) > 0 {
+		var err error
+		if cursor, err = strconv.ParseUint(v, 10, 64); err != nil {
+			writeError(c, errors.New("invalid cursor"))
+			return
+		}
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 || limit > maxMentionsLimit {
+		writeError(c, errors.New("invalid limit"))
+		return
+	}
+	s.rwm.RLock()
+	defer s.rwm.RUnlock()
+	user := s.currentUser(c)
+	if user == nil {
+		writeError(c, storage.ErrUserNotExists)
+		return
+	}
+	type RespMentions struct {
+		Mentions []respMention `json:"mentions"`
+		// NextCursor is empty if there are no more mentions.
+		NextCursor string `json:"nextCursor,omitempty"`
+	}
+	resp := RespMentions{Mentions: make([]respMention, 0, limit)}
+	mentions := user.MentionsBefore(cursor)
+	i := len(mentions) - 1
+	for ; i >= 0 && len(resp.Mentions) < limit; i-- {
+		m := mentions[i]
+		if mention, ok := s.toRespMention(user, m.Seq, m.RoomID, m.MessageSeq); ok {
+			resp.Mentions = append(resp.Mentions, mention)
+		}
+	}
+	if i >= 0 {
+		resp.NextCursor = strconv.FormatUint(mentions[i+1].Seq, 10)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// handleMyEvents streams the mentions of the user as server-sent events until
+// the client disconnects.
+func (s *Server) handleMyEvents(c *gin.Context) {
+	username, _ := c.Get("username")
+	defer s.presence.connect(username.(string))()
+	topic := userTopic(username.(string))
+	ch := s.hub.subscribe(topic)
+	defer s.hub.unsubscribe(topic, ch)
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return false
+			}
+			if mention, ok := s.renderMention(c, e); ok {
+				c.SSEvent(string(e.Type), mention)
+			}
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// renderMention returns the mention of the event, or false if the message is
+// no longer visible to the user.
+func (s *Server) renderMention(c *gin.Context, e storage.Event) (respMention, bool) {
+	s.rwm.RLock()
+	defer s.rwm.RUnlock()
+	user := s.currentUser(c)
+	if user == nil {
+		return respMention{}, false
+	}
+	return s.toRespMention(user, e.MentionSeq, e.RoomID, e.Message.Seq)
+}
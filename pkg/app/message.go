@@ -0,0 +1,246 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/gozssky/groupchat/pkg/storage"
+)
+
+// respMessage is a message in responses, fields added after the first
+// version of the API are omitted if they are empty.
+type respMessage struct {
+	ID        string `json:"id"`
+	Text      string `json:"text"`
+	Timestamp string `json:"timestamp"`
+	// TimestampMs and Author are empty for messages sent by old versions.
+	TimestampMs string `json:"timestampMs,omitempty"`
+	Author      string `json:"author,omitempty"`
+	Edited      bool   `json:"edited,omitempty"`
+	Deleted     bool   `json:"deleted,omitempty"`
+	// ReplyTo is the id of the root message of the thread for replies, and
+	// ReplyCount is the number of replies for root messages.
+	ReplyTo    string `json:"replyTo,omitempty"`
+	ReplyCount int    `json:"replyCount,omitempty"`
+	// Reactions maps emojis to the number of users who reacted with them.
+	Reactions map[string]int `json:"reactions,omitempty"`
+	// Attachments are the ids of the attachments, their content is served
+	// by /attachments/{id}.
+	Attachments []string `json:"attachments,omitempty"`
+}
+
+// formatTimestampMs returns the timestamp in milliseconds, or an empty string
+// if it is not recorded.
+func formatTimestampMs(ms int64) string {
+	if ms == 0 {
+		return ""
+	}
+	return strconv.FormatInt(ms, 10)
+}
+
+// toRespMessage must be called with rwm held since it looks up the thread of
+// the message in the room.
+func toRespMessage(room *storage.Room, msg *storage.Message) respMessage {
+	resp := respMessage{
+		ID:          msg.ID,
+		Text:        msg.Text,
+		Timestamp:   strconv.Itoa(msg.TS),
+		TimestampMs: formatTimestampMs(msg.TSMilli),
+		Author:      msg.Author,
+		Edited:      msg.EditTS > 0 && !msg.Deleted,
+		Deleted:     msg.Deleted,
+		ReplyCount:  room.ReplyCount(msg.Seq),
+		Reactions:   msg.ReactionCounts(),
+		Attachments: msg.Attachments,
+	}
+	if msg.ReplyTo != 0 {
+		if root := room.MessageBySeq(msg.ReplyTo); root != nil {
+			resp.ReplyTo = root.ID
+		}
+	}
+	return resp
+}
+
+func (s *Server) handleMessageEdit(c *gin.Context) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	var msg struct {
+		Text string `json:"text"`
+	}
+	if err := c.ShouldBindJSON(&msg); err != nil {
+		writeError(c, err)
+		return
+	}
+	username, _ := c.Get("username")
+	if _, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
+		EditMessage: &storage.EditMessageCommand{
+			UserName: username.(string),
+			RoomID:   id,
+			ID:       c.Param("msgid"),
+			Text:     msg.Text,
+			TS:       int(time.Now().Unix()),
+		},
+	}); err != nil {
+		writeError(c, err)
+	}
+}
+
+func (s *Server) handleMessageDelete(c *gin.Context) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	username, _ := c.Get("username")
+	if _, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
+		DeleteMessage: &storage.DeleteMessageCommand{
+			UserName: username.(string),
+			RoomID:   id,
+			ID:       c.Param("msgid"),
+			TS:       int(time.Now().Unix()),
+		},
+	}); err != nil {
+		writeError(c, err)
+	}
+}
+
+func (s *Server) handleReactionAdd(c *gin.Context) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	username, _ := c.Get("username")
+	if _, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
+		AddReaction: &storage.AddReactionCommand{
+			UserName: username.(string),
+			RoomID:   id,
+			ID:       c.Param("msgid"),
+			Emoji:    c.Param("emoji"),
+			TS:       int(time.Now().Unix()),
+		},
+	}); err != nil {
+		writeError(c, err)
+	}
+}
+
+func (s *Server) handleReactionRemove(c *gin.Context) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	username, _ := c.Get("username")
+	if _, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
+		RemoveReaction: &storage.RemoveReactionCommand{
+			UserName: username.(string),
+			RoomID:   id,
+			ID:       c.Param("msgid"),
+			Emoji:    c.Param("emoji"),
+		},
+	}); err != nil {
+		writeError(c, err)
+	}
+}
+
+// handleMessageRevisions writes the edit history of a message oldest first,
+// ending with the current text.
+func (s *Server) handleMessageRevisions(c *gin.Context) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	s.rwm.RLock()
+	defer s.rwm.RUnlock()
+	user := s.currentUser(c)
+	if user == nil {
+		writeError(c, storage.ErrUserNotExists)
+		return
+	}
+	if !user.IsMember(id) {
+		writeError(c, storage.ErrNotRoomMember)
+		return
+	}
+	room, ok := s.storage.Room(id)
+	if !ok {
+		writeError(c, storage.ErrRoomNotExists)
+		return
+	}
+	msg, ok := room.MessageByID(c.Param("msgid"))
+	if !ok {
+		writeError(c, storage.ErrMessageNotExists)
+		return
+	}
+	type RespRevision struct {
+		Text      string `json:"text"`
+		Timestamp string `json:"timestamp"`
+	}
+	respRevisions := make([]RespRevision, 0, len(msg.Revisions)+1)
+	for _, r := range msg.Revisions {
+		respRevisions = append(respRevisions, RespRevision{Text: r.Text, Timestamp: strconv.Itoa(r.TS)})
+	}
+	if !msg.Deleted {
+		ts := msg.TS
+		if msg.EditTS > 0 {
+			ts = msg.EditTS
+		}
+		respRevisions = append(respRevisions, RespRevision{Text: msg.Text, Timestamp: strconv.Itoa(ts)})
+	}
+	c.JSON(http.StatusOK, respRevisions)
+}
+
+// handleMessageReplies writes a page of the replies to a message, pages are
+// counted like direct conversations.
+func (s *Server) handleMessageReplies(c *gin.Context) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	pageIndex, err := strconv.Atoi(c.DefaultQuery("pageIndex", "-1"))
+	if err != nil {
+		writeError(c, errors.New("invalid page index"))
+		return
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", "10"))
+	if err != nil {
+		writeError(c, errors.New("invalid page size"))
+		return
+	}
+	s.rwm.RLock()
+	defer s.rwm.RUnlock()
+	user := s.currentUser(c)
+	if user == nil {
+		writeError(c, storage.ErrUserNotExists)
+		return
+	}
+	if !user.IsMember(id) {
+		writeError(c, storage.ErrNotRoomMember)
+		return
+	}
+	room, ok := s.storage.Room(id)
+	if !ok {
+		writeError(c, storage.ErrRoomNotExists)
+		return
+	}
+	msg, ok := room.MessageByID(c.Param("msgid"))
+	if !ok {
+		writeError(c, storage.ErrMessageNotExists)
+		return
+	}
+	replies := room.Replies(msg.Seq)
+	start, end := convertPageToRange(len(replies), pageIndex, pageSize)
+	respMsgs := make([]respMessage, end-start)
+	for i := end - 1; i >= start; i-- {
+		respMsgs[end-1-i] = toRespMessage(room, replies[i])
+	}
+	c.JSON(http.StatusOK, respMsgs)
+}
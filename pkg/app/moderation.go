@@ -0,0 +1,208 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/gozssky/groupchat/pkg/storage"
+)
+
+// parseRestrictionUntil returns the unix time when a restriction given by the
+// optional duration query expires, 0 means it never expires.
+func parseRestrictionUntil(c *gin.Context, now int) (int, error) {
+	durationStr := c.Query("duration")
+	if len(durationStr) == 0 {
+		return 0, nil
+	}
+	duration, err := time.ParseDuration(durationStr)
+	if err != nil || duration <= 0 {
+		return 0, errors.New("invalid duration")
+	}
+	return now + int(duration/time.Second), nil
+}
+
+func (s *Server) handleRoomListModerators(c *gin.Context) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	s.rwm.RLock()
+	defer s.rwm.RUnlock()
+	room, ok := s.storage.Room(id)
+	if !ok || !room.CanAccess(s.currentUser(c)) {
+		writeError(c, errors.New("room not exists"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"owner":      room.Owner,
+		"moderators": append([]string{}, room.Moderators...),
+	})
+}
+
+func (s *Server) handleRoomAddModerator(c *gin.Context) {
+	s.setModerator(c, true)
+}
+
+func (s *Server) handleRoomRemoveModerator(c *gin.Context) {
+	s.setModerator(c, false)
+}
+
+func (s *Server) setModerator(c *gin.Context, moderator bool) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	username, _ := c.Get("username")
+	if _, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
+		SetModerator: &storage.SetModeratorCommand{
+			UserName:  username.(string),
+			RoomID:    id,
+			Target:    c.Param("username"),
+			Moderator: moderator,
+		},
+	}); err != nil {
+		writeError(c, err)
+	}
+}
+
+func (s *Server) handleRoomKick(c *gin.Context) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	username, _ := c.Get("username")
+	if _, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
+		KickUser: &storage.KickUserCommand{
+			UserName: username.(string),
+			RoomID:   id,
+			Target:   c.Param("username"),
+		},
+	}); err != nil {
+		writeError(c, err)
+	}
+}
+
+func (s *Server) handleRoomBan(c *gin.Context) {
+	s.banUser(c, true)
+}
+
+func (s *Server) handleRoomUnban(c *gin.Context) {
+	s.banUser(c, false)
+}
+
+func (s *Server) banUser(c *gin.Context, banned bool) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	now := int(time.Now().Unix())
+	until, err := parseRestrictionUntil(c, now)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	username, _ := c.Get("username")
+	if _, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
+		BanUser: &storage.BanUserCommand{
+			UserName: username.(string),
+			RoomID:   id,
+			Target:   c.Param("username"),
+			Banned:   banned,
+			Until:    until,
+			TS:       now,
+		},
+	}); err != nil {
+		writeError(c, err)
+	}
+}
+
+func (s *Server) handleRoomMute(c *gin.Context) {
+	s.muteUser(c, true)
+}
+
+func (s *Server) handleRoomUnmute(c *gin.Context) {
+	s.muteUser(c, false)
+}
+
+func (s *Server) muteUser(c *gin.Context, muted bool) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	now := int(time.Now().Unix())
+	until, err := parseRestrictionUntil(c, now)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	username, _ := c.Get("username")
+	if _, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
+		MuteUser: &storage.MuteUserCommand{
+			UserName: username.(string),
+			RoomID:   id,
+			Target:   c.Param("username"),
+			Muted:    muted,
+			Until:    until,
+			TS:       now,
+		},
+	}); err != nil {
+		writeError(c, err)
+	}
+}
+
+func (s *Server) handleRoomListBans(c *gin.Context) {
+	s.listRestrictions(c, func(room *storage.Room) map[string]int { return room.Bans })
+}
+
+func (s *Server) handleRoomListMutes(c *gin.Context) {
+	s.listRestrictions(c, func(room *storage.Room) map[string]int { return room.Mutes })
+}
+
+// listRestrictions writes the unexpired restrictions of the room sorted by
+// user name, only the owner and moderators can list them.
+func (s *Server) listRestrictions(c *gin.Context, restrictions func(room *storage.Room) map[string]int) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	username, _ := c.Get("username")
+	s.rwm.RLock()
+	defer s.rwm.RUnlock()
+	room, ok := s.storage.Room(id)
+	if !ok || !room.CanAccess(s.currentUser(c)) {
+		writeError(c, errors.New("room not exists"))
+		return
+	}
+	if !room.CanModerate(username.(string)) {
+		writeError(c, storage.ErrPermissionDenied)
+		return
+	}
+	type RespRestriction struct {
+		UserName string `json:"username"`
+		// Until is empty if the restriction never expires.
+		Until string `json:"until,omitempty"`
+	}
+	respRestrictions := make([]RespRestriction, 0)
+	for name, until := range storage.Restrictions(restrictions(room), int(time.Now().Unix())) {
+		r := RespRestriction{UserName: name}
+		if until > 0 {
+			r.Until = strconv.Itoa(until)
+		}
+		respRestrictions = append(respRestrictions, r)
+	}
+	sort.Slice(respRestrictions, func(i, j int) bool {
+		return respRestrictions[i].UserName < respRestrictions[j].UserName
+	})
+	c.JSON(http.StatusOK, respRestrictions)
+}
@@ -0,0 +1,132 @@
+package app
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Requests sent by other members carry the time they are signed in unix
+// milliseconds, a random nonce and the hmac of the method, the uri, the
+// time, the nonce and the sha256 digest of the body keyed by the peer key.
+const (
+	peerHeader          = "X-Groupchat-Peer-Signature"
+	peerTimestampHeader = "X-Groupchat-Peer-Timestamp"
+	peerNonceHeader     = "X-Groupchat-Peer-Nonce"
+)
+
+// peerRequestMaxAge is how long a signed request is accepted, in either
+// direction to tolerate clock drift between members. Nonces are remembered
+// for twice as long, so that a request is never accepted twice.
+const peerRequestMaxAge = time.Minute
+
+// derivePeerKey derives the key signing requests between members from the
+// secret key shared by the cluster, so that the secret key itself is only
+// used by the AEAD of tokens.
+func derivePeerKey(secretKey []byte) []byte {
+	mac := hmac.New(sha256.New, secretKey)
+	mac.Write([]byte("groupchat peer"))
+	return mac.Sum(nil)
+}
+
+func (s *Server) peerSignature(method, uri, timestamp, nonce string, body []byte) []byte {
+	digest := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, s.peerKey)
+	mac.Write([]byte(method + "\n" + uri + "\n" + timestamp + "\n" + nonce + "\n"))
+	mac.Write([]byte(hex.EncodeToString(digest[:])))
+	return mac.Sum(nil)
+}
+
+// signPeerRequest signs the request sent to another member, body must be
+// the body of the request.
+func (s *Server) signPeerRequest(req *http.Request, body []byte) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	req.Header.Set(peerTimestampHeader, timestamp)
+	req.Header.Set(peerNonceHeader, hex.EncodeToString(nonce))
+	req.Header.Set(peerHeader, hex.EncodeToString(
+		s.peerSignature(req.Method, req.URL.RequestURI(), timestamp, hex.EncodeToString(nonce), body)))
+}
+
+// isPeer returns whether the request is signed by another member. The body
+// is read to check its digest and replaced for the handlers. Requests signed
+// too long ago and replayed requests are rejected.
+func (s *Server) isPeer(c *gin.Context) bool {
+	signature, err := hex.DecodeString(c.GetHeader(peerHeader))
+	if err != nil || len(signature) == 0 || len(s.peerKey) == 0 {
+		return false
+	}
+	timestamp := c.GetHeader(peerTimestampHeader)
+	ms, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	now := time.Now()
+	if age := now.Sub(time.UnixMilli(ms)); age > peerRequestMaxAge || age < -peerRequestMaxAge {
+		return false
+	}
+	nonce := c.GetHeader(peerNonceHeader)
+	if len(nonce) == 0 {
+		return false
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return false
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	expected := s.peerSignature(c.Request.Method, c.Request.URL.RequestURI(), timestamp, nonce, body)
+	return hmac.Equal(signature, expected) && s.peerNonces.add(nonce, now)
+}
+
+// peerRequired rejects requests which are not sent by other members.
+func (s *Server) peerRequired(c *gin.Context) {
+	if !s.isPeer(c) {
+		writeError(c, errors.New("peer signature is invalid"))
+		c.Abort()
+	}
+}
+
+// nonceSet remembers the nonces of the accepted peer requests until they
+// are too old to be accepted again.
+type nonceSet struct {
+	mu     sync.Mutex
+	expire map[string]time.Time
+	// pruned is when the expired nonces were dropped last time.
+	pruned time.Time
+}
+
+func newNonceSet() *nonceSet {
+	return &nonceSet{expire: make(map[string]time.Time)}
+}
+
+// add returns false if the nonce has been seen.
+func (ns *nonceSet) add(nonce string, now time.Time) bool {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	if _, ok := ns.expire[nonce]; ok {
+		return false
+	}
+	if now.Sub(ns.pruned) > peerRequestMaxAge {
+		for n, expire := range ns.expire {
+			if now.After(expire) {
+				delete(ns.expire, n)
+			}
+		}
+		ns.pruned = now
+	}
+	ns.expire[nonce] = now.Add(peerRequestMaxAge * 2)
+	return true
+}
@@ -0,0 +1,349 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/client/pkg/v3/types"
+	"go.uber.org/zap"
+
+	"github.com/gozssky/groupchat/pkg/storage"
+)
+
+// typingTTL is how long a user is shown typing after a typing notification,
+// clients keep sending them while the user is typing.
+const typingTTL = time.Second * 5
+
+// presenceTracker tracks the presence of users. It is ephemeral state kept
+// out of raft: every member records the activity of its own clients, and
+// fetches the activity recorded by the other members periodically.
+type presenceTracker struct {
+	mu sync.Mutex
+	// lastSeen is the time of the last request of users on this member.
+	lastSeen map[string]time.Time
+	// streams counts the streaming connections of users on this member,
+	// users with any of them are always online.
+	streams map[string]int
+	// remote maps ids of other members to the last time users were seen
+	// on them.
+	remote map[types.ID]map[string]time.Time
+	// typing maps room ids to the typing users and when they stop typing.
+	typing map[int]map[string]time.Time
+	// now returns the current time, it is replaced by tests.
+	now func() time.Time
+}
+
+func newPresenceTracker() *presenceTracker {
+	return &presenceTracker{
+		lastSeen: make(map[string]time.Time),
+		streams:  make(map[string]int),
+		remote:   make(map[types.ID]map[string]time.Time),
+		typing:   make(map[int]map[string]time.Time),
+		now:      time.Now,
+	}
+}
+
+func (p *presenceTracker) touch(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastSeen[name] = p.now()
+}
+
+// connect records a streaming connection of the user, the returned function
+// must be called once it is closed.
+func (p *presenceTracker) connect(name string) func() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.streams[name]++
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.lastSeen[name] = p.now()
+		if p.streams[name]--; p.streams[name] <= 0 {
+			delete(p.streams, name)
+		}
+	}
+}
+
+// local returns how long ago the users active within ttl were seen on this
+// member, users with streaming connections are seen just now. Durations
+// rather than times are exchanged so that clocks of members don't matter.
+func (p *presenceTracker) local(ttl time.Duration) map[string]time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.now()
+	result := make(map[string]time.Duration)
+	for name, t := range p.lastSeen {
+		if age := now.Sub(t); age < ttl {
+			result[name] = age
+		} else if p.streams[name] == 0 {
+			delete(p.lastSeen, name)
+		}
+	}
+	for name := range p.streams {
+		result[name] = 0
+	}
+	return result
+}
+
+// setRemote replaces the presence fetched from the member at now.
+func (p *presenceTracker) setRemote(id types.ID, now time.Time, ages map[string]time.Duration) {
+	seen := make(map[string]time.Time, len(ages))
+	for name, age := range ages {
+		seen[name] = now.Add(-age)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.remote[id] = seen
+}
+
+// retainRemote drops the presence fetched from members which are removed.
+func (p *presenceTracker) retainRemote(ids map[types.ID]bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id := range p.remote {
+		if !ids[id] {
+			delete(p.remote, id)
+		}
+	}
+}
+
+// lastSeenAt returns the last time the user was seen on any member, and
+// whether the user has a streaming connection to this member.
+func (p *presenceTracker) lastSeenAt(name string) (time.Time, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.streams[name] > 0 {
+		return p.now(), true
+	}
+	last := p.lastSeen[name]
+	for _, seen := range p.remote {
+		if t := seen[name]; t.After(last) {
+			last = t
+		}
+	}
+	return last, false
+}
+
+func (p *presenceTracker) setTyping(roomID int, name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.typing[roomID] == nil {
+		p.typing[roomID] = make(map[string]time.Time)
+	}
+	p.typing[roomID][name] = p.now().Add(typingTTL)
+}
+
+func (p *presenceTracker) isTyping(roomID int, name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	until, ok := p.typing[roomID][name]
+	if ok && p.now().After(until) {
+		delete(p.typing[roomID], name)
+		if len(p.typing[roomID]) == 0 {
+			delete(p.typing, roomID)
+		}
+		return false
+	}
+	return ok
+}
+
+// presenceLoop fetches the presence of users on the other members.
+func (s *Server) presenceLoop() {
+	ticker := time.NewTicker(s.cfg.PresenceInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ids := make(map[types.ID]bool)
+		var wg sync.WaitGroup
+		for _, peer := range s.node.Members() {
+			ids[peer.ID] = true
+			if peer.ID == s.node.ID() {
+				continue
+			}
+			wg.Add(1)
+			go func(id types.ID, url string) {
+				defer wg.Done()
+				if err := s.fetchPresence(id, url); err != nil {
+					s.lg.Debug("failed to fetch presence", zap.String("url", url), zap.Error(err))
+				}
+			}(peer.ID, peer.URL)
+		}
+		wg.Wait()
+		s.presence.retainRemote(ids)
+	}
+}
+
+func (s *Server) fetchPresence(id types.ID, url string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.PresenceInterval)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"/cluster/presence", nil)
+	if err != nil {
+		return err
+	}
+	s.signPeerRequest(req, nil)
+	now := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+	var agesMs map[string]int64
+	if err := json.NewDecoder(resp.Body).Decode(&agesMs); err != nil {
+		return err
+	}
+	ages := make(map[string]time.Duration, len(agesMs))
+	for name, ms := range agesMs {
+		ages[name] = time.Duration(ms) * time.Millisecond
+	}
+	s.presence.setRemote(id, now, ages)
+	return nil
+}
+
+// handleClusterPresence writes how many milliseconds ago the users active on
+// this member were seen, it is fetched by the other members only.
+func (s *Server) handleClusterPresence(c *gin.Context) {
+	ages := s.presence.local(s.cfg.PresenceTTL)
+	agesMs := make(map[string]int64, len(ages))
+	for name, age := range ages {
+		agesMs[name] = age.Milliseconds()
+	}
+	c.JSON(http.StatusOK, agesMs)
+}
+
+type typingNotification struct {
+	RoomID   int    `json:"roomId"`
+	UserName string `json:"username"`
+}
+
+// publishTyping records the typing user and fans it out to the subscribers
+// of the room on this member.
+func (s *Server) publishTyping(n typingNotification) {
+	s.presence.setTyping(n.RoomID, n.UserName)
+	s.hub.publish([]storage.Event{{Type: storage.EventTyping, RoomID: n.RoomID, UserName: n.UserName}})
+}
+
+// handleRoomTyping notifies the subscribers of the room on all members that
+// the user is typing.
+func (s *Server) handleRoomTyping(c *gin.Context) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	s.rwm.RLock()
+	user := s.currentUser(c)
+	room, ok := s.storage.Room(id)
+	switch {
+	case user == nil:
+		err = storage.ErrUserNotExists
+	case !ok:
+		err = storage.ErrRoomNotExists
+	case !user.IsMember(id):
+		err = storage.ErrNotRoomMember
+	case room.Archived:
+		err = storage.ErrRoomArchived
+	case room.IsMuted(user.UserName, int(time.Now().Unix())):
+		err = storage.ErrUserMuted
+	}
+	s.rwm.RUnlock()
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	n := typingNotification{RoomID: id, UserName: user.UserName}
+	s.publishTyping(n)
+	body, err := json.Marshal(n)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	for _, peer := range s.node.Members() {
+		if peer.ID == s.node.ID() {
+			continue
+		}
+		go func(url string) {
+			ctx, cancel := context.WithTimeout(context.Background(), typingTTL)
+			defer cancel()
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, url+"/cluster/typing", bytes.NewReader(body))
+			if err != nil {
+				return
+			}
+			req.Header.Set("Content-Type", "application/json")
+			s.signPeerRequest(req, body)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				s.lg.Debug("failed to relay typing notification", zap.String("url", url), zap.Error(err))
+				return
+			}
+			resp.Body.Close()
+		}(peer.URL)
+	}
+}
+
+// handleClusterTyping receives a typing notification relayed by another
+// member, clients can't send it since it skips the checks of
+// handleRoomTyping.
+func (s *Server) handleClusterTyping(c *gin.Context) {
+	var n typingNotification
+	if err := c.ShouldBindJSON(&n); err != nil {
+		writeError(c, err)
+		return
+	}
+	s.publishTyping(n)
+}
+
+// handleRoomPresence writes whether the members of the room are online and
+// typing, only members can see private rooms.
+func (s *Server) handleRoomPresence(c *gin.Context) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	s.rwm.RLock()
+	room, ok := s.storage.Room(id)
+	if !ok || !room.CanAccess(s.currentUser(c)) {
+		s.rwm.RUnlock()
+		writeError(c, storage.ErrRoomNotExists)
+		return
+	}
+	names := append([]string(nil), room.Users...)
+	s.rwm.RUnlock()
+
+	type RespPresence struct {
+		UserName string `json:"username"`
+		Online   bool   `json:"online"`
+		// LastSeen is the timestamp in milliseconds of the last activity
+		// known by the cluster, it is empty if the user has not been seen
+		// since the members started.
+		LastSeen string `json:"lastSeen,omitempty"`
+		Typing   bool   `json:"typing,omitempty"`
+	}
+	sort.Strings(names)
+	now := time.Now()
+	respPresences := make([]RespPresence, 0, len(names))
+	for _, name := range names {
+		last, streaming := s.presence.lastSeenAt(name)
+		r := RespPresence{
+			UserName: name,
+			Online:   streaming || now.Sub(last) < s.cfg.PresenceTTL,
+			Typing:   s.presence.isTyping(id, name),
+		}
+		if !last.IsZero() {
+			r.LastSeen = strconv.FormatInt(last.UnixMilli(), 10)
+		}
+		respPresences = append(respPresences, r)
+	}
+	c.JSON(http.StatusOK, respPresences)
+}
@@ -0,0 +1,136 @@
+package app
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/client/pkg/v3/types"
+)
+
+// newTestPresenceTracker returns a tracker whose clock only moves by the
+// returned function.
+func newTestPresenceTracker() (*presenceTracker, func(time.Duration)) {
+	p := newPresenceTracker()
+	now := time.Unix(1000, 0)
+	p.now = func() time.Time { return now }
+	return p, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestPresenceTTL(t *testing.T) {
+	p, advance := newTestPresenceTracker()
+	ttl := time.Minute
+	p.touch("alice")
+	advance(time.Second * 30)
+	if ages := p.local(ttl); len(ages) != 1 || ages["alice"] != time.Second*30 {
+		t.Fatalf("unexpected local presence %v", ages)
+	}
+	if last, streaming := p.lastSeenAt("alice"); streaming || !last.Equal(time.Unix(1000, 0)) {
+		t.Fatalf("unexpected last seen %v, streaming %v", last, streaming)
+	}
+	advance(time.Second * 30)
+	if ages := p.local(ttl); len(ages) != 0 {
+		t.Fatalf("presence should expire after ttl, got %v", ages)
+	}
+	if _, ok := p.lastSeen["alice"]; ok {
+		t.Fatal("expired presence should be dropped")
+	}
+}
+
+func TestPresenceStreams(t *testing.T) {
+	p, advance := newTestPresenceTracker()
+	ttl := time.Minute
+	close1 := p.connect("alice")
+	close2 := p.connect("alice")
+	advance(time.Hour)
+	if ages := p.local(ttl); len(ages) != 1 || ages["alice"] != 0 {
+		t.Fatalf("users with streams should be seen just now, got %v", ages)
+	}
+	close1()
+	if last, streaming := p.lastSeenAt("alice"); !streaming || !last.Equal(p.now()) {
+		t.Fatal("users should be streaming until all streams are closed")
+	}
+	advance(time.Second)
+	close2()
+	if _, ok := p.streams["alice"]; ok {
+		t.Fatal("stream count should be dropped once all streams are closed")
+	}
+	advance(time.Second)
+	if last, streaming := p.lastSeenAt("alice"); streaming || !last.Equal(p.now().Add(-time.Second)) {
+		t.Fatalf("users should be seen when the last stream is closed, got %v", last)
+	}
+	advance(ttl)
+	if ages := p.local(ttl); len(ages) != 0 {
+		t.Fatalf("presence should expire after the streams are closed, got %v", ages)
+	}
+}
+
+func TestPresenceRemote(t *testing.T) {
+	p, advance := newTestPresenceTracker()
+	fetched := p.now()
+	p.setRemote(2, fetched, map[string]time.Duration{"alice": time.Second * 10, "bob": time.Second})
+	p.setRemote(3, fetched, map[string]time.Duration{"alice": time.Second * 5})
+	advance(time.Second)
+	p.touch("bob")
+	if last, _ := p.lastSeenAt("alice"); !last.Equal(fetched.Add(-time.Second * 5)) {
+		t.Fatalf("the latest time seen by members should win, got %v", last)
+	}
+	if last, _ := p.lastSeenAt("bob"); !last.Equal(p.now()) {
+		t.Fatalf("local activity should win if it is later, got %v", last)
+	}
+	p.retainRemote(map[types.ID]bool{1: true, 2: true})
+	if last, _ := p.lastSeenAt("alice"); !last.Equal(fetched.Add(-time.Second * 10)) {
+		t.Fatalf("presence of removed members should be dropped, got %v", last)
+	}
+	if last, _ := p.lastSeenAt("carol"); !last.IsZero() {
+		t.Fatalf("unknown users should never be seen, got %v", last)
+	}
+}
+
+func TestTypingExpiry(t *testing.T) {
+	p, advance := newTestPresenceTracker()
+	p.setTyping(1, "alice")
+	if !p.isTyping(1, "alice") || p.isTyping(2, "alice") || p.isTyping(1, "bob") {
+		t.Fatal("users should only be typing in the notified room")
+	}
+	advance(typingTTL)
+	if !p.isTyping(1, "alice") {
+		t.Fatal("users should be typing until the ttl passes")
+	}
+	// A new notification extends the typing.
+	p.setTyping(1, "alice")
+	advance(typingTTL + time.Millisecond)
+	if p.isTyping(1, "alice") {
+		t.Fatal("typing should expire after the ttl")
+	}
+	if _, ok := p.typing[1]; ok {
+		t.Fatal("expired typing should be dropped")
+	}
+}
+
+func TestClusterPresencePeerOnly(t *testing.T) {
+	s := newTestServer(t, testConfig(t))
+	if w := s.request(http.MethodGet, "/cluster/presence", nil, nil); w.Code == http.StatusOK {
+		t.Fatal("presence should not be served to clients")
+	}
+	if w := s.request(http.MethodGet, "/cluster/presence", s.signed(http.MethodGet, "/cluster/presence", ""), nil); w.Code != http.StatusOK {
+		t.Fatalf("presence should be served to members: %s", w.Body)
+	}
+
+	body := `{"roomId":1,"username":"alice"}`
+	if w := s.request(http.MethodPost, "/cluster/typing", nil, strings.NewReader(body)); w.Code == http.StatusOK {
+		t.Fatal("typing notifications should not be accepted from clients")
+	}
+	if s.presence.isTyping(1, "alice") {
+		t.Fatal("rejected typing notification should not be recorded")
+	}
+	header := s.signed(http.MethodPost, "/cluster/typing", body)
+	header.Set("Content-Type", "application/json")
+	if w := s.request(http.MethodPost, "/cluster/typing", header, strings.NewReader(body)); w.Code != http.StatusOK {
+		t.Fatalf("typing notifications should be accepted from members: %s", w.Body)
+	}
+	if !s.presence.isTyping(1, "alice") {
+		t.Fatal("relayed typing notification should be recorded")
+	}
+}
@@ -0,0 +1,172 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/gozssky/groupchat/pkg/storage"
+)
+
+func (s *Server) handleRoomVisibility(c *gin.Context) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	var req struct {
+		Private bool `json:"private"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, err)
+		return
+	}
+	username, _ := c.Get("username")
+	if _, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
+		SetRoomVisibility: &storage.SetRoomVisibilityCommand{
+			UserName: username.(string),
+			RoomID:   id,
+			Private:  req.Private,
+		},
+	}); err != nil {
+		writeError(c, err)
+	}
+}
+
+func (s *Server) handleRoomInvite(c *gin.Context) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	var req struct {
+		UserName string `json:"username"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeError(c, err)
+		return
+	}
+	username, _ := c.Get("username")
+	if _, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
+		Invite: &storage.InviteCommand{
+			UserName: username.(string),
+			RoomID:   id,
+			Invitee:  req.UserName,
+			TS:       int(time.Now().Unix()),
+		},
+	}); err != nil {
+		writeError(c, err)
+	}
+}
+
+func (s *Server) handleRoomListInvites(c *gin.Context) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	s.rwm.RLock()
+	defer s.rwm.RUnlock()
+	room, ok := s.storage.Room(id)
+	if !ok || !room.CanAccess(s.currentUser(c)) {
+		writeError(c, errors.New("room not exists"))
+		return
+	}
+	invites := append([]string{}, room.Invites...)
+	c.JSON(http.StatusOK, invites)
+}
+
+func (s *Server) handleRoomRequestJoin(c *gin.Context) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	username, _ := c.Get("username")
+	if _, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
+		RequestJoin: &storage.RequestJoinCommand{
+			UserName: username.(string),
+			RoomID:   id,
+			TS:       int(time.Now().Unix()),
+		},
+	}); err != nil {
+		writeError(c, err)
+	}
+}
+
+func (s *Server) handleRoomListJoinRequests(c *gin.Context) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	username, _ := c.Get("username")
+	s.rwm.RLock()
+	defer s.rwm.RUnlock()
+	room, ok := s.storage.Room(id)
+	if !ok || !room.CanAccess(s.currentUser(c)) {
+		writeError(c, errors.New("room not exists"))
+		return
+	}
+	if room.Owner != username.(string) {
+		writeError(c, storage.ErrPermissionDenied)
+		return
+	}
+	requests := append([]string{}, room.JoinRequests...)
+	c.JSON(http.StatusOK, requests)
+}
+
+func (s *Server) handleRoomApproveJoinRequest(c *gin.Context) {
+	s.answerJoinRequest(c, true)
+}
+
+func (s *Server) handleRoomRejectJoinRequest(c *gin.Context) {
+	s.answerJoinRequest(c, false)
+}
+
+func (s *Server) answerJoinRequest(c *gin.Context, approved bool) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	username, _ := c.Get("username")
+	if _, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
+		AnswerJoinRequest: &storage.AnswerJoinRequestCommand{
+			UserName:  username.(string),
+			RoomID:    id,
+			Requester: c.Param("username"),
+			Approved:  approved,
+		},
+	}); err != nil {
+		writeError(c, err)
+	}
+}
+
+func (s *Server) handleMyInvites(c *gin.Context) {
+	username, _ := c.Get("username")
+	s.rwm.RLock()
+	defer s.rwm.RUnlock()
+	type RespRoom struct {
+		Name  string `json:"name"`
+		ID    string `json:"id"`
+		Owner string `json:"owner"`
+	}
+	respRooms := make([]RespRoom, 0)
+	for _, room := range s.storage.RoomList {
+		for _, name := range room.Invites {
+			if name == username.(string) {
+				respRooms = append(respRooms, RespRoom{
+					Name:  room.Name,
+					ID:    strconv.Itoa(room.ID),
+					Owner: room.Owner,
+				})
+				break
+			}
+		}
+	}
+	c.JSON(http.StatusOK, respRooms)
+}
@@ -0,0 +1,84 @@
+package app
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/gozssky/groupchat/pkg/storage"
+)
+
+// handleRoomRead marks the messages of the room up to the message with the
+// optional id as read, or all messages without a body.
+func (s *Server) handleRoomRead(c *gin.Context) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	var req struct {
+		ID string `json:"id"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			writeError(c, err)
+			return
+		}
+	}
+	username, _ := c.Get("username")
+	if _, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
+		MarkRead: &storage.MarkReadCommand{UserName: username.(string), RoomID: id, ID: req.ID},
+	}); err != nil {
+		writeError(c, err)
+	}
+}
+
+// handleRoomReceipts writes the last message read by each member of the
+// room, only members can see them.
+func (s *Server) handleRoomReceipts(c *gin.Context) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	s.rwm.RLock()
+	defer s.rwm.RUnlock()
+	user := s.currentUser(c)
+	if user == nil {
+		writeError(c, storage.ErrUserNotExists)
+		return
+	}
+	if !user.IsMember(id) {
+		writeError(c, storage.ErrNotRoomMember)
+		return
+	}
+	room, ok := s.storage.Room(id)
+	if !ok {
+		writeError(c, storage.ErrRoomNotExists)
+		return
+	}
+	type RespReceipt struct {
+		UserName string `json:"username"`
+		// LastReadID is empty if the user has read nothing or the message
+		// is pruned.
+		LastReadID string `json:"lastReadId,omitempty"`
+		Unread     int    `json:"unread"`
+	}
+	respReceipts := make([]RespReceipt, 0, len(room.Users))
+	for _, name := range room.Users {
+		member, ok := s.storage.Users[name]
+		if !ok {
+			continue
+		}
+		r := RespReceipt{UserName: name, Unread: room.UnreadCount(member)}
+		if msg := room.MessageBySeq(member.LastRead[room.ID]); msg != nil {
+			r.LastReadID = msg.ID
+		}
+		respReceipts = append(respReceipts, r)
+	}
+	sort.Slice(respReceipts, func(i, j int) bool {
+		return respReceipts[i].UserName < respReceipts[j].UserName
+	})
+	c.JSON(http.StatusOK, respReceipts)
+}
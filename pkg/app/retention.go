@@ -0,0 +1,155 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/gozssky/groupchat/pkg/storage"
+)
+
+type respRetention struct {
+	MaxAge   string `json:"maxAge"`
+	MaxCount int    `json:"maxCount"`
+	// Inherited is true if the room follows the global retention policy.
+	Inherited bool `json:"inherited,omitempty"`
+}
+
+func toRespRetention(r storage.Retention) respRetention {
+	return respRetention{MaxAge: r.MaxAge.String(), MaxCount: r.MaxCount}
+}
+
+func bindRetention(c *gin.Context) (*storage.Retention, error) {
+	var req struct {
+		MaxAge   string `json:"maxAge"`
+		MaxCount int    `json:"maxCount"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		return nil, err
+	}
+	r := &storage.Retention{MaxCount: req.MaxCount}
+	if len(req.MaxAge) > 0 {
+		maxAge, err := time.ParseDuration(req.MaxAge)
+		if err != nil {
+			return nil, err
+		}
+		r.MaxAge = maxAge
+	}
+	return r, r.Validate()
+}
+
+func (s *Server) handleRetentionQuery(c *gin.Context) {
+	s.rwm.RLock()
+	defer s.rwm.RUnlock()
+	c.JSON(http.StatusOK, toRespRetention(s.storage.Retention))
+}
+
+func (s *Server) handleRetentionUpdate(c *gin.Context) {
+	r, err := bindRetention(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	if _, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
+		SetRetention: &storage.SetRetentionCommand{Admin: true, Retention: r},
+	}); err != nil {
+		writeError(c, err)
+	}
+}
+
+func (s *Server) handleRoomRetentionQuery(c *gin.Context) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	s.rwm.RLock()
+	defer s.rwm.RUnlock()
+	room, ok := s.storage.Room(id)
+	if !ok {
+		writeError(c, errors.New("room not exists"))
+		return
+	}
+	if room.Retention == nil {
+		resp := toRespRetention(s.storage.Retention)
+		resp.Inherited = true
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	c.JSON(http.StatusOK, toRespRetention(*room.Retention))
+}
+
+func (s *Server) handleRoomRetentionUpdate(c *gin.Context) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	r, err := bindRetention(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	username, _ := c.Get("username")
+	if _, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
+		SetRetention: &storage.SetRetentionCommand{
+			UserName:  username.(string),
+			Admin:     s.isAdmin(c),
+			RoomID:    id,
+			Retention: r,
+		},
+	}); err != nil {
+		writeError(c, err)
+	}
+}
+
+func (s *Server) handleRoomRetentionDelete(c *gin.Context) {
+	id, err := parseRoomID(c)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	username, _ := c.Get("username")
+	if _, err := s.proposeRaftCommand(c.Request.Context(), storage.InternalRaftCommand{
+		SetRetention: &storage.SetRetentionCommand{
+			UserName: username.(string),
+			Admin:    s.isAdmin(c),
+			RoomID:   id,
+		},
+	}); err != nil {
+		writeError(c, err)
+	}
+}
+
+// pruneLoop proposes a PruneMessagesCommand periodically on the leader if
+// any message exceeds the retention policies.
+func (s *Server) pruneLoop() {
+	ticker := time.NewTicker(s.cfg.PruneInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !s.node.IsLead() {
+			continue
+		}
+		now := int(time.Now().Unix())
+		s.rwm.RLock()
+		expired := s.storage.HasExpiredMessages(now)
+		s.rwm.RUnlock()
+		if !expired {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), s.cfg.PruneInterval)
+		result, err := s.proposeRaftCommand(ctx, storage.InternalRaftCommand{
+			PruneMessages: &storage.PruneMessagesCommand{Now: now},
+		})
+		cancel()
+		if err != nil {
+			s.lg.Warn("failed to prune messages", zap.Error(err))
+			continue
+		}
+		s.lg.Info("pruned expired messages", zap.Int("count", result.(int)))
+	}
+}
@@ -0,0 +1,110 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/gozssky/groupchat/pkg/search"
+	"github.com/gozssky/groupchat/pkg/storage"
+)
+
+// handleSearch writes the messages matching all words of the query in the
+// rooms the user is a member of, ranked by relevance. They can be limited to
+// a room, an author, and messages sent in [from, before) of unix timestamps.
+func (s *Server) handleSearch(c *gin.Context) {
+	query := c.Query("q")
+	if len(search.Tokenize(query)) == 0 {
+		writeError(c, errors.New("empty search query"))
+		return
+	}
+	var roomID int
+	if v := c.Query("room"); len(v) > 0 {
+		var err error
+		if roomID, err = strconv.Atoi(v); err != nil {
+			writeError(c, errors.New("invalid room id"))
+			return
+		}
+	}
+	author := c.Query("author")
+	var from, before int
+	if v := c.Query("from"); len(v) > 0 {
+		var err error
+		if from, err = strconv.Atoi(v); err != nil {
+			writeError(c, errors.New("invalid from timestamp"))
+			return
+		}
+	}
+	if v := c.Query("before"); len(v) > 0 {
+		var err error
+		if before, err = strconv.Atoi(v); err != nil {
+			writeError(c, errors.New("invalid before timestamp"))
+			return
+		}
+	}
+	pageIndex, err := strconv.Atoi(c.DefaultQuery("pageIndex", "0"))
+	if err != nil {
+		writeError(c, errors.New("invalid page index"))
+		return
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", "10"))
+	if err != nil {
+		writeError(c, errors.New("invalid page size"))
+		return
+	}
+	s.rwm.RLock()
+	defer s.rwm.RUnlock()
+	user := s.currentUser(c)
+	if user == nil {
+		writeError(c, storage.ErrUserNotExists)
+		return
+	}
+	if roomID != 0 && !user.IsMember(roomID) {
+		writeError(c, storage.ErrNotRoomMember)
+		return
+	}
+	type RespHit struct {
+		RoomID   string      `json:"roomId"`
+		RoomName string      `json:"roomName"`
+		Score    float64     `json:"score"`
+		Message  respMessage `json:"message"`
+	}
+	type hit struct {
+		room  *storage.Room
+		msg   *storage.Message
+		score float64
+	}
+	var hits []hit
+	for _, h := range s.storage.Search(query) {
+		if roomID != 0 && h.ID.RoomID != roomID {
+			continue
+		}
+		room, ok := s.storage.Room(h.ID.RoomID)
+		if !ok || !user.IsMember(room.ID) {
+			continue
+		}
+		msg := room.MessageBySeq(h.ID.Seq)
+		if msg == nil || (len(author) > 0 && msg.Author != author) ||
+			(from > 0 && msg.TS < from) || (before > 0 && msg.TS >= before) {
+			continue
+		}
+		hits = append(hits, hit{room: room, msg: msg, score: h.Score})
+	}
+	type RespSearch struct {
+		Total int       `json:"total"`
+		Hits  []RespHit `json:"hits"`
+	}
+	start, end := convertPageToRange(len(hits), pageIndex, pageSize)
+	resp := RespSearch{Total: len(hits), Hits: make([]RespHit, 0, end-start)}
+	for _, h := range hits[start:end] {
+		resp.Hits = append(resp.Hits, RespHit{
+			RoomID:   strconv.Itoa(h.room.ID),
+			RoomName: h.room.Name,
+			Score:    h.score,
+			Message:  toRespMessage(h.room, h.msg),
+		})
+	}
+	c.JSON(http.StatusOK, resp)
+}
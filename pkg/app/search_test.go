@@ -0,0 +1,72 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gozssky/groupchat/pkg/storage"
+)
+
+func TestSearchFilters(t *testing.T) {
+	s := newTestServer(t, testConfig(t))
+	alice := s.login(t, "alice")
+	s.login(t, "bob")
+	w := s.request(http.MethodPost, "/room", bearer(alice), strings.NewReader(`{"name":"lobby"}`))
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to create room: %s", w.Body)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	for _, cmd := range []storage.InternalRaftCommand{
+		{EnterRoom: &storage.EnterRoomCommand{UserName: "alice", RoomID: 1}},
+		{EnterRoom: &storage.EnterRoomCommand{UserName: "bob", RoomID: 1}},
+		{SendMessage: &storage.SendMessageCommand{ID: "a100", TS: 100, Text: "raft", UserName: "alice"}},
+		{SendMessage: &storage.SendMessageCommand{ID: "b200", TS: 200, Text: "raft", UserName: "bob"}},
+		{SendMessage: &storage.SendMessageCommand{ID: "a300", TS: 300, Text: "raft", UserName: "alice"}},
+	} {
+		if _, err := s.proposeRaftCommand(ctx, cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for params, expected := range map[string]string{
+		"":                       "a100,a300,b200",
+		"&author=alice":          "a100,a300",
+		"&from=200":              "a300,b200",
+		"&before=300":            "a100,b200",
+		"&from=200&before=300":   "b200",
+		"&author=bob&from=201":   "",
+		"&author=alice&from=100": "a100,a300",
+	} {
+		w := s.request(http.MethodGet, "/search?q=raft"+params, bearer(alice), nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("failed to search with %q: %s", params, w.Body)
+		}
+		var resp struct {
+			Hits []struct {
+				Message struct {
+					ID string `json:"id"`
+				} `json:"message"`
+			} `json:"hits"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, hit := range resp.Hits {
+			ids = append(ids, hit.Message.ID)
+		}
+		sort.Strings(ids)
+		if got := strings.Join(ids, ","); got != expected {
+			t.Errorf("search with %q got %q, expected %q", params, got, expected)
+		}
+	}
+	if w := s.request(http.MethodGet, "/search?q=raft&from=bob", bearer(alice), nil); w.Code == http.StatusOK {
+		t.Fatal("from should be a timestamp")
+	}
+}
@@ -1,13 +1,13 @@
 package app
 
 import (
-	"bytes"
 	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"net/http"
 	"strings"
 	"sync"
@@ -22,16 +22,19 @@ import (
 	"go.uber.org/atomic"
 	"go.uber.org/zap"
 
+	"github.com/gozssky/groupchat/pkg/blob"
 	"github.com/gozssky/groupchat/pkg/future"
 	"github.com/gozssky/groupchat/pkg/raftnode"
 	"github.com/gozssky/groupchat/pkg/storage"
 )
 
 type Server struct {
-	lg      *zap.Logger
-	port    int
-	dataDir string
-	aead    cipher.AEAD
+	lg   *zap.Logger
+	cfg  Config
+	aead cipher.AEAD
+	// peerKey signs requests between members, see signPeerRequest.
+	peerKey    []byte
+	peerNonces *nonceSet
 
 	once           sync.Once
 	node           *raftnode.Node
@@ -39,36 +42,63 @@ type Server struct {
 	raftStarted    atomic.Bool
 	clusterStarted atomic.Bool
 
-	rwm     sync.RWMutex
-	storage *storage.Storage
+	rwm      sync.RWMutex
+	storage  *storage.Storage
+	backend  storage.Backend
+	hub      *eventHub
+	presence *presenceTracker
+	blobs    *blob.Store
+	// snapshotIndex is the index of the latest snapshot, snapshotting is
+	// set while a snapshot is created automatically.
+	snapshotIndex atomic.Uint64
+	snapshotting  atomic.Bool
 
-	reqIDGen     *idutil.Generator
-	readWaitC    chan struct{}
-	readyReadMu  sync.RWMutex
-	readyRead    *future.Result
-	applyWait    wait.WaitTime
-	applyNotify  wait.Wait
-	appliedIndex atomic.Uint64
+	reqIDGen        *idutil.Generator
+	readWaitC       chan struct{}
+	readyReadMu     sync.RWMutex
+	readyRead       *future.Result
+	applyWait       wait.WaitTime
+	applyNotify     wait.Wait
+	readStateNotify wait.Wait
+	appliedIndex    atomic.Uint64
 }
 
-func NewServer(lg *zap.Logger, port int, dataDir string) *Server {
+func NewServer(lg *zap.Logger, cfg Config) *Server {
 	return &Server{
-		lg:      lg,
-		port:    port,
-		dataDir: dataDir,
-		storage: storage.NewStorage(),
+		lg:       lg,
+		cfg:      cfg,
+		storage:  storage.NewStorage(),
+		hub:      newEventHub(),
+		presence: newPresenceTracker(),
+
+		peerNonces: newNonceSet(),
 	}
 }
 
 func (s *Server) Run() error {
 	gin.SetMode(gin.ReleaseMode)
 	router := s.newChatRouter()
-	node, ok := raftnode.RestartRaftNode(s.lg, s.dataDir)
+	if err := s.openBackend(); err != nil {
+		return err
+	}
+	if err := s.openBlobs(); err != nil {
+		return err
+	}
+	node, ok := raftnode.RestartRaftNode(s.lg, s.cfg.Raft, s.cfg.DataDir, s.cfg.ForceNewCluster)
 	if ok {
-		s.lg.Info("restart the existing raft cluster")
+		s.lg.Info("restart the existing raft cluster", zap.Uint64("applied-index", s.storage.Index))
 		go s.bootstrap(func() *raftnode.Node { return node })
+	} else if err := s.resetStorage(); err != nil {
+		return err
 	}
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	http.Handle("/", s.newHandler(router))
+	return http.ListenAndServe(fmt.Sprintf(":%d", s.cfg.Port), nil)
+}
+
+// newHandler returns the handler of both client requests and raft messages
+// from other members, they share the same port.
+func (s *Server) newHandler(router http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if strings.HasPrefix(r.URL.Path, rafthttp.RaftPrefix) {
 			if s.raftStarted.Load() {
 				s.rafthttp.ServeHTTP(w, r)
@@ -79,7 +109,33 @@ func (s *Server) Run() error {
 			router.ServeHTTP(w, r)
 		}
 	})
-	return http.ListenAndServe(fmt.Sprintf(":%d", s.port), nil)
+}
+
+// openBackend opens the storage backend and loads the persisted state.
+func (s *Server) openBackend() error {
+	backend, err := storage.OpenBackend(s.cfg.Backend, s.cfg.DataDir)
+	if err != nil {
+		return fmt.Errorf("failed to open storage backend: %v", err)
+	}
+	if err := backend.Load(s.storage); err != nil {
+		backend.Close()
+		return fmt.Errorf("failed to load storage backend: %v", err)
+	}
+	s.backend = backend
+	s.appliedIndex.Store(s.storage.Index)
+	return nil
+}
+
+// resetStorage clears the state left by a previous cluster, it is called
+// before the node starts or joins a new cluster.
+func (s *Server) resetStorage() error {
+	if s.storage.Index == 0 {
+		return nil
+	}
+	s.lg.Warn("discard the storage of a previous cluster", zap.Uint64("applied-index", s.storage.Index))
+	s.storage = storage.NewStorage()
+	s.appliedIndex.Store(0)
+	return s.backend.Reset(s.storage)
 }
 
 func (s *Server) initAEAD() {
@@ -93,6 +149,7 @@ func (s *Server) initAEAD() {
 		s.lg.Panic("failed to create gcm aead", zap.Error(err))
 	}
 	s.aead = aead
+	s.peerKey = derivePeerKey(secretKey)
 	s.lg.Info("AEAD cipher mode is initialized")
 }
 
@@ -106,8 +163,8 @@ func (s *Server) getSecretKey(ctx context.Context) ([]byte, error) {
 }
 
 func (s *Server) getOrInitSecretKey() []byte {
-	for ; ; time.Sleep(time.Second) {
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	for ; ; time.Sleep(s.cfg.SecretKeyRetryInterval) {
+		ctx, cancel := context.WithTimeout(context.Background(), s.cfg.SecretKeyTimeout)
 		secretKey, err := s.getSecretKey(ctx)
 		cancel()
 		if err != nil {
@@ -124,7 +181,7 @@ func (s *Server) getOrInitSecretKey() []byte {
 		s.lg.Info("secret key is empty, start to initialize new secret key")
 		secretKey = make([]byte, aes.BlockSize)
 		rand.Read(secretKey)
-		ctx, cancel = context.WithTimeout(context.Background(), time.Second*5)
+		ctx, cancel = context.WithTimeout(context.Background(), s.cfg.SecretKeyTimeout)
 		result, err := s.proposeRaftCommand(ctx, storage.InternalRaftCommand{
 			InitSecretKey: &storage.InitSecretKeyCommand{
 				SecretKey: secretKey,
@@ -142,14 +199,26 @@ func (s *Server) getOrInitSecretKey() []byte {
 func (s *Server) bootstrap(newRaftNode func() *raftnode.Node) {
 	s.once.Do(func() {
 		s.node = newRaftNode()
+		s.node.SetSnapshotSource(backendSnapshotSource{s})
+		if snap, err := s.node.Snapshot(); err == nil {
+			s.snapshotIndex.Store(snap.Metadata.Index)
+		}
 		s.rafthttp = s.node.Handler()
 		s.reqIDGen = idutil.NewGenerator(uint16(s.node.ID()), time.Now())
 		s.readWaitC = make(chan struct{}, 1)
 		s.readyRead = future.NewResult()
 		s.applyWait = wait.NewTimeList()
 		s.applyNotify = wait.New()
+		s.readStateNotify = wait.New()
 		go s.handleApplyTasks()
+		go s.handleReadStates()
 		go s.linearizableReadLoop()
+		go s.pruneLoop()
+		go s.presenceLoop()
+		s.rwm.RLock()
+		attachments := s.allAttachments()
+		s.rwm.RUnlock()
+		go s.replicateBlobs(attachments)
 		s.raftStarted.Store(true)
 		s.initAEAD()
 		s.clusterStarted.Store(true)
@@ -164,8 +233,12 @@ func (s *Server) applyAll(entries []raftpb.Entry) {
 	lastIndex := s.storage.Index
 	s.rwm.RUnlock()
 
+	type indexedCommand struct {
+		index uint64
+		cmd   storage.InternalRaftCommand
+	}
 	newIndex := lastIndex
-	var commands []storage.InternalRaftCommand
+	var commands []indexedCommand
 	for _, entry := range entries {
 		if entry.Index <= lastIndex {
 			continue
@@ -176,25 +249,131 @@ func (s *Server) applyAll(entries []raftpb.Entry) {
 		}
 		var cmd storage.InternalRaftCommand
 		cmd.MustUnmarshalGOB(entry.Data)
-		commands = append(commands, cmd)
+		commands = append(commands, indexedCommand{index: entry.Index, cmd: cmd})
 	}
 
 	s.rwm.Lock()
-	for _, cmd := range commands {
-		result := cmd.Execute(s.storage)
-		s.applyNotify.Trigger(cmd.ID, result)
+	for _, c := range commands {
+		s.storage.Index = c.index
+		result := c.cmd.Execute(s.storage)
+		s.applyNotify.Trigger(c.cmd.ID, result)
 	}
 	s.storage.Index = newIndex
+	changes := s.storage.PendingChanges()
+	if err := s.backend.Commit(s.storage); err != nil {
+		s.lg.Panic("failed to commit storage backend", zap.Error(err))
+	}
+	events := s.storage.TakeEvents()
 	s.appliedIndex.Store(newIndex)
 	s.rwm.Unlock()
 	s.applyWait.Trigger(newIndex)
+	s.hub.publish(events)
+	if len(changes.Attachments) > 0 {
+		attachments := make([]string, 0, len(changes.Attachments))
+		for id := range changes.Attachments {
+			attachments = append(attachments, id)
+		}
+		go s.replicateBlobs(attachments)
+	}
+	s.maybeSnapshot(newIndex)
 }
 
+// applySnapshot replaces the state with the one of the snapshot, which is
+// the bbolt database received or saved with it, or the data of snapshots
+// of old versions.
 func (s *Server) applySnapshot(snap raftpb.Snapshot) {
+	index := snap.Metadata.Index
 	s.rwm.Lock()
-	defer s.rwm.Unlock()
-	s.storage.RecoverFromSnapshot(snap.Data)
-	s.appliedIndex.Store(snap.Metadata.Index)
+	if index <= s.storage.Index {
+		s.rwm.Unlock()
+		return
+	}
+	st := storage.NewStorage()
+	if path, err := s.node.SnapshotDBPath(index); err == nil {
+		if err := s.backend.Restore(st, path); err != nil {
+			s.lg.Panic("failed to restore storage backend", zap.String("path", path), zap.Error(err))
+		}
+	} else if len(snap.Data) > 0 {
+		st.RecoverFromSnapshot(snap.Data)
+		st.Index = index
+		if err := s.backend.Reset(st); err != nil {
+			s.lg.Panic("failed to reset storage backend", zap.Error(err))
+		}
+	} else {
+		s.lg.Panic("no state is found for the snapshot", zap.Uint64("index", index))
+	}
+	s.storage = st
+	attachments := s.allAttachments()
+	s.appliedIndex.Store(index)
+	s.rwm.Unlock()
+	if index > s.snapshotIndex.Load() {
+		s.snapshotIndex.Store(index)
+	}
+	s.applyWait.Trigger(index)
+	go s.replicateBlobs(attachments)
+}
+
+// createSnapshot creates a raft snapshot of the applied state and returns
+// the index of it. The state persisted by the bolt backend is not copied,
+// the memory backend saves a copy with the snapshot to recover from it on
+// restart.
+func (s *Server) createSnapshot(ctx context.Context) (uint64, error) {
+	s.rwm.RLock()
+	index := s.storage.Index
+	var db io.ReadCloser
+	if s.cfg.Backend == storage.BackendMemory {
+		var err error
+		if db, _, index, err = s.backend.Snapshot(s.storage); err != nil {
+			s.rwm.RUnlock()
+			return 0, err
+		}
+	}
+	s.rwm.RUnlock()
+	if db != nil {
+		err := s.node.SaveSnapshotDB(db, index)
+		db.Close()
+		if err != nil {
+			return 0, err
+		}
+	}
+	snap, err := s.node.CreateSnapshot(ctx, index, nil)
+	if err != nil {
+		return 0, err
+	}
+	if snap.Metadata.Index > s.snapshotIndex.Load() {
+		s.snapshotIndex.Store(snap.Metadata.Index)
+	}
+	return snap.Metadata.Index, nil
+}
+
+// maybeSnapshot creates a snapshot in the background once SnapshotCount
+// entries have been applied since the latest one, so that the raft log is
+// compacted.
+func (s *Server) maybeSnapshot(index uint64) {
+	if s.cfg.SnapshotCount == 0 || index < s.snapshotIndex.Load()+s.cfg.SnapshotCount {
+		return
+	}
+	if !s.snapshotting.CAS(false, true) {
+		return
+	}
+	go func() {
+		defer s.snapshotting.Store(false)
+		if _, err := s.createSnapshot(context.Background()); err != nil {
+			s.lg.Warn("failed to create snapshot", zap.Error(err))
+		}
+	}()
+}
+
+// backendSnapshotSource streams the state persisted by the storage backend
+// to the followers which need a snapshot.
+type backendSnapshotSource struct {
+	s *Server
+}
+
+func (src backendSnapshotSource) OpenSnapshot() (io.ReadCloser, int64, uint64, error) {
+	src.s.rwm.RLock()
+	defer src.s.rwm.RUnlock()
+	return src.s.backend.Snapshot(src.s.storage)
 }
 
 func (s *Server) handleApplyTasks() {
@@ -225,31 +404,36 @@ func (s *Server) proposeRaftCommand(
 	}
 }
 
+// handleReadStates dispatches read states to the ReadIndex requests waiting
+// for them, so that overlapping requests never lose their read states.
+func (s *Server) handleReadStates() {
+	for rs := range s.node.ReadStates() {
+		if len(rs.RequestCtx) != 8 {
+			s.lg.Warn("unexpected read state request context", zap.Binary("request-ctx", rs.RequestCtx))
+			continue
+		}
+		s.readStateNotify.Trigger(binary.BigEndian.Uint64(rs.RequestCtx), rs)
+	}
+}
+
 func (s *Server) applyToLatest(ctx context.Context) error {
 	id := s.reqIDGen.Next()
 	ctxToSend := make([]byte, 8)
 	binary.BigEndian.PutUint64(ctxToSend, id)
 
+	notify := s.readStateNotify.Register(id)
+	defer s.readStateNotify.Trigger(id, nil)
 	if err := s.node.ReadIndex(ctx, ctxToSend); err != nil {
 		return err
 	}
 	var rs raft.ReadState
-	for done := false; !done; {
-		select {
-		case rs = <-s.node.ReadStates():
-			done = bytes.Equal(rs.RequestCtx, ctxToSend)
-		case <-ctx.Done():
-			return ctx.Err()
-		}
-	}
-	if s.appliedIndex.Load() < rs.Index {
-		select {
-		case <-s.applyWait.Wait(rs.Index):
-		case <-ctx.Done():
-			return ctx.Err()
-		}
+	select {
+	case v := <-notify:
+		rs = v.(raft.ReadState)
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	return nil
+	return s.waitApplied(ctx, rs.Index)
 }
 
 func (s *Server) linearizableReadLoop() {
@@ -260,7 +444,7 @@ func (s *Server) linearizableReadLoop() {
 		s.readyRead = future.NewResult()
 		s.readyReadMu.Unlock()
 
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		ctx, cancel := context.WithTimeout(context.Background(), s.cfg.ReadTimeout)
 		err := s.applyToLatest(ctx)
 		cancel()
 		readyRead.Notify(err)
@@ -278,7 +462,7 @@ func (s *Server) linearizableReadNotify(ctx context.Context) error {
 	}
 	select {
 	case <-readyRead.Done():
-		return ctx.Err()
+		return readyRead.Err()
 	case <-ctx.Done():
 		return ctx.Err()
 	}
@@ -0,0 +1,173 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.etcd.io/etcd/server/v3/etcdserver/api/rafthttp"
+	"go.uber.org/zap"
+
+	"github.com/gozssky/groupchat/pkg/raftnode"
+	"github.com/gozssky/groupchat/pkg/storage"
+)
+
+func testConfig(t *testing.T) Config {
+	cfg := DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	cfg.Backend = storage.BackendMemory
+	cfg.ReadTimeout = time.Millisecond * 500
+	cfg.SecretKeyRetryInterval = time.Millisecond * 10
+	cfg.Raft.TickInterval = time.Millisecond * 10
+	return cfg
+}
+
+type testServer struct {
+	*Server
+	url     string
+	handler http.Handler
+
+	mu sync.Mutex
+	// received counts the client requests received on the port by path.
+	received map[string]int
+}
+
+func (s *testServer) receivedOf(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.received[path]
+}
+
+// startTestServer serves both client requests and raft messages of a server
+// on a random local port, and starts its raft node created by newRaftNode
+// with the url of the port. It returns once the cluster is started.
+func startTestServer(t *testing.T, cfg Config, newRaftNode func(s *testServer) *raftnode.Node) *testServer {
+	gin.SetMode(gin.TestMode)
+	s := &testServer{Server: NewServer(zap.NewNop(), cfg), received: make(map[string]int)}
+	if err := s.openBackend(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.openBlobs(); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.url = "http://" + l.Addr().String()
+	s.handler = s.newHandler(s.newChatRouter())
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, rafthttp.RaftPrefix) {
+			s.mu.Lock()
+			s.received[r.URL.Path]++
+			s.mu.Unlock()
+		}
+		s.handler.ServeHTTP(w, r)
+	})}
+	go srv.Serve(l)
+	s.bootstrap(func() *raftnode.Node { return newRaftNode(s) })
+	t.Cleanup(func() {
+		srv.Close()
+		s.node.Stop()
+		s.backend.Close()
+	})
+	return s
+}
+
+// newTestServer starts a server of a new one-member cluster.
+func newTestServer(t *testing.T, cfg Config) *testServer {
+	return startTestServer(t, cfg, func(s *testServer) *raftnode.Node {
+		return raftnode.NewRaftNode(s.lg, cfg.Raft, s.url, nil, cfg.DataDir)
+	})
+}
+
+// joinTestServer adds a new member to the cluster of the leader and starts
+// its server.
+func joinTestServer(t *testing.T, leader *testServer, cfg Config) *testServer {
+	return startTestServer(t, cfg, func(s *testServer) *raftnode.Node {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		id, err := leader.node.AddMember(ctx, s.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raftnode.JoinRaftNode(s.lg, cfg.Raft, id, leader.node.Members(), cfg.DataDir)
+	})
+}
+
+// request sends a request to the router of the server and returns the
+// recorded response.
+func (s *testServer) request(method, target string, header http.Header, body io.Reader) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, body)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, req)
+	return w
+}
+
+// login creates the user and returns its token.
+func (s *testServer) login(t *testing.T, name string) string {
+	t.Helper()
+	body := fmt.Sprintf(`{"username":%q,"password":"secret"}`, name)
+	if w := s.request(http.MethodPost, "/user", nil, strings.NewReader(body)); w.Code != http.StatusOK {
+		t.Fatalf("failed to create user %s: %s", name, w.Body)
+	}
+	w := s.request(http.MethodGet, "/userLogin?password=secret&username="+name, nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed to login %s: %s", name, w.Body)
+	}
+	return w.Body.String()
+}
+
+// signed returns the headers signing the request as another member.
+func (s *testServer) signed(method, target, body string) http.Header {
+	req := httptest.NewRequest(method, target, nil)
+	s.signPeerRequest(req, []byte(body))
+	return req.Header
+}
+
+func bearer(token string) http.Header {
+	return http.Header{"Authorization": []string{"Bearer " + token}}
+}
+
+func TestAutomaticSnapshot(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.SnapshotCount = 5
+	s := newTestServer(t, cfg)
+	for i := 0; i < int(cfg.SnapshotCount); i++ {
+		s.login(t, fmt.Sprintf("user%d", i))
+	}
+	deadline := time.Now().Add(time.Second * 5)
+	for s.snapshotIndex.Load() < cfg.SnapshotCount {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for automatic snapshot")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	snap, err := s.node.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The memory backend saves the state with the snapshot.
+	path, err := s.node.SnapshotDBPath(snap.Metadata.Index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := storage.NewStorage()
+	if err := s.backend.Restore(restored, path); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Index != snap.Metadata.Index || len(restored.Users) == 0 {
+		t.Fatalf("unexpected state at %d with %d users", restored.Index, len(restored.Users))
+	}
+}
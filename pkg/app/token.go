@@ -1,28 +1,45 @@
 package app
 
 import (
+	"bytes"
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base64"
+	"strconv"
 )
 
-func generateToken(username string, aead cipher.AEAD) string {
-	cipherText := make([]byte, aead.NonceSize()+len(username)+aead.Overhead())
+// tokenEpochSep separates the user name and the token epoch in the plain
+// text of a token. Tokens generated by old versions only contain the user
+// name, their epoch is 0.
+const tokenEpochSep = 0
+
+func generateToken(username string, epoch uint64, aead cipher.AEAD) string {
+	plainText := append([]byte(username), tokenEpochSep)
+	plainText = strconv.AppendUint(plainText, epoch, 10)
+	cipherText := make([]byte, aead.NonceSize()+len(plainText)+aead.Overhead())
 	rand.Read(cipherText[:aead.NonceSize()])
 	nonce := cipherText[:aead.NonceSize():aead.NonceSize()]
-	cipherText = aead.Seal(cipherText[:len(nonce)], nonce, []byte(username), nil)
+	cipherText = aead.Seal(cipherText[:len(nonce)], nonce, plainText, nil)
 	return base64.StdEncoding.EncodeToString(cipherText)
 }
 
-func parseUserNameFromToken(token string, aead cipher.AEAD) (string, bool) {
+func parseToken(token string, aead cipher.AEAD) (username string, epoch uint64, ok bool) {
 	cipherText, err := base64.StdEncoding.DecodeString(token)
-	if err != nil {
-		return "", false
+	if err != nil || len(cipherText) < aead.NonceSize() {
+		return "", 0, false
 	}
 	nonce := cipherText[:aead.NonceSize():aead.NonceSize()]
 	plainText, err := aead.Open(nil, nonce, cipherText[aead.NonceSize():], nil)
 	if err != nil {
-		return "", false
+		return "", 0, false
+	}
+	i := bytes.IndexByte(plainText, tokenEpochSep)
+	if i < 0 {
+		return string(plainText), 0, true
+	}
+	epoch, err = strconv.ParseUint(string(plainText[i+1:]), 10, 64)
+	if err != nil {
+		return "", 0, false
 	}
-	return string(plainText), true
+	return string(plainText[:i]), epoch, true
 }
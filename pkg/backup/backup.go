@@ -0,0 +1,150 @@
+package backup
+
+import (
+	"encoding/gob"
+	"errors"
+	"io"
+
+	"github.com/gozssky/groupchat/pkg/metadata"
+)
+
+// dbChunkSize is the max size of each chunk of the database in a backup.
+const dbChunkSize = 1 << 20
+
+// Backup is a consistent copy of the state machine of a node together with
+// the raft metadata required to seed a new cluster from it. It is followed
+// by the database of the state machine and the attachment blobs in the
+// stream.
+type Backup struct {
+	Members []metadata.Peer
+	Index   uint64
+	Term    uint64
+	// Data is the state machine encoded by backups of old versions, which
+	// carry no database.
+	Data []byte
+	// DBSize is the size of the bbolt database of the state machine, which
+	// follows the backup in chunks.
+	DBSize int64
+	// Blobs is the number of blobs following the backup, it is 0 for
+	// backups of old versions.
+	Blobs int
+	// MissingBlobs are the ids of the attachments whose blobs were not
+	// found on any member when the backup was taken.
+	MissingBlobs []string
+}
+
+// Blob is the content of an attachment.
+type Blob struct {
+	ID   string
+	Data []byte
+}
+
+// Encoder writes a backup, its database and then its blobs.
+type Encoder struct {
+	enc    *gob.Encoder
+	dbLeft int64
+	left   int
+}
+
+// NewEncoder writes the backup, the database of b.DBSize bytes and b.Blobs
+// blobs must be written after it.
+func NewEncoder(w io.Writer, b *Backup) (*Encoder, error) {
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(b); err != nil {
+		return nil, err
+	}
+	return &Encoder{enc: enc, dbLeft: b.DBSize, left: b.Blobs}, nil
+}
+
+// EncodeDB writes the database read from r in chunks.
+func (e *Encoder) EncodeDB(r io.Reader) error {
+	buf := make([]byte, dbChunkSize)
+	for e.dbLeft > 0 {
+		n := int64(len(buf))
+		if n > e.dbLeft {
+			n = e.dbLeft
+		}
+		if _, err := io.ReadFull(r, buf[:n]); err != nil {
+			return err
+		}
+		if err := e.enc.Encode(buf[:n]); err != nil {
+			return err
+		}
+		e.dbLeft -= n
+	}
+	return nil
+}
+
+func (e *Encoder) EncodeBlob(blob *Blob) error {
+	if e.dbLeft > 0 {
+		return errors.New("database must be written before blobs")
+	}
+	if e.left == 0 {
+		return errors.New("too many blobs")
+	}
+	e.left--
+	return e.enc.Encode(blob)
+}
+
+// Decoder reads the database and the blobs following a backup.
+type Decoder struct {
+	dec    *gob.Decoder
+	dbLeft int64
+	chunk  []byte
+	left   int
+}
+
+// NewDecoder reads the backup, the database and the blobs following it are
+// read by the returned decoder.
+func NewDecoder(r io.Reader) (*Backup, *Decoder, error) {
+	dec := gob.NewDecoder(r)
+	var b Backup
+	if err := dec.Decode(&b); err != nil {
+		return nil, nil, err
+	}
+	return &b, &Decoder{dec: dec, dbLeft: b.DBSize, left: b.Blobs}, nil
+}
+
+// Read reads the database, it returns io.EOF after the whole database is
+// read. A truncated backup fails with io.ErrUnexpectedEOF.
+func (d *Decoder) Read(p []byte) (int, error) {
+	if len(d.chunk) == 0 {
+		if d.dbLeft == 0 {
+			return 0, io.EOF
+		}
+		if err := d.dec.Decode(&d.chunk); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		if int64(len(d.chunk)) > d.dbLeft {
+			return 0, errors.New("database exceeds its size")
+		}
+		d.dbLeft -= int64(len(d.chunk))
+	}
+	n := copy(p, d.chunk)
+	d.chunk = d.chunk[n:]
+	return n, nil
+}
+
+// DecodeBlob returns the next blob, or io.EOF after the last one. A
+// truncated backup fails with io.ErrUnexpectedEOF. The database must have
+// been read before.
+func (d *Decoder) DecodeBlob() (*Blob, error) {
+	if d.dbLeft > 0 || len(d.chunk) > 0 {
+		return nil, errors.New("database must be read before blobs")
+	}
+	if d.left == 0 {
+		return nil, io.EOF
+	}
+	var blob Blob
+	if err := d.dec.Decode(&blob); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	d.left--
+	return &blob, nil
+}
@@ -0,0 +1,102 @@
+package backup
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/client/pkg/v3/types"
+
+	"github.com/gozssky/groupchat/pkg/metadata"
+)
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
+
+func TestEncoding(t *testing.T) {
+	b := Backup{
+		Index: rand.Uint64(),
+		Term:  rand.Uint64(),
+		Data:  make([]byte, 1024),
+	}
+	rand.Read(b.Data)
+	for i := 0; i < 3; i++ {
+		b.Members = append(b.Members, metadata.Peer{
+			ID:  types.ID(i + 1),
+			URL: "http://127.0.0.1:8080",
+		})
+	}
+	db := make([]byte, dbChunkSize*2+10)
+	rand.Read(db)
+	b.DBSize = int64(len(db))
+	blobs := []Blob{{ID: "a", Data: []byte("hello")}, {ID: "b", Data: []byte("world")}}
+	b.Blobs = len(blobs)
+	b.MissingBlobs = []string{"c"}
+	var buf bytes.Buffer
+	enc, err := NewEncoder(&buf, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.EncodeBlob(&blobs[0]); err == nil {
+		t.Fatal("expected an error for blobs before the database")
+	}
+	if err := enc.EncodeDB(bytes.NewReader(db)); err != nil {
+		t.Fatal(err)
+	}
+	for i := range blobs {
+		if err := enc.EncodeBlob(&blobs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.EncodeBlob(&blobs[0]); err == nil {
+		t.Fatal("expected an error for too many blobs")
+	}
+	data := buf.Bytes()
+
+	b2, dec, err := NewDecoder(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&b, b2) {
+		t.Fatal("backup has changed after encoding then decoding")
+	}
+	if db2, err := io.ReadAll(dec); err != nil || !bytes.Equal(db, db2) {
+		t.Fatalf("database has changed after encoding then decoding: %v", err)
+	}
+	var blobs2 []Blob
+	for {
+		blob, err := dec.DecodeBlob()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		blobs2 = append(blobs2, *blob)
+	}
+	if !reflect.DeepEqual(blobs, blobs2) {
+		t.Fatalf("blobs %v have changed after encoding then decoding %v", blobs, blobs2)
+	}
+
+	// A backup truncated before its last blob is detected.
+	_, dec, err = NewDecoder(bytes.NewReader(data[:len(data)-5]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dec.DecodeBlob(); err == nil {
+		t.Fatal("expected an error for blobs before the database")
+	}
+	if _, err := io.Copy(io.Discard, dec); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dec.DecodeBlob(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dec.DecodeBlob(); err == nil || err == io.EOF {
+		t.Fatalf("expected an error for truncated backup, got %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+// Package blob implements a content-addressed store of files, every blob is
+// identified by the hex encoded SHA-256 of its content.
+package blob
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var (
+	ErrInvalidID = errors.New("invalid blob id")
+	ErrTooLarge  = errors.New("blob is too large")
+	ErrMismatch  = errors.New("blob content does not match its id")
+)
+
+// Store keeps blobs under a directory, each in a file named by its id under
+// a sub directory named by the first two characters of the id.
+type Store struct {
+	dir string
+}
+
+// Open returns the store under the directory, creating it if it doesn't
+// exist.
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir}, nil
+}
+
+// ValidID returns whether id is a well-formed blob id.
+func ValidID(id string) bool {
+	if len(id) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range id {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, id[:2], id)
+}
+
+// Put stores the content read from r and returns its id and size. It fails
+// with ErrTooLarge if the content exceeds maxSize bytes, and ErrMismatch if
+// id is not empty and doesn't match the content.
+func (s *Store) Put(r io.Reader, maxSize int64, id string) (string, int64, error) {
+	if len(id) > 0 && !ValidID(id) {
+		return "", 0, ErrInvalidID
+	}
+	f, err := os.CreateTemp(s.dir, "upload-")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return "", 0, err
+	}
+	if size > maxSize {
+		return "", 0, ErrTooLarge
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if len(id) > 0 && id != sum {
+		return "", 0, ErrMismatch
+	}
+	if err := f.Sync(); err != nil {
+		return "", 0, err
+	}
+	if err := f.Close(); err != nil {
+		return "", 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path(sum)), 0700); err != nil {
+		return "", 0, err
+	}
+	// The same content may be stored concurrently, either of them wins.
+	if err := os.Rename(f.Name(), s.path(sum)); err != nil {
+		return "", 0, err
+	}
+	return sum, size, nil
+}
+
+// Has returns whether the blob is stored.
+func (s *Store) Has(id string) bool {
+	if !ValidID(id) {
+		return false
+	}
+	_, err := os.Stat(s.path(id))
+	return err == nil
+}
+
+// Get opens the blob for reading, the caller must close it.
+func (s *Store) Get(id string) (*os.File, error) {
+	if !ValidID(id) {
+		return nil, ErrInvalidID
+	}
+	return os.Open(s.path(id))
+}
@@ -0,0 +1,56 @@
+package blob
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	const sum = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	id, size, err := s.Put(strings.NewReader("hello"), 5, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != sum || size != 5 {
+		t.Fatalf("unexpected blob %s of size %d", id, size)
+	}
+	if !s.Has(id) {
+		t.Fatal("blob should be stored")
+	}
+	f, err := s.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("unexpected content %q: %v", data, err)
+	}
+
+	// Storing the same content again is fine.
+	if _, _, err := s.Put(strings.NewReader("hello"), 5, sum); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Put(strings.NewReader("hello!"), 5, ""); err != ErrTooLarge {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+	if _, _, err := s.Put(strings.NewReader("bye"), 5, sum); err != ErrMismatch {
+		t.Fatalf("expected ErrMismatch, got %v", err)
+	}
+	if _, err := s.Get("../secret"); err != ErrInvalidID {
+		t.Fatalf("expected ErrInvalidID, got %v", err)
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("temporary files should be removed: %v", entries)
+	}
+}
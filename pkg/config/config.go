@@ -0,0 +1,148 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v2"
+
+	"github.com/gozssky/groupchat/pkg/app"
+)
+
+// EnvPrefix is the prefix of environment variables overriding the config,
+// e.g. GROUPCHAT_RAFT_ELECTION_TICK overrides raft.election-tick.
+const EnvPrefix = "GROUPCHAT"
+
+// Config is the config of chat server.
+type Config struct {
+	LogLevel   string `yaml:"log-level"`
+	app.Config `yaml:",inline"`
+}
+
+func Default() Config {
+	return Config{
+		LogLevel: "info",
+		Config:   app.DefaultConfig(),
+	}
+}
+
+// Load returns the default config overridden by the given config file and
+// environment variables. The file is skipped if path is empty.
+func Load(path string) (Config, error) {
+	cfg := Default()
+	if len(path) > 0 {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, err
+		}
+		if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("failed to parse config file %s: %v", path, err)
+		}
+	}
+	if err := cfg.ApplyEnv(os.LookupEnv); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// ApplyEnv overrides the config with environment variables found by lookup.
+func (cfg *Config) ApplyEnv(lookup func(key string) (string, bool)) error {
+	return applyEnv(reflect.ValueOf(cfg).Elem(), EnvPrefix, lookup)
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func applyEnv(v reflect.Value, prefix string, lookup func(key string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		fv := v.Field(i)
+		if field.Anonymous {
+			if err := applyEnv(fv, prefix, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+		key := prefix + "_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		if fv.Kind() == reflect.Struct {
+			if err := applyEnv(fv, key, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+		value, ok := lookup(key)
+		if !ok {
+			continue
+		}
+		if err := setValue(fv, value); err != nil {
+			return fmt.Errorf("invalid value %q of %s: %v", value, key, err)
+		}
+	}
+	return nil
+}
+
+func setValue(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 0, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint64:
+		n, err := strconv.ParseUint(s, 0, 64)
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	default:
+		return fmt.Errorf("unsupported kind %s", v.Kind())
+	}
+	return nil
+}
+
+func (cfg *Config) Validate() error {
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		return err
+	}
+	return cfg.Config.Validate()
+}
+
+// redacted replaces the value of a secret when the config is printed.
+const redacted = "<redacted>"
+
+// Encode encodes the config in the format of config file. Secrets such as
+// the admin token are replaced with a placeholder.
+func (cfg *Config) Encode() ([]byte, error) {
+	c := *cfg
+	if len(c.AdminToken) > 0 {
+		c.AdminToken = redacted
+	}
+	return yaml.Marshal(&c)
+}
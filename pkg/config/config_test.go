@@ -0,0 +1,135 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfigFile(t, `
+port: 9090
+log-level: debug
+read-timeout: 3s
+raft:
+  election-tick: 30
+  tick-interval: 200ms
+`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Default()
+	expected.Port = 9090
+	expected.LogLevel = "debug"
+	expected.ReadTimeout = time.Second * 3
+	expected.Raft.ElectionTick = 30
+	expected.Raft.TickInterval = time.Millisecond * 200
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Fatalf("loaded config %+v does not match the expected %+v", cfg, expected)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadUnknownField(t *testing.T) {
+	path := writeConfigFile(t, "raft:\n  election-ticks: 30\n")
+	if _, err := Load(path); err == nil {
+		t.Fatal("expected an error for unknown field")
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"GROUPCHAT_PORT":                  "9091",
+		"GROUPCHAT_DATA_DIR":              "/data",
+		"GROUPCHAT_RAFT_HEARTBEAT_TICK":   "2",
+		"GROUPCHAT_RAFT_TICK_INTERVAL":    "50ms",
+		"GROUPCHAT_RAFT_CLUSTER_ID":       "0x2000",
+		"GROUPCHAT_RAFT_READ_ONLY_OPTION": "safe",
+	}
+	cfg := Default()
+	if err := cfg.ApplyEnv(func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}); err != nil {
+		t.Fatal(err)
+	}
+	expected := Default()
+	expected.Port = 9091
+	expected.DataDir = "/data"
+	expected.Raft.HeartbeatTick = 2
+	expected.Raft.TickInterval = time.Millisecond * 50
+	expected.Raft.ClusterID = 0x2000
+	expected.Raft.ReadOnlyOption = "safe"
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Fatalf("config %+v does not match the expected %+v", cfg, expected)
+	}
+
+	env = map[string]string{"GROUPCHAT_RAFT_ELECTION_TICK": "ten"}
+	if err := cfg.ApplyEnv(func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}); err == nil {
+		t.Fatal("expected an error for invalid value")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Default()
+	cfg.LogLevel = "verbose"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected an error for unknown log level")
+	}
+	cfg = Default()
+	cfg.Raft.HeartbeatTick = cfg.Raft.ElectionTick
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected an error for invalid raft config")
+	}
+}
+
+func TestEncode(t *testing.T) {
+	cfg := Default()
+	data, err := cfg.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeConfigFile(t, string(data))
+	cfg2, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg, cfg2) {
+		t.Fatalf("config %+v has changed after encoding then loading %+v", cfg, cfg2)
+	}
+}
+
+func TestEncodeRedactsSecrets(t *testing.T) {
+	cfg := Default()
+	cfg.AdminToken = "s3cr3t"
+	data, err := cfg.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), cfg.AdminToken) {
+		t.Fatalf("encoded config contains the admin token:\n%s", data)
+	}
+	if !strings.Contains(string(data), "admin-token: <redacted>") {
+		t.Fatalf("encoded config does not redact the admin token:\n%s", data)
+	}
+	if cfg.AdminToken != "s3cr3t" {
+		t.Fatal("encoding modified the config")
+	}
+}
@@ -14,6 +14,9 @@ type Peer struct {
 type Metadata struct {
 	ID    types.ID `json:"id"`
 	Peers []Peer   `json:"peers"`
+	// Removed are the ids of members removed from the cluster, they are
+	// never assigned to new members.
+	Removed []types.ID `json:"removed,omitempty"`
 }
 
 func (md *Metadata) MustMarshalJSON() []byte {
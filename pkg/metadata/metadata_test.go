@@ -29,6 +29,7 @@ func TestEncoding(t *testing.T) {
 			ID:  types.ID(rand.Uint64()),
 			URL: randString(),
 		})
+		md.Removed = append(md.Removed, types.ID(rand.Uint64()))
 	}
 	data := md.MustMarshalJSON()
 	var md2 Metadata
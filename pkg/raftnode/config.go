@@ -0,0 +1,97 @@
+package raftnode
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.etcd.io/etcd/raft/v3"
+)
+
+const (
+	ReadOnlySafe       = "safe"
+	ReadOnlyLeaseBased = "lease-based"
+)
+
+// Config contains the tuning and timing parameters of a raft node. All
+// members of a cluster should use the same config.
+type Config struct {
+	// ClusterID identifies the cluster, messages from other clusters are
+	// rejected by the transport.
+	ClusterID uint64 `yaml:"cluster-id"`
+	// TickInterval is the interval of a raft logical clock tick.
+	TickInterval time.Duration `yaml:"tick-interval"`
+	// ElectionTick is the number of ticks without hearing from a leader
+	// before a follower starts an election.
+	ElectionTick int `yaml:"election-tick"`
+	// HeartbeatTick is the number of ticks between heartbeats of a leader.
+	HeartbeatTick int `yaml:"heartbeat-tick"`
+	// MaxSizePerMsg limits the byte size of each append message.
+	MaxSizePerMsg uint64 `yaml:"max-size-per-msg"`
+	// MaxInflightMsgs limits the number of in-flight append messages.
+	MaxInflightMsgs int `yaml:"max-inflight-msgs"`
+	// MaxUncommittedEntriesSize limits the byte size of uncommitted entries
+	// of a leader, 0 means no limit.
+	MaxUncommittedEntriesSize uint64 `yaml:"max-uncommitted-entries-size"`
+	// ReadOnlyOption is either "safe" or "lease-based". The lease-based
+	// option doesn't communicate with a quorum for ReadIndex requests, but
+	// it may serve stale reads under clock drift.
+	ReadOnlyOption string `yaml:"read-only-option"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		ClusterID:                 0x1000,
+		TickInterval:              time.Millisecond * 100,
+		ElectionTick:              10,
+		HeartbeatTick:             1,
+		MaxSizePerMsg:             1024 * 1024,
+		MaxInflightMsgs:           256,
+		MaxUncommittedEntriesSize: 1 << 30,
+		ReadOnlyOption:            ReadOnlySafe,
+	}
+}
+
+func (cfg *Config) Validate() error {
+	if cfg.ClusterID == 0 {
+		return errors.New("cluster id must not be 0")
+	}
+	if cfg.TickInterval <= 0 {
+		return errors.New("tick interval must be greater than 0")
+	}
+	if cfg.HeartbeatTick <= 0 {
+		return errors.New("heartbeat tick must be greater than 0")
+	}
+	if cfg.ElectionTick <= cfg.HeartbeatTick {
+		return fmt.Errorf("election tick %d must be greater than heartbeat tick %d",
+			cfg.ElectionTick, cfg.HeartbeatTick)
+	}
+	if cfg.MaxInflightMsgs <= 0 {
+		return errors.New("max inflight msgs must be greater than 0")
+	}
+	if cfg.MaxUncommittedEntriesSize > 0 && cfg.MaxUncommittedEntriesSize < cfg.MaxSizePerMsg {
+		return fmt.Errorf("max uncommitted entries size %d must not be less than max size per msg %d",
+			cfg.MaxUncommittedEntriesSize, cfg.MaxSizePerMsg)
+	}
+	if _, err := cfg.readOnlyOption(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (cfg *Config) readOnlyOption() (raft.ReadOnlyOption, error) {
+	switch cfg.ReadOnlyOption {
+	case ReadOnlySafe:
+		return raft.ReadOnlySafe, nil
+	case ReadOnlyLeaseBased:
+		return raft.ReadOnlyLeaseBased, nil
+	default:
+		return 0, fmt.Errorf("unknown read only option %q", cfg.ReadOnlyOption)
+	}
+}
+
+// ElectionTimeout returns the minimum duration before a follower starts an
+// election.
+func (cfg *Config) ElectionTimeout() time.Duration {
+	return cfg.TickInterval * time.Duration(cfg.ElectionTick)
+}
@@ -0,0 +1,51 @@
+package raftnode
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(cfg *Config)
+		valid  bool
+	}{
+		{"default", func(cfg *Config) {}, true},
+		{"lease-based read", func(cfg *Config) { cfg.ReadOnlyOption = ReadOnlyLeaseBased }, true},
+		{"no limit of uncommitted size", func(cfg *Config) { cfg.MaxUncommittedEntriesSize = 0 }, true},
+		{"zero cluster id", func(cfg *Config) { cfg.ClusterID = 0 }, false},
+		{"zero tick interval", func(cfg *Config) { cfg.TickInterval = 0 }, false},
+		{"zero heartbeat tick", func(cfg *Config) { cfg.HeartbeatTick = 0 }, false},
+		{"election tick equals heartbeat tick", func(cfg *Config) {
+			cfg.ElectionTick = 5
+			cfg.HeartbeatTick = 5
+		}, false},
+		{"zero inflight msgs", func(cfg *Config) { cfg.MaxInflightMsgs = 0 }, false},
+		{"uncommitted size less than msg size", func(cfg *Config) {
+			cfg.MaxSizePerMsg = 1024
+			cfg.MaxUncommittedEntriesSize = 1
+		}, false},
+		{"unknown read only option", func(cfg *Config) { cfg.ReadOnlyOption = "stale" }, false},
+	}
+	for _, tt := range tests {
+		cfg := DefaultConfig()
+		tt.modify(&cfg)
+		err := cfg.Validate()
+		if tt.valid && err != nil {
+			t.Fatalf("%s: unexpected error %v", tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Fatalf("%s: expected an error", tt.name)
+		}
+	}
+}
+
+func TestConfigElectionTimeout(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TickInterval = time.Millisecond * 200
+	cfg.ElectionTick = 15
+	if timeout := cfg.ElectionTimeout(); timeout != time.Second*3 {
+		t.Fatalf("election timeout %v does not match the expected %v", timeout, time.Second*3)
+	}
+}
@@ -0,0 +1,135 @@
+package raftnode
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"go.etcd.io/etcd/client/pkg/v3/types"
+	"go.etcd.io/etcd/pkg/v3/pbutil"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+	"go.etcd.io/etcd/server/v3/wal"
+	"go.uber.org/zap"
+)
+
+// forcedMarkerFileName is the file written to the data dir once the member
+// has been forced into a new cluster, it holds the index of the last forced
+// conf change. Restarting with the flag again would undo the membership
+// changes made since, so the flag is ignored while the marker exists.
+const forcedMarkerFileName = "force-new-cluster"
+
+// readForcedMarker returns the index in the marker file and whether it
+// exists.
+func readForcedMarker(lg *zap.Logger, dataDir string) (uint64, bool) {
+	path := filepath.Join(dataDir, forcedMarkerFileName)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, false
+	}
+	if err != nil {
+		lg.Fatal("failed to read force-new-cluster marker", zap.String("path", path), zap.Error(err))
+	}
+	index, err := strconv.ParseUint(string(data), 10, 64)
+	if err != nil {
+		lg.Fatal("corrupt force-new-cluster marker", zap.String("path", path), zap.Error(err))
+	}
+	return index, true
+}
+
+func writeForcedMarker(lg *zap.Logger, dataDir string, index uint64) {
+	path := filepath.Join(dataDir, forcedMarkerFileName)
+	if err := os.WriteFile(path, []byte(strconv.FormatUint(index, 10)), 0644); err != nil {
+		lg.Fatal("failed to write force-new-cluster marker", zap.String("path", path), zap.Error(err))
+	}
+}
+
+// forceNewClusterEntries discards the uncommitted entries and appends conf
+// changes which remove all members except the local one. The appended
+// entries are committed directly and saved to wal.
+func forceNewClusterEntries(
+	lg *zap.Logger,
+	w *wal.WAL,
+	localID types.ID,
+	localURL string,
+	snap *raftpb.Snapshot,
+	st *raftpb.HardState,
+	ents []raftpb.Entry,
+) []raftpb.Entry {
+	for i, entry := range ents {
+		if entry.Index > st.Commit {
+			lg.Info("discarding uncommitted wal entries", zap.Uint64("commit-index", st.Commit),
+				zap.Int("discarded", len(ents)-i))
+			ents = ents[:i]
+			break
+		}
+	}
+
+	var toAppend []raftpb.Entry
+	appendConfChange := func(cc raftpb.ConfChange) {
+		toAppend = append(toAppend, raftpb.Entry{
+			Type:  raftpb.EntryConfChange,
+			Term:  st.Term,
+			Index: st.Commit + uint64(len(toAppend)) + 1,
+			Data:  pbutil.MustMarshal(&cc),
+		})
+	}
+	foundLocal := false
+	for _, id := range memberIDs(snap, ents) {
+		if id == localID {
+			foundLocal = true
+			continue
+		}
+		appendConfChange(raftpb.ConfChange{Type: raftpb.ConfChangeRemoveNode, NodeID: uint64(id)})
+	}
+	if !foundLocal {
+		appendConfChange(raftpb.ConfChange{
+			Type:    raftpb.ConfChangeAddNode,
+			NodeID:  uint64(localID),
+			Context: []byte(localURL),
+		})
+	}
+	if len(toAppend) == 0 {
+		return ents
+	}
+	st.Commit = toAppend[len(toAppend)-1].Index
+	if err := w.Save(*st, toAppend); err != nil {
+		lg.Fatal("failed to save forced conf changes", zap.Error(err))
+	}
+	lg.Warn("forcing a new cluster with the local member only",
+		zap.Stringer("local-member-id", localID), zap.Int("conf-changes", len(toAppend)))
+	return append(ents, toAppend...)
+}
+
+// memberIDs returns the ids of voters after applying all conf changes in ents
+// to the conf state of snap.
+func memberIDs(snap *raftpb.Snapshot, ents []raftpb.Entry) []types.ID {
+	ids := make(map[types.ID]struct{})
+	if snap != nil {
+		for _, id := range snap.Metadata.ConfState.Voters {
+			ids[types.ID(id)] = struct{}{}
+		}
+		for _, id := range snap.Metadata.ConfState.Learners {
+			ids[types.ID(id)] = struct{}{}
+		}
+	}
+	for _, entry := range ents {
+		if entry.Type != raftpb.EntryConfChange {
+			continue
+		}
+		var cc raftpb.ConfChange
+		pbutil.MustUnmarshal(&cc, entry.Data)
+		switch cc.Type {
+		case raftpb.ConfChangeAddNode, raftpb.ConfChangeAddLearnerNode:
+			ids[types.ID(cc.NodeID)] = struct{}{}
+		case raftpb.ConfChangeRemoveNode:
+			delete(ids, types.ID(cc.NodeID))
+		}
+	}
+	sids := make([]types.ID, 0, len(ids))
+	for id := range ids {
+		sids = append(sids, id)
+	}
+	sort.Slice(sids, func(i, j int) bool { return sids[i] < sids[j] })
+	return sids
+}
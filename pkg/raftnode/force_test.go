@@ -0,0 +1,158 @@
+package raftnode
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go.etcd.io/etcd/client/pkg/v3/types"
+	"go.etcd.io/etcd/pkg/v3/pbutil"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+	"go.etcd.io/etcd/server/v3/wal"
+	"go.etcd.io/etcd/server/v3/wal/walpb"
+	"go.uber.org/zap"
+
+	"github.com/gozssky/groupchat/pkg/metadata"
+)
+
+func TestForceNewCluster(t *testing.T) {
+	l, url := listenLocal(t)
+	dataDir := t.TempDir()
+	walDir := filepath.Join(dataDir, "wal")
+	ensureDir(zap.NewNop(), filepath.Join(dataDir, "snap"))
+
+	// A three-member cluster has committed a normal entry after the
+	// bootstrap conf changes, and the local member has an uncommitted one.
+	md := &metadata.Metadata{ID: 1}
+	for i, peerURL := range []string{url, "http://127.0.0.1:1", "http://127.0.0.1:2"} {
+		md.Peers = append(md.Peers, metadata.Peer{ID: types.ID(i + 1), URL: peerURL})
+	}
+	var ents []raftpb.Entry
+	for _, peer := range md.Peers {
+		cc := raftpb.ConfChange{Type: raftpb.ConfChangeAddNode, NodeID: uint64(peer.ID)}
+		ents = append(ents, raftpb.Entry{
+			Type:  raftpb.EntryConfChange,
+			Term:  1,
+			Index: uint64(len(ents) + 1),
+			Data:  pbutil.MustMarshal(&cc),
+		})
+	}
+	ents = append(ents,
+		raftpb.Entry{Type: raftpb.EntryNormal, Term: 2, Index: 4, Data: []byte("committed")},
+		raftpb.Entry{Type: raftpb.EntryNormal, Term: 2, Index: 5, Data: []byte("uncommitted")},
+	)
+	w, err := wal.Create(zap.NewNop(), walDir, md.MustMarshalJSON())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Save(raftpb.HardState{Term: 2, Vote: 2, Commit: 4}, ents); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	saveMembers(zap.NewNop(), dataDir, md)
+
+	node, ok := RestartRaftNode(zap.NewNop(), testConfig(), dataDir, true)
+	if !ok {
+		t.Fatal("node should be restarted")
+	}
+	rc := serveNode(t, node, l)
+	waitFor(t, "leader", rc.IsLead)
+	if ids := memberIDsOf(rc.Members()); len(ids) != 1 || ids[0] != 1 {
+		t.Fatalf("unexpected members %v", ids)
+	}
+	var applied []string
+	for len(applied) == 0 {
+		task := rc.nextTask(t)
+		for _, entry := range task.Entries {
+			if len(entry.Data) > 0 {
+				applied = append(applied, string(entry.Data))
+			}
+		}
+	}
+	if len(applied) != 1 || applied[0] != "committed" {
+		t.Fatalf("unexpected applied entries %v", applied)
+	}
+	rc.stop()
+
+	members := &metadata.Metadata{}
+	loadMembers(zap.NewNop(), dataDir, members)
+	if ids := memberIDsOf(members.Peers); len(ids) != 1 || ids[0] != 1 {
+		t.Fatalf("unexpected persisted members %v", ids)
+	}
+	if len(members.Removed) != 2 || members.Removed[0] != 2 || members.Removed[1] != 3 {
+		t.Fatalf("unexpected persisted removed members %v", members.Removed)
+	}
+
+	w, err = wal.OpenForRead(zap.NewNop(), walDir, walpb.Snapshot{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, st, ents, err := w.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The uncommitted entry is replaced by the conf changes removing the
+	// other members, which are followed by the empty entry of the new term.
+	var removed []uint64
+	for _, entry := range ents[4:] {
+		if entry.Type != raftpb.EntryConfChange {
+			continue
+		}
+		var cc raftpb.ConfChange
+		pbutil.MustUnmarshal(&cc, entry.Data)
+		if cc.Type != raftpb.ConfChangeRemoveNode {
+			t.Fatalf("unexpected conf change %v", cc)
+		}
+		removed = append(removed, cc.NodeID)
+	}
+	if len(removed) != 2 || removed[0] != 2 || removed[1] != 3 {
+		t.Fatalf("unexpected removed members %v in wal", removed)
+	}
+	if ents[4].Index != 5 || ents[4].Type != raftpb.EntryConfChange || ents[5].Index != 6 {
+		t.Fatalf("conf changes should be appended right after the committed entries")
+	}
+	if st.Commit < 6 {
+		t.Fatalf("conf changes should be committed, commit index is %d", st.Commit)
+	}
+	if index, ok := readForcedMarker(zap.NewNop(), dataDir); !ok || index != 6 {
+		t.Fatalf("expect the marker of index 6, got %d, %v", index, ok)
+	}
+
+	// A member is added after the forced restart, restarting with the flag
+	// again must not remove it.
+	last := ents[len(ents)-1]
+	cc := raftpb.ConfChange{Type: raftpb.ConfChangeAddNode, NodeID: 4}
+	added := raftpb.Entry{Type: raftpb.EntryConfChange, Term: last.Term, Index: last.Index + 1, Data: pbutil.MustMarshal(&cc)}
+	st.Commit = added.Index
+	w.Close()
+	w, err = wal.Open(zap.NewNop(), walDir, walpb.Snapshot{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := w.ReadAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Save(st, []raftpb.Entry{added}); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	l, _ = listenLocal(t)
+	node, ok = RestartRaftNode(zap.NewNop(), testConfig(), dataDir, true)
+	if !ok {
+		t.Fatal("node should be restarted")
+	}
+	rc = serveNode(t, node, l)
+	rc.stop()
+	w, err = wal.OpenForRead(zap.NewNop(), walDir, walpb.Snapshot{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	_, _, ents2, err := w.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := memberIDs(nil, ents2); len(ids) != 2 || ids[0] != 1 || ids[1] != 4 {
+		t.Fatalf("members %v should not be forced again", ids)
+	}
+}
@@ -8,14 +8,17 @@ import (
 	"go.etcd.io/etcd/server/v3/etcdserver/api/rafthttp"
 )
 
-type httpRaft struct{ raft.Node }
+type httpRaft struct {
+	raft.Node
+	isIDRemoved func(id uint64) bool
+}
 
 func (h httpRaft) Process(ctx context.Context, m raftpb.Message) error {
 	return h.Step(ctx, m)
 }
 
-func (h httpRaft) IsIDRemoved(_ uint64) bool {
-	return false
+func (h httpRaft) IsIDRemoved(id uint64) bool {
+	return h.isIDRemoved(id)
 }
 
 var _ rafthttp.Raft = httpRaft{}
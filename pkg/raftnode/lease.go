@@ -0,0 +1,79 @@
+package raftnode
+
+import (
+	"sync"
+	"time"
+)
+
+// leaderLease is the time until which the local leader is sure that no other
+// member can be elected. It is renewed by the ReadIndex requests confirmed by
+// a quorum: the members which confirmed a request have heard from the leader
+// after it was issued, and with CheckQuorum they refuse to vote for another
+// member for an election timeout since then, unless the leadership is being
+// transferred.
+type leaderLease struct {
+	mu sync.Mutex
+	// starts are the times when the pending ReadIndex requests were issued,
+	// keyed by their request contexts.
+	starts map[string]time.Time
+	expire time.Time
+	// notBefore is the earliest issue time of the requests which can renew
+	// the lease, requests issued before a leadership change or transfer can't.
+	notBefore time.Time
+}
+
+func newLeaderLease() *leaderLease {
+	return &leaderLease{starts: make(map[string]time.Time)}
+}
+
+// leaseDuration is the duration of the lease since a confirmed request was
+// issued. Followers may count one tick less than the election timeout due to
+// the phase of their ticks, and a tenth of it is left for clock drift.
+func (cfg *Config) leaseDuration() time.Duration {
+	return cfg.TickInterval * time.Duration(cfg.ElectionTick-1) * 9 / 10
+}
+
+// issue records the issue time of a ReadIndex request, requests which are
+// never confirmed are dropped once they are too old to renew the lease.
+func (l *leaderLease) issue(rctx []byte, now time.Time, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for k, start := range l.starts {
+		if now.Sub(start) > d {
+			delete(l.starts, k)
+		}
+	}
+	l.starts[string(rctx)] = now
+}
+
+// confirm renews the lease by the confirmed ReadIndex request if renew is
+// true, i.e. the local member is the leader and confirmed it with a quorum.
+func (l *leaderLease) confirm(rctx []byte, renew bool, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	start, ok := l.starts[string(rctx)]
+	if !ok {
+		return
+	}
+	delete(l.starts, string(rctx))
+	if renew && !start.Before(l.notBefore) && start.Add(d).After(l.expire) {
+		l.expire = start.Add(d)
+	}
+}
+
+// revoke drops the lease, and refuses to renew it by the requests issued
+// before until.
+func (l *leaderLease) revoke(until time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.expire = time.Time{}
+	if until.After(l.notBefore) {
+		l.notBefore = until
+	}
+}
+
+func (l *leaderLease) valid(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return now.Before(l.expire)
+}
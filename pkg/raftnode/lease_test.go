@@ -0,0 +1,84 @@
+package raftnode
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// confirmRead issues a ReadIndex request on the node and waits for its read
+// state.
+func confirmRead(t *testing.T, tn *testNode, id uint64) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	rctx := make([]byte, 8)
+	binary.BigEndian.PutUint64(rctx, id)
+	if err := tn.ReadIndex(ctx, rctx); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		select {
+		case rs := <-tn.ReadStates():
+			if string(rs.RequestCtx) == string(rctx) {
+				return
+			}
+		case <-ctx.Done():
+			t.Fatal("timed out waiting for read state")
+		}
+	}
+}
+
+func TestLeaderLease(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	n1 := startSingleNode(t, t.TempDir())
+	if n1.HasLease() {
+		t.Fatal("lease should not be held before any confirmed read")
+	}
+	confirmRead(t, n1, 1)
+	if !n1.HasLease() {
+		t.Fatal("confirmed read should renew the lease")
+	}
+	time.Sleep(n1.cfg.leaseDuration())
+	if n1.HasLease() {
+		t.Fatal("lease should expire")
+	}
+
+	l2, url2 := listenLocal(t)
+	id2, err := n1.AddMember(ctx, url2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n2 := serveNode(t, JoinRaftNode(zap.NewNop(), testConfig(), id2, n1.Members(), t.TempDir()), l2)
+	waitFor(t, "new member to follow the leader", func() bool { return n2.Lead() == n1.ID() })
+	confirmRead(t, n2, 2)
+	if n2.HasLease() {
+		t.Fatal("follower should never hold the lease")
+	}
+	confirmRead(t, n1, 3)
+	if !n1.HasLease() {
+		t.Fatal("read confirmed by the quorum should renew the lease")
+	}
+
+	// The lease is revoked when the leadership is transferred, and the new
+	// leader holds it once it confirms a read.
+	if err := n1.TransferLeadership(ctx, id2); err != nil {
+		t.Fatal(err)
+	}
+	if n1.HasLease() || n2.HasLease() {
+		t.Fatal("lease should not be held right after the transfer")
+	}
+	waitFor(t, "old leader to follow the new one", func() bool { return n1.Lead() == id2 })
+	confirmRead(t, n2, 4)
+	if !n2.HasLease() {
+		t.Fatal("new leader should hold the lease after a confirmed read")
+	}
+	confirmRead(t, n1, 5)
+	if n1.HasLease() {
+		t.Fatal("old leader should not hold the lease")
+	}
+}
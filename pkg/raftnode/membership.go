@@ -0,0 +1,283 @@
+package raftnode
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"go.etcd.io/etcd/client/pkg/v3/fileutil"
+	"go.etcd.io/etcd/client/pkg/v3/types"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+	"go.uber.org/zap"
+
+	"github.com/gozssky/groupchat/pkg/metadata"
+)
+
+const membersFileName = "members.json"
+
+var (
+	ErrMemberExists    = errors.New("member already exists")
+	ErrMemberNotExists = errors.New("member not exists")
+	// ErrLeaderChanged and ErrConfChangeTimeout are returned if the conf
+	// change is not applied before the leader changes or the deadline, it
+	// may still be applied later.
+	ErrLeaderChanged     = errors.New("leader changed")
+	ErrConfChangeTimeout = errors.New("conf change timed out")
+)
+
+// confChangeTimeout bounds the wait for a proposed conf change to be applied,
+// which leaves time for a few elections.
+func (cfg *Config) confChangeTimeout() time.Duration {
+	return cfg.ElectionTimeout() * 5
+}
+
+// loadMembers overrides the peers and removed members of md with the ones
+// persisted in data dir, which reflect the latest applied membership changes.
+func loadMembers(lg *zap.Logger, dataDir string, md *metadata.Metadata) {
+	path := filepath.Join(dataDir, membersFileName)
+	if !fileutil.Exist(path) {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		lg.Fatal("failed to read members file", zap.String("path", path), zap.Error(err))
+	}
+	var members metadata.Metadata
+	members.MustUnmarshalJSON(data)
+	md.Peers = members.Peers
+	md.Removed = members.Removed
+}
+
+func saveMembers(lg *zap.Logger, dataDir string, md *metadata.Metadata) {
+	path := filepath.Join(dataDir, membersFileName)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, md.MustMarshalJSON(), 0644); err != nil {
+		lg.Fatal("failed to write members file", zap.String("path", tmpPath), zap.Error(err))
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		lg.Fatal("failed to rename members file", zap.String("path", path), zap.Error(err))
+	}
+}
+
+// Members returns the current members of the cluster sorted by id.
+func (rc *Node) Members() []metadata.Peer {
+	rc.membersMu.RLock()
+	defer rc.membersMu.RUnlock()
+	var peers []metadata.Peer
+	for id, url := range rc.members {
+		peers = append(peers, metadata.Peer{ID: id, URL: url})
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		return peers[i].ID < peers[j].ID
+	})
+	return peers
+}
+
+func (rc *Node) isIDRemoved(id uint64) bool {
+	rc.membersMu.RLock()
+	defer rc.membersMu.RUnlock()
+	_, ok := rc.removed[types.ID(id)]
+	return ok
+}
+
+func (rc *Node) applyConfChange(cc raftpb.ConfChange) {
+	rc.membersMu.Lock()
+	defer rc.membersMu.Unlock()
+	id := types.ID(cc.NodeID)
+	switch cc.Type {
+	case raftpb.ConfChangeAddNode, raftpb.ConfChangeAddLearnerNode:
+		// Conf changes proposed when bootstrapping the cluster carry no url,
+		// those members are already known from the metadata.
+		if len(cc.Context) == 0 {
+			return
+		}
+		url := string(cc.Context)
+		rc.members[id] = url
+		delete(rc.removed, id)
+		if id != rc.id {
+			rc.transport.AddPeer(id, []string{url})
+		}
+		rc.lg.Info("added member", zap.Stringer("member-id", id), zap.String("url", url))
+	case raftpb.ConfChangeRemoveNode:
+		if _, ok := rc.members[id]; !ok {
+			return
+		}
+		delete(rc.members, id)
+		rc.removed[id] = struct{}{}
+		if id == rc.id {
+			rc.lg.Warn("local member has been removed from the cluster")
+		} else {
+			rc.transport.RemovePeer(id)
+		}
+		rc.lg.Info("removed member", zap.Stringer("member-id", id))
+	default:
+		return
+	}
+	saveMembers(rc.lg, rc.dataDir, rc.membersLocked())
+}
+
+// membersLocked returns the members and removed members sorted by id, the
+// caller must hold membersMu.
+func (rc *Node) membersLocked() *metadata.Metadata {
+	md := &metadata.Metadata{ID: rc.id}
+	for id, url := range rc.members {
+		md.Peers = append(md.Peers, metadata.Peer{ID: id, URL: url})
+	}
+	sort.Slice(md.Peers, func(i, j int) bool {
+		return md.Peers[i].ID < md.Peers[j].ID
+	})
+	for id := range rc.removed {
+		md.Removed = append(md.Removed, id)
+	}
+	sort.Slice(md.Removed, func(i, j int) bool {
+		return md.Removed[i] < md.Removed[j]
+	})
+	return md
+}
+
+// resetMembers replaces the members with the ones recovered from a snapshot,
+// which include the members added by conf changes compacted into it, and
+// adds and removes the peers of the transport accordingly.
+func (rc *Node) resetMembers(md *metadata.Metadata) {
+	rc.membersMu.Lock()
+	defer rc.membersMu.Unlock()
+	members := make(map[types.ID]string)
+	for _, peer := range md.Peers {
+		members[peer.ID] = peer.URL
+	}
+	for id, url := range members {
+		if id == rc.id {
+			continue
+		}
+		if old, ok := rc.members[id]; !ok {
+			rc.transport.AddPeer(id, []string{url})
+			rc.lg.Info("added member from snapshot", zap.Stringer("member-id", id), zap.String("url", url))
+		} else if old != url {
+			rc.transport.UpdatePeer(id, []string{url})
+		}
+	}
+	for id := range rc.members {
+		if _, ok := members[id]; !ok && id != rc.id {
+			rc.transport.RemovePeer(id)
+			rc.lg.Info("removed member from snapshot", zap.Stringer("member-id", id))
+		}
+	}
+	rc.members = members
+	rc.removed = make(map[types.ID]struct{})
+	for _, id := range md.Removed {
+		rc.removed[id] = struct{}{}
+	}
+	saveMembers(rc.lg, rc.dataDir, rc.membersLocked())
+}
+
+// membersOfConfState returns the current members which are voters or
+// learners of the conf state, the others are removed. It recovers the
+// members from snapshots of old versions, which don't carry them.
+func (rc *Node) membersOfConfState(cs raftpb.ConfState) *metadata.Metadata {
+	rc.membersMu.RLock()
+	md := rc.membersLocked()
+	rc.membersMu.RUnlock()
+	ids := make(map[types.ID]struct{})
+	for _, id := range cs.Voters {
+		ids[types.ID(id)] = struct{}{}
+	}
+	for _, id := range cs.Learners {
+		ids[types.ID(id)] = struct{}{}
+	}
+	var peers []metadata.Peer
+	for _, peer := range md.Peers {
+		if _, ok := ids[peer.ID]; ok {
+			peers = append(peers, peer)
+		} else {
+			md.Removed = append(md.Removed, peer.ID)
+		}
+	}
+	md.Peers = peers
+	sort.Slice(md.Removed, func(i, j int) bool {
+		return md.Removed[i] < md.Removed[j]
+	})
+	return md
+}
+
+// proposeConfChange proposes the conf change and waits until it is applied.
+// It fails if the leader changes or the conf change is not applied within
+// confChangeTimeout, e.g. after the quorum is lost.
+func (rc *Node) proposeConfChange(ctx context.Context, cc raftpb.ConfChange) error {
+	lead := rc.Lead()
+	if lead == 0 {
+		return ErrNoLeader
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, rc.cfg.confChangeTimeout())
+	defer cancel()
+	cc.ID = rc.reqIDGen.Next()
+	notify := rc.confWait.Register(cc.ID)
+	defer rc.confWait.Trigger(cc.ID, nil)
+	if err := rc.node.ProposeConfChange(waitCtx, cc); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(rc.cfg.TickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-notify:
+			return nil
+		case <-ticker.C:
+			if rc.Lead() != lead {
+				return ErrLeaderChanged
+			}
+		case <-waitCtx.Done():
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return ErrConfChangeTimeout
+		}
+	}
+}
+
+// AddMember adds a new member with the given url to the cluster and returns
+// the id assigned to it. The new member must then be started with JoinRaftNode.
+// Ids of removed members are never reused, since the transport rejects
+// messages from them.
+func (rc *Node) AddMember(ctx context.Context, url string) (types.ID, error) {
+	rc.membersMu.RLock()
+	var id types.ID
+	for mid, murl := range rc.members {
+		if murl == url {
+			rc.membersMu.RUnlock()
+			return 0, ErrMemberExists
+		}
+		if mid > id {
+			id = mid
+		}
+	}
+	for mid := range rc.removed {
+		if mid > id {
+			id = mid
+		}
+	}
+	rc.membersMu.RUnlock()
+	id++
+	err := rc.proposeConfChange(ctx, raftpb.ConfChange{
+		Type:    raftpb.ConfChangeAddNode,
+		NodeID:  uint64(id),
+		Context: []byte(url),
+	})
+	return id, err
+}
+
+// RemoveMember removes the member with the given id from the cluster.
+func (rc *Node) RemoveMember(ctx context.Context, id types.ID) error {
+	rc.membersMu.RLock()
+	_, ok := rc.members[id]
+	rc.membersMu.RUnlock()
+	if !ok {
+		return ErrMemberNotExists
+	}
+	return rc.proposeConfChange(ctx, raftpb.ConfChange{
+		Type:   raftpb.ConfChangeRemoveNode,
+		NodeID: uint64(id),
+	})
+}
@@ -2,15 +2,18 @@ package raftnode
 
 import (
 	"context"
-	"go.uber.org/atomic"
+	"errors"
 	"net/http"
 	"path/filepath"
 	"sort"
 	"strconv"
+	"sync"
 	"time"
 
 	"go.etcd.io/etcd/client/pkg/v3/types"
+	"go.etcd.io/etcd/pkg/v3/idutil"
 	"go.etcd.io/etcd/pkg/v3/pbutil"
+	"go.etcd.io/etcd/pkg/v3/wait"
 	"go.etcd.io/etcd/raft/v3"
 	"go.etcd.io/etcd/raft/v3/raftpb"
 	"go.etcd.io/etcd/server/v3/etcdserver/api/rafthttp"
@@ -18,47 +21,159 @@ import (
 	stats "go.etcd.io/etcd/server/v3/etcdserver/api/v2stats"
 	"go.etcd.io/etcd/server/v3/wal"
 	"go.etcd.io/etcd/server/v3/wal/walpb"
+	"go.uber.org/atomic"
 	"go.uber.org/zap"
 
 	"github.com/gozssky/groupchat/pkg/metadata"
 )
 
+// snapshotCatchUpEntries is the number of entries kept in memory after a
+// snapshot is created, so that slow followers can catch up without it. It is
+// a variable so that tests can make followers catch up from snapshots.
+var snapshotCatchUpEntries uint64 = 10000
+
+const (
+	// readStateBufferSize is the buffer size of read states channel, which
+	// absorbs bursts of read states of overlapping ReadIndex requests.
+	readStateBufferSize = 64
+)
+
+var ErrNoLeader = errors.New("no leader")
+
 type ApplyTask struct {
 	Snapshot raftpb.Snapshot
-	Entries  []raftpb.Entry
+	// Entries are the committed entries to apply. Conf changes are applied
+	// by the node, their entries are passed without data so that the applied
+	// index still advances over them.
+	Entries []raftpb.Entry
 }
 
 type Node struct {
 	lg          *zap.Logger
+	cfg         Config
 	id          types.ID
+	dataDir     string
 	lead        atomic.Uint64
 	node        raft.Node
 	storage     *raft.MemoryStorage
 	wal         *wal.WAL
 	snapshotter *snap.Snapshotter
 	transport   rafthttp.Transporter
+	reqIDGen    *idutil.Generator
+	confWait    wait.Wait
+	lease       *leaderLease
+
+	membersMu sync.RWMutex
+	members   map[types.ID]string
+	removed   map[types.ID]struct{}
+
+	// initSnap is only accessed by serveRaft.
+	initSnap *raftpb.Snapshot
+	// snapMu guards the following fields, which are modified by serveRaft
+	// and read when snapshots are sent.
+	snapMu      sync.Mutex
+	snapConf    raftpb.ConfState
+	snapMembers *metadata.Metadata
+	confStates  []confStateAt
+	snapSource  SnapshotSource
 
 	applyTaskC chan ApplyTask
 	readStateC chan raft.ReadState
+	snapshotC  chan snapshotRequest
+	stopC      chan struct{}
+	doneC      chan struct{}
+}
+
+type confStateAt struct {
+	index     uint64
+	confState raftpb.ConfState
+	// members are the members after the conf change is applied.
+	members *metadata.Metadata
+}
+
+type snapshotRequest struct {
+	index uint64
+	data  []byte
+	resC  chan snapshotResult
+}
+
+type snapshotResult struct {
+	snap raftpb.Snapshot
+	err  error
 }
 
-func newRaftConfig(lg *zap.Logger, id uint64, storage *raft.MemoryStorage) *raft.Config {
+func newRaftConfig(lg *zap.Logger, cfg Config, id uint64, storage *raft.MemoryStorage) *raft.Config {
+	readOnlyOption, err := cfg.readOnlyOption()
+	if err != nil {
+		lg.Fatal("invalid raft config", zap.Error(err))
+	}
 	return &raft.Config{
 		ID:                        id,
-		ElectionTick:              10,
-		HeartbeatTick:             1,
+		ElectionTick:              cfg.ElectionTick,
+		HeartbeatTick:             cfg.HeartbeatTick,
 		Storage:                   storage,
-		MaxSizePerMsg:             1024 * 1024,
-		MaxInflightMsgs:           256,
-		MaxUncommittedEntriesSize: 1 << 30,
+		MaxSizePerMsg:             cfg.MaxSizePerMsg,
+		MaxInflightMsgs:           cfg.MaxInflightMsgs,
+		MaxUncommittedEntriesSize: cfg.MaxUncommittedEntriesSize,
 		PreVote:                   true,
 		CheckQuorum:               true,
-		ReadOnlyOption:            raft.ReadOnlyLeaseBased,
+		ReadOnlyOption:            readOnlyOption,
 		Logger:                    newRaftLogger(lg),
 	}
 }
 
-func NewRaftNode(lg *zap.Logger, localURL string, remoteURLs []string, dataDir string) *Node {
+func newNode(lg *zap.Logger, cfg Config, md *metadata.Metadata, dataDir string, node raft.Node, storage *raft.MemoryStorage,
+	w *wal.WAL, snapshotter *snap.Snapshotter, applyTaskC chan ApplyTask) *Node {
+	rc := &Node{
+		lg:          lg,
+		cfg:         cfg,
+		id:          md.ID,
+		dataDir:     dataDir,
+		node:        node,
+		storage:     storage,
+		wal:         w,
+		snapshotter: snapshotter,
+		reqIDGen:    idutil.NewGenerator(uint16(md.ID), time.Now()),
+		confWait:    wait.New(),
+		lease:       newLeaderLease(),
+		members:     make(map[types.ID]string),
+		removed:     make(map[types.ID]struct{}),
+		applyTaskC:  applyTaskC,
+		readStateC:  make(chan raft.ReadState, readStateBufferSize),
+		snapshotC:   make(chan snapshotRequest),
+		stopC:       make(chan struct{}),
+		doneC:       make(chan struct{}),
+	}
+	idStr := strconv.Itoa(int(md.ID))
+	transport := &rafthttp.Transport{
+		Logger:      lg,
+		ID:          md.ID,
+		ClusterID:   types.ID(cfg.ClusterID),
+		Raft:        httpRaft{Node: node, isIDRemoved: rc.isIDRemoved},
+		Snapshotter: snapshotter,
+		ServerStats: stats.NewServerStats(idStr, idStr),
+		LeaderStats: stats.NewLeaderStats(lg, idStr),
+		ErrorC:      make(chan error),
+	}
+	if err := transport.Start(); err != nil {
+		lg.Fatal("failed to start transport", zap.Error(err))
+	}
+	for _, peer := range md.Peers {
+		rc.members[peer.ID] = peer.URL
+		if peer.ID != md.ID {
+			transport.AddPeer(peer.ID, []string{peer.URL})
+		}
+	}
+	for _, id := range md.Removed {
+		rc.removed[id] = struct{}{}
+	}
+	rc.transport = transport
+	rc.snapMembers = rc.membersLocked()
+	rc.purgeFiles()
+	return rc
+}
+
+func NewRaftNode(lg *zap.Logger, cfg Config, localURL string, remoteURLs []string, dataDir string) *Node {
 	snapDir := filepath.Join(dataDir, "snap")
 	walDir := filepath.Join(dataDir, "wal")
 	ensureEmptyDir(lg, snapDir)
@@ -74,7 +189,7 @@ func NewRaftNode(lg *zap.Logger, localURL string, remoteURLs []string, dataDir s
 	for i := 1; i <= len(peerURLs); i++ {
 		raftPeers = append(raftPeers, raft.Peer{ID: uint64(i)})
 	}
-	raftCfg := newRaftConfig(lg, uint64(id), storage)
+	raftCfg := newRaftConfig(lg, cfg, uint64(id), storage)
 	node := raft.StartNode(raftCfg, raftPeers)
 
 	md := &metadata.Metadata{ID: id}
@@ -85,41 +200,47 @@ func NewRaftNode(lg *zap.Logger, localURL string, remoteURLs []string, dataDir s
 	if err != nil {
 		lg.Fatal("failed to create wal", zap.Error(err))
 	}
+	saveMembers(lg, dataDir, md)
 	snapshotter := snap.New(lg, snapDir)
 
-	rc := &Node{
-		lg:          lg,
-		id:          id,
-		node:        node,
-		storage:     storage,
-		wal:         w,
-		snapshotter: snapshotter,
-		applyTaskC:  make(chan ApplyTask, 3),
-		readStateC:  make(chan raft.ReadState, 3),
-	}
-	transport := &rafthttp.Transport{
-		Logger:      lg,
-		ID:          id,
-		ClusterID:   0x1000,
-		Raft:        httpRaft{Node: node},
-		ServerStats: stats.NewServerStats("", ""),
-		LeaderStats: stats.NewLeaderStats(zap.NewExample(), strconv.Itoa(int(id))),
-		ErrorC:      make(chan error),
-	}
-	if err := transport.Start(); err != nil {
-		lg.Fatal("failed to start transport", zap.Error(err))
-	}
-	for i, url := range peerURLs {
-		if i+1 != int(id) {
-			transport.AddPeer(types.ID(i+1), []string{url})
-		}
+	rc := newNode(lg, cfg, md, dataDir, node, storage, w, snapshotter,
+		make(chan ApplyTask, 3))
+	go rc.serveRaft()
+	return rc
+}
+
+// JoinRaftNode starts a new node which joins an existing cluster. The node
+// must have been added to the cluster by an existing member before.
+func JoinRaftNode(lg *zap.Logger, cfg Config, id types.ID, peers []metadata.Peer, dataDir string) *Node {
+	snapDir := filepath.Join(dataDir, "snap")
+	walDir := filepath.Join(dataDir, "wal")
+	ensureEmptyDir(lg, snapDir)
+	ensureEmptyDir(lg, walDir)
+
+	md := &metadata.Metadata{ID: id, Peers: peers}
+	w, err := wal.Create(lg, walDir, md.MustMarshalJSON())
+	if err != nil {
+		lg.Fatal("failed to create wal", zap.Error(err))
 	}
-	rc.transport = transport
+	saveMembers(lg, dataDir, md)
+	snapshotter := snap.New(lg, snapDir)
+
+	storage := raft.NewMemoryStorage()
+	raftCfg := newRaftConfig(lg, cfg, uint64(id), storage)
+	node := raft.RestartNode(raftCfg)
+
+	rc := newNode(lg, cfg, md, dataDir, node, storage, w, snapshotter,
+		make(chan ApplyTask, 3))
 	go rc.serveRaft()
 	return rc
 }
 
-func RestartRaftNode(lg *zap.Logger, dataDir string) (*Node, bool) {
+// RestartRaftNode restarts the node from the existing data dir. If
+// forceNewCluster is set, the node discards its uncommitted entries and
+// removes all other members, so that it can form a new single member cluster
+// with all committed data after a permanent quorum loss. It is done only once
+// per data dir, later restarts with the flag ignore it with a warning.
+func RestartRaftNode(lg *zap.Logger, cfg Config, dataDir string, forceNewCluster bool) (*Node, bool) {
 	snapDir := filepath.Join(dataDir, "snap")
 	walDir := filepath.Join(dataDir, "wal")
 	ensureDir(lg, snapDir)
@@ -159,6 +280,23 @@ func RestartRaftNode(lg *zap.Logger, dataDir string) (*Node, bool) {
 	}
 	var md metadata.Metadata
 	md.MustUnmarshalJSON(rawMetadata)
+	if forceNewCluster {
+		if index, forced := readForcedMarker(lg, dataDir); forced {
+			lg.Warn("IGNORING --force-new-cluster: the member has already been forced into a new cluster, "+
+				"remove the flag to stop this warning, or the marker file to force a new cluster again",
+				zap.Uint64("forced-index", index),
+				zap.String("marker", filepath.Join(dataDir, forcedMarkerFileName)))
+		} else {
+			var localURL string
+			for _, peer := range md.Peers {
+				if peer.ID == md.ID {
+					localURL = peer.URL
+				}
+			}
+			ents = forceNewClusterEntries(lg, w, md.ID, localURL, raftSnap, &st, ents)
+			writeForcedMarker(lg, dataDir, st.Commit)
+		}
+	}
 	storage := raft.NewMemoryStorage()
 	if raftSnap != nil {
 		storage.ApplySnapshot(*raftSnap)
@@ -172,39 +310,26 @@ func RestartRaftNode(lg *zap.Logger, dataDir string) (*Node, bool) {
 	if int(lastIndex) < len(md.Peers) {
 		return nil, false
 	}
+	loadMembers(lg, dataDir, &md)
 
-	raftCfg := newRaftConfig(lg, uint64(md.ID), storage)
+	raftCfg := newRaftConfig(lg, cfg, uint64(md.ID), storage)
 	node := raft.RestartNode(raftCfg)
 
-	rc := &Node{
-		lg:          lg,
-		id:          md.ID,
-		node:        node,
-		storage:     storage,
-		wal:         w,
-		snapshotter: snapshotter,
-		applyTaskC:  make(chan ApplyTask),
-		readStateC:  make(chan raft.ReadState, 1),
-	}
-	idStr := strconv.Itoa(int(md.ID))
-	transport := &rafthttp.Transport{
-		Logger:      lg,
-		ID:          md.ID,
-		ClusterID:   0x1000,
-		Raft:        httpRaft{Node: node},
-		ServerStats: stats.NewServerStats(idStr, idStr),
-		LeaderStats: stats.NewLeaderStats(lg, idStr),
-		ErrorC:      make(chan error),
-	}
-	if err := transport.Start(); err != nil {
-		lg.Fatal("failed to start transport", zap.Error(err))
-	}
-	for _, peer := range md.Peers {
-		if peer.ID != md.ID {
-			transport.AddPeer(peer.ID, []string{peer.URL})
+	rc := newNode(lg, cfg, &md, dataDir, node, storage, w, snapshotter,
+		make(chan ApplyTask))
+	if raftSnap != nil {
+		members, data, err := DecodeSnapshotData(raftSnap.Data)
+		if err != nil {
+			lg.Fatal("failed to decode raft snapshot", zap.Error(err))
+		}
+		initSnap := *raftSnap
+		initSnap.Data = data
+		rc.initSnap = &initSnap
+		rc.snapConf = raftSnap.Metadata.ConfState
+		if members != nil {
+			rc.snapMembers = members
 		}
 	}
-	rc.transport = transport
 	go rc.serveRaft()
 	return rc, true
 }
@@ -217,6 +342,25 @@ func (rc *Node) IsLead() bool {
 	return rc.lead.Load() == uint64(rc.id)
 }
 
+func (rc *Node) Lead() types.ID {
+	return types.ID(rc.lead.Load())
+}
+
+func (rc *Node) Status() raft.Status {
+	return rc.node.Status()
+}
+
+// Snapshot returns the latest snapshot of the node.
+func (rc *Node) Snapshot() (raftpb.Snapshot, error) {
+	return rc.storage.Snapshot()
+}
+
+// Term returns the term of the entry at the given index, it fails if the
+// entry has been compacted.
+func (rc *Node) Term(index uint64) (uint64, error) {
+	return rc.storage.Term(index)
+}
+
 func (rc *Node) Handler() http.Handler {
 	return rc.transport.Handler()
 }
@@ -236,56 +380,155 @@ func (rc *Node) saveSnap(snap raftpb.Snapshot) error {
 	return rc.wal.ReleaseLockTo(snap.Metadata.Index)
 }
 
+// confStateAt returns the conf state and the members which were in effect at
+// the given index.
+func (rc *Node) confStateAt(index uint64) (raftpb.ConfState, *metadata.Metadata) {
+	rc.snapMu.Lock()
+	defer rc.snapMu.Unlock()
+	cs, members := rc.snapConf, rc.snapMembers
+	for _, c := range rc.confStates {
+		if c.index > index {
+			break
+		}
+		cs, members = c.confState, c.members
+	}
+	return cs, members
+}
+
+func (rc *Node) resetConfStates(snap raftpb.Snapshot, members *metadata.Metadata) {
+	rc.snapMu.Lock()
+	defer rc.snapMu.Unlock()
+	rc.snapConf = snap.Metadata.ConfState
+	rc.snapMembers = members
+	j := 0
+	for _, c := range rc.confStates {
+		if c.index > snap.Metadata.Index {
+			rc.confStates[j] = c
+			j++
+		}
+	}
+	rc.confStates = rc.confStates[:j]
+}
+
+func (rc *Node) createSnapshot(index uint64, data []byte) (raftpb.Snapshot, error) {
+	cs, members := rc.confStateAt(index)
+	snap, err := rc.storage.CreateSnapshot(index, &cs, encodeSnapshotData(members, data))
+	if err != nil {
+		return raftpb.Snapshot{}, err
+	}
+	if err := rc.saveSnap(snap); err != nil {
+		rc.lg.Fatal("failed to save snapshot", zap.Error(err))
+	}
+	rc.resetConfStates(snap, members)
+	if index > snapshotCatchUpEntries {
+		compactIndex := index - snapshotCatchUpEntries
+		if err := rc.storage.Compact(compactIndex); err != nil && err != raft.ErrCompacted {
+			rc.lg.Fatal("failed to compact raft log", zap.Error(err))
+		}
+	}
+	rc.lg.Info("created snapshot", zap.Uint64("index", index), zap.Uint64("term", snap.Metadata.Term))
+	return snap, nil
+}
+
 func (rc *Node) serveRaft() {
-	ticker := time.NewTicker(time.Millisecond * 100)
+	defer close(rc.doneC)
+	ticker := time.NewTicker(rc.cfg.TickInterval)
 	defer ticker.Stop()
 
+	if rc.initSnap != nil {
+		rc.applyTaskC <- ApplyTask{Snapshot: *rc.initSnap}
+		rc.initSnap = nil
+	}
 	for {
 		select {
+		case <-rc.stopC:
+			return
 		case <-ticker.C:
 			rc.node.Tick()
+		case req := <-rc.snapshotC:
+			snap, err := rc.createSnapshot(req.index, req.data)
+			req.resC <- snapshotResult{snap: snap, err: err}
 		case rd := <-rc.node.Ready():
 			if rd.SoftState != nil {
+				if rd.SoftState.Lead != rc.lead.Load() {
+					rc.lease.revoke(time.Now())
+				}
 				rc.lead.Store(rd.SoftState.Lead)
 			}
-			if len(rd.ReadStates) != 0 {
-				rc.readStateC <- rd.ReadStates[len(rd.ReadStates)-1]
+			// Every read state must be delivered, otherwise the ReadIndex
+			// request waiting for it would time out. Read states confirmed
+			// by a quorum renew the lease of the leader, which they don't in
+			// the lease-based mode.
+			renew := rc.IsLead() && rc.cfg.ReadOnlyOption == ReadOnlySafe
+			for _, rs := range rd.ReadStates {
+				rc.lease.confirm(rs.RequestCtx, renew, rc.cfg.leaseDuration())
+				rc.readStateC <- rs
+			}
+			// Entries must be durable before they are applied, since the
+			// storage backend may persist the applied index, which must
+			// never be ahead of the wal.
+			task := ApplyTask{}
+			if !raft.IsEmptySnap(rd.Snapshot) {
+				members, data, err := DecodeSnapshotData(rd.Snapshot.Data)
+				if err != nil {
+					rc.lg.Fatal("failed to decode snapshot", zap.Error(err))
+				}
+				if members == nil {
+					members = rc.membersOfConfState(rd.Snapshot.Metadata.ConfState)
+				}
+				if err := rc.saveSnap(rd.Snapshot); err != nil {
+					rc.lg.Fatal("failed to save snapshot", zap.Error(err))
+				}
+				rc.storage.ApplySnapshot(rd.Snapshot)
+				rc.resetMembers(members)
+				rc.resetConfStates(rd.Snapshot, members)
+				task.Snapshot = rd.Snapshot
+				task.Snapshot.Data = data
+			}
+			if err := rc.wal.Save(rd.HardState, rd.Entries); err != nil {
+				rc.lg.Fatal("failed to save raft entries", zap.Error(err))
 			}
-			task := ApplyTask{Snapshot: rd.Snapshot}
-			sawNormal := false
+			rc.storage.Append(rd.Entries)
 			for _, entry := range rd.CommittedEntries {
 				switch entry.Type {
 				case raftpb.EntryNormal:
 					task.Entries = append(task.Entries, entry)
-					sawNormal = true
 				case raftpb.EntryConfChange:
-					if sawNormal {
-						rc.lg.Fatal("unexpected ConfChange entry")
-					}
 					var cc raftpb.ConfChange
 					pbutil.MustUnmarshal(&cc, entry.Data)
-					rc.node.ApplyConfChange(cc)
+					cs := rc.node.ApplyConfChange(cc)
+					rc.applyConfChange(cc)
+					rc.membersMu.RLock()
+					members := rc.membersLocked()
+					rc.membersMu.RUnlock()
+					rc.snapMu.Lock()
+					rc.confStates = append(rc.confStates, confStateAt{index: entry.Index, confState: *cs, members: members})
+					rc.snapMu.Unlock()
+					rc.confWait.Trigger(cc.ID, nil)
+					task.Entries = append(task.Entries, raftpb.Entry{Term: entry.Term, Index: entry.Index, Type: entry.Type})
 				default:
 					rc.lg.Fatal("unknown raft entry type", zap.Stringer("type", entry.Type))
 				}
 			}
 			rc.applyTaskC <- task
-			if !raft.IsEmptySnap(rd.Snapshot) {
-				if err := rc.saveSnap(rd.Snapshot); err != nil {
-					rc.lg.Fatal("failed to save snapshot", zap.Error(err))
-				}
-				rc.storage.ApplySnapshot(rd.Snapshot)
-			}
-			if err := rc.wal.Save(rd.HardState, rd.Entries); err != nil {
-				rc.lg.Fatal("failed to save raft entries", zap.Error(err))
-			}
-			rc.storage.Append(rd.Entries)
-			rc.transport.Send(rd.Messages)
+			rc.send(rd.Messages)
 			rc.node.Advance()
 		}
 	}
 }
 
+// Stop stops the node and closes its wal. The apply tasks must keep being
+// received until it returns.
+func (rc *Node) Stop() {
+	close(rc.stopC)
+	<-rc.doneC
+	rc.node.Stop()
+	rc.transport.Stop()
+	if err := rc.wal.Close(); err != nil {
+		rc.lg.Warn("failed to close wal", zap.Error(err))
+	}
+}
+
 func (rc *Node) ApplyTasks() <-chan ApplyTask {
 	return rc.applyTaskC
 }
@@ -295,9 +538,60 @@ func (rc *Node) ReadStates() <-chan raft.ReadState {
 }
 
 func (rc *Node) ReadIndex(ctx context.Context, rctx []byte) error {
+	rc.lease.issue(rctx, time.Now(), rc.cfg.leaseDuration())
 	return rc.node.ReadIndex(ctx, rctx)
 }
 
+// HasLease returns whether the local member is the leader and holds the
+// leader lease, so that it can serve reads without confirming them with a
+// quorum. It assumes the clock drift between members is bounded.
+func (rc *Node) HasLease() bool {
+	return rc.IsLead() && rc.lease.valid(time.Now())
+}
+
 func (rc *Node) Propose(ctx context.Context, data []byte) error {
 	return rc.node.Propose(ctx, data)
 }
+
+// CreateSnapshot creates a snapshot at the given applied index, then compacts
+// the raft log. The data of the state machine is nil if it is persisted by
+// the storage backend, or saved by SaveSnapshotDB.
+func (rc *Node) CreateSnapshot(ctx context.Context, index uint64, data []byte) (raftpb.Snapshot, error) {
+	req := snapshotRequest{index: index, data: data, resC: make(chan snapshotResult, 1)}
+	select {
+	case rc.snapshotC <- req:
+	case <-ctx.Done():
+		return raftpb.Snapshot{}, ctx.Err()
+	}
+	res := <-req.resC
+	return res.snap, res.err
+}
+
+// TransferLeadership transfers the leadership to the given member and waits
+// until the transfer is done.
+func (rc *Node) TransferLeadership(ctx context.Context, transferee types.ID) error {
+	lead := rc.Lead()
+	if lead == 0 {
+		return ErrNoLeader
+	}
+	rc.membersMu.RLock()
+	_, ok := rc.members[transferee]
+	rc.membersMu.RUnlock()
+	if !ok {
+		return ErrMemberNotExists
+	}
+	// The transferee campaigns regardless of the lease, which is not renewed
+	// until the transfer is surely done or aborted.
+	rc.lease.revoke(time.Now().Add(rc.cfg.ElectionTimeout() * 2))
+	rc.node.TransferLeadership(ctx, uint64(lead), uint64(transferee))
+	ticker := time.NewTicker(rc.cfg.TickInterval)
+	defer ticker.Stop()
+	for rc.Lead() != transferee {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,363 @@
+package raftnode
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/client/pkg/v3/types"
+	"go.etcd.io/etcd/raft/v3"
+	"go.etcd.io/etcd/server/v3/etcdserver/api/snap"
+	"go.uber.org/atomic"
+	"go.uber.org/zap"
+
+	"github.com/gozssky/groupchat/pkg/metadata"
+)
+
+func testConfig() Config {
+	cfg := DefaultConfig()
+	cfg.TickInterval = time.Millisecond * 10
+	return cfg
+}
+
+// listenLocal listens on a random local port for a node which is created
+// later, its peers may connect to the listener before the node is served.
+func listenLocal(t *testing.T) (net.Listener, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l, "http://" + l.Addr().String()
+}
+
+type testNode struct {
+	*Node
+	applied  atomic.Uint64
+	tasksC   chan ApplyTask
+	stopOnce sync.Once
+	stop     func()
+}
+
+// serveNode serves raft messages of the node on the listener and receives
+// its apply tasks until the node is stopped, which is at the latest when the
+// test ends.
+func serveNode(t *testing.T, rc *Node, l net.Listener) *testNode {
+	tn := &testNode{Node: rc, tasksC: make(chan ApplyTask, 1024)}
+	stopC := make(chan struct{})
+	doneC := make(chan struct{})
+	go func() {
+		defer close(doneC)
+		for {
+			select {
+			case task := <-rc.ApplyTasks():
+				if task.Snapshot.Metadata.Index > tn.applied.Load() {
+					tn.applied.Store(task.Snapshot.Metadata.Index)
+				}
+				if n := len(task.Entries); n > 0 {
+					tn.applied.Store(task.Entries[n-1].Index)
+				}
+				select {
+				case tn.tasksC <- task:
+				default:
+				}
+			case <-stopC:
+				return
+			}
+		}
+	}()
+	srv := &http.Server{Handler: rc.Handler()}
+	go srv.Serve(l)
+	stop := func() {
+		srv.Close()
+		// Apply tasks are received until the node is stopped.
+		rc.Stop()
+		close(stopC)
+		<-doneC
+	}
+	tn.stop = func() { tn.stopOnce.Do(stop) }
+	t.Cleanup(tn.stop)
+	return tn
+}
+
+// nextTask returns the next apply task received from the node.
+func (tn *testNode) nextTask(t *testing.T) ApplyTask {
+	t.Helper()
+	select {
+	case task := <-tn.tasksC:
+		return task
+	case <-time.After(time.Second * 10):
+		t.Fatal("timed out waiting for apply task")
+		return ApplyTask{}
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 10)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+// startSingleNode starts a new one-member cluster and waits until the member
+// becomes the leader.
+func startSingleNode(t *testing.T, dataDir string) *testNode {
+	l, url := listenLocal(t)
+	rc := serveNode(t, NewRaftNode(zap.NewNop(), testConfig(), url, nil, dataDir), l)
+	waitFor(t, "leader", rc.IsLead)
+	return rc
+}
+
+func memberIDsOf(peers []metadata.Peer) []types.ID {
+	var ids []types.ID
+	for _, peer := range peers {
+		ids = append(ids, peer.ID)
+	}
+	return ids
+}
+
+func TestMembership(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	dataDir := t.TempDir()
+	n1 := startSingleNode(t, dataDir)
+
+	l2, url2 := listenLocal(t)
+	id2, err := n1.AddMember(ctx, url2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id2 != 2 {
+		t.Fatalf("expected member id 2, got %d", id2)
+	}
+	n2 := serveNode(t, JoinRaftNode(zap.NewNop(), testConfig(), id2, n1.Members(), t.TempDir()), l2)
+	waitFor(t, "new member to follow the leader", func() bool { return n2.Lead() == n1.ID() })
+	// The last committed entry is the conf change adding the member, the
+	// applied index must advance over it.
+	waitFor(t, "conf change to be applied", func() bool {
+		commit := n2.Status().Commit
+		return commit > 0 && n2.applied.Load() == commit
+	})
+
+	if _, err := n1.AddMember(ctx, url2); err != ErrMemberExists {
+		t.Fatalf("expected ErrMemberExists, got %v", err)
+	}
+	if err := n1.RemoveMember(ctx, 3); err != ErrMemberNotExists {
+		t.Fatalf("expected ErrMemberNotExists, got %v", err)
+	}
+	if err := n1.RemoveMember(ctx, id2); err != nil {
+		t.Fatal(err)
+	}
+	if ids := memberIDsOf(n1.Members()); len(ids) != 1 || ids[0] != n1.ID() {
+		t.Fatalf("unexpected members %v after removal", ids)
+	}
+
+	// The id of the removed member must not be reused.
+	l3, url3 := listenLocal(t)
+	l3.Close()
+	id3, err := n1.AddMember(ctx, url3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id3 != 3 {
+		t.Fatalf("expected member id 3, got %d", id3)
+	}
+
+	md := &metadata.Metadata{}
+	loadMembers(zap.NewNop(), dataDir, md)
+	if ids := memberIDsOf(md.Peers); len(ids) != 2 || ids[0] != 1 || ids[1] != 3 {
+		t.Fatalf("unexpected persisted members %v", ids)
+	}
+	if len(md.Removed) != 1 || md.Removed[0] != id2 {
+		t.Fatalf("unexpected persisted removed members %v", md.Removed)
+	}
+}
+
+// TestSnapshotMembers checks that a member which catches up from a snapshot
+// learns the members added by the conf changes compacted into it.
+func TestSnapshotMembers(t *testing.T) {
+	defer func(entries uint64) { snapshotCatchUpEntries = entries }(snapshotCatchUpEntries)
+	snapshotCatchUpEntries = 0
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	n1 := startSingleNode(t, t.TempDir())
+	l2, url2 := listenLocal(t)
+	id2, err := n1.AddMember(ctx, url2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n2 := serveNode(t, JoinRaftNode(zap.NewNop(), testConfig(), id2, n1.Members(), t.TempDir()), l2)
+	waitFor(t, "new member to follow the leader", func() bool { return n2.Lead() == n1.ID() })
+
+	// The third member only knows the leader, it learns the second member
+	// from the snapshot sent to it. Raft only restores snapshots whose conf
+	// state contains the local member, so the snapshot is created after it
+	// is added.
+	l3, url3 := listenLocal(t)
+	id3, err := n1.AddMember(ctx, url3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "conf change to be applied", func() bool { return n1.applied.Load() == n1.Status().Commit })
+	index := n1.applied.Load()
+	if _, err := n1.CreateSnapshot(ctx, index, []byte("state")); err != nil {
+		t.Fatal(err)
+	}
+	peers := []metadata.Peer{{ID: n1.ID(), URL: n1.Members()[0].URL}, {ID: id3, URL: url3}}
+	n3 := serveNode(t, JoinRaftNode(zap.NewNop(), testConfig(), id3, peers, t.TempDir()), l3)
+	task := n3.nextTask(t)
+	for raft.IsEmptySnap(task.Snapshot) {
+		task = n3.nextTask(t)
+	}
+	if task.Snapshot.Metadata.Index < index || string(task.Snapshot.Data) != "state" {
+		t.Fatalf("expected snapshot with the state machine data, got %d %q",
+			task.Snapshot.Metadata.Index, task.Snapshot.Data)
+	}
+	waitFor(t, "members of the snapshot", func() bool {
+		ids := memberIDsOf(n3.Members())
+		return len(ids) == 3 && ids[0] == n1.ID() && ids[1] == id2 && ids[2] == id3
+	})
+
+	// The remaining members can only elect a leader if they reach each
+	// other.
+	n1.stop()
+	waitFor(t, "new leader", func() bool {
+		lead := n3.Lead()
+		return (lead == id2 || lead == id3) && n2.Lead() == lead
+	})
+}
+
+type testSnapshotSource struct {
+	tn *testNode
+}
+
+func (s testSnapshotSource) OpenSnapshot() (io.ReadCloser, int64, uint64, error) {
+	return io.NopCloser(strings.NewReader("db")), 2, s.tn.applied.Load(), nil
+}
+
+// TestStreamSnapshot checks that snapshots are streamed from the snapshot
+// source to the followers at the index of the source.
+func TestStreamSnapshot(t *testing.T) {
+	defer func(entries uint64) { snapshotCatchUpEntries = entries }(snapshotCatchUpEntries)
+	snapshotCatchUpEntries = 0
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	n1 := startSingleNode(t, t.TempDir())
+	n1.SetSnapshotSource(testSnapshotSource{n1})
+	l2, url2 := listenLocal(t)
+	id2, err := n1.AddMember(ctx, url2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "conf change to be applied", func() bool { return n1.applied.Load() == n1.Status().Commit })
+	index := n1.applied.Load()
+	if _, err := n1.CreateSnapshot(ctx, index, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	n2 := serveNode(t, JoinRaftNode(zap.NewNop(), testConfig(), id2, n1.Members(), t.TempDir()), l2)
+	task := n2.nextTask(t)
+	for raft.IsEmptySnap(task.Snapshot) {
+		task = n2.nextTask(t)
+	}
+	if task.Snapshot.Metadata.Index < index || len(task.Snapshot.Data) > 0 {
+		t.Fatalf("expected snapshot without data, got %d %q", task.Snapshot.Metadata.Index, task.Snapshot.Data)
+	}
+	path, err := n2.SnapshotDBPath(task.Snapshot.Metadata.Index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db, err := os.ReadFile(path); err != nil || string(db) != "db" {
+		t.Fatalf("unexpected snapshot db %q: %v", db, err)
+	}
+	waitFor(t, "follower to catch up", func() bool { return n2.applied.Load() == n1.applied.Load() })
+}
+
+func TestConfChangeTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	n1 := startSingleNode(t, t.TempDir())
+	l2, url2 := listenLocal(t)
+	id2, err := n1.AddMember(ctx, url2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n2 := serveNode(t, JoinRaftNode(zap.NewNop(), testConfig(), id2, n1.Members(), t.TempDir()), l2)
+	waitFor(t, "new member to follow the leader", func() bool { return n2.Lead() == n1.ID() })
+
+	// Without the quorum the conf change is never applied, the proposal
+	// fails once the leader steps down or the deadline passes rather than
+	// waiting for the context of the caller.
+	n2.stop()
+	start := time.Now()
+	_, err = n1.AddMember(context.Background(), "http://127.0.0.1:1")
+	if err != ErrLeaderChanged && err != ErrConfChangeTimeout && err != ErrNoLeader {
+		t.Fatalf("expected the conf change to fail, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > n1.cfg.confChangeTimeout()+time.Second {
+		t.Fatalf("conf change failed after %v", elapsed)
+	}
+}
+
+func TestCreateSnapshot(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	dataDir := t.TempDir()
+	rc := startSingleNode(t, dataDir)
+	for i := 0; i < 3; i++ {
+		if err := rc.Propose(ctx, []byte("entry")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The conf change of the bootstrap member and the empty entry of the
+	// leader come first.
+	waitFor(t, "entries to be applied", func() bool { return rc.applied.Load() >= 5 })
+
+	index := rc.applied.Load()
+	data := []byte("state")
+	snapshot, err := rc.CreateSnapshot(ctx, index, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	members, snapData, err := DecodeSnapshotData(snapshot.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Metadata.Index != index || !bytes.Equal(snapData, data) {
+		t.Fatalf("unexpected snapshot at %d with data %q", snapshot.Metadata.Index, snapData)
+	}
+	if ids := memberIDsOf(members.Peers); len(ids) != 1 || ids[0] != rc.ID() {
+		t.Fatalf("unexpected members %v of snapshot", ids)
+	}
+	if voters := snapshot.Metadata.ConfState.Voters; len(voters) != 1 || voters[0] != uint64(rc.ID()) {
+		t.Fatalf("unexpected voters %v of snapshot", voters)
+	}
+	latest, err := rc.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Metadata.Index != index {
+		t.Fatalf("expected latest snapshot at %d, got %d", index, latest.Metadata.Index)
+	}
+	saved, err := snap.New(zap.NewNop(), filepath.Join(dataDir, "snap")).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Metadata.Index != index || !bytes.Equal(saved.Data, snapshot.Data) {
+		t.Fatalf("unexpected saved snapshot at %d", saved.Metadata.Index)
+	}
+	if _, err := rc.CreateSnapshot(ctx, index-1, data); err == nil {
+		t.Fatal("snapshot older than the latest one should be rejected")
+	}
+}
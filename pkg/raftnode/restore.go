@@ -0,0 +1,94 @@
+package raftnode
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"go.etcd.io/etcd/client/pkg/v3/types"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+	"go.etcd.io/etcd/server/v3/etcdserver/api/snap"
+	"go.etcd.io/etcd/server/v3/wal"
+	"go.etcd.io/etcd/server/v3/wal/walpb"
+	"go.uber.org/zap"
+
+	"github.com/gozssky/groupchat/pkg/metadata"
+)
+
+// Restore seeds the data dir of a new cluster member from a snapshot of the
+// state machine, which is either the bbolt database read from db, or data
+// encoded by old versions if db is nil. Every member of the new cluster must be restored from the
+// same snapshot with the same peer urls, the member ids are assigned in the
+// same way as NewRaftNode does. The data dir must be empty or not exist, so
+// that no state of another cluster, e.g. the state machine persisted by the
+// storage backend, is loaded together with the restored snapshot.
+func Restore(lg *zap.Logger, dataDir string, localURL string, peerURLs []string, index, term uint64, data []byte, db io.Reader) error {
+	snapDir := filepath.Join(dataDir, "snap")
+	walDir := filepath.Join(dataDir, "wal")
+	if entries, err := os.ReadDir(dataDir); err != nil && !os.IsNotExist(err) {
+		return err
+	} else if len(entries) > 0 {
+		return fmt.Errorf("data dir %s is not empty", dataDir)
+	}
+	if index == 0 {
+		return errors.New("snapshot index must be greater than 0")
+	}
+
+	urls := append([]string(nil), peerURLs...)
+	sort.Strings(urls)
+	var localID types.ID
+	md := &metadata.Metadata{}
+	var confState raftpb.ConfState
+	for i, url := range urls {
+		id := types.ID(i + 1)
+		if url == localURL {
+			localID = id
+		}
+		md.Peers = append(md.Peers, metadata.Peer{ID: id, URL: url})
+		confState.Voters = append(confState.Voters, uint64(id))
+	}
+	if localID == 0 {
+		return errors.New("local url not exists in peer urls")
+	}
+	md.ID = localID
+
+	if err := os.MkdirAll(snapDir, 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return err
+	}
+	raftSnap := raftpb.Snapshot{
+		Data: encodeSnapshotData(md, data),
+		Metadata: raftpb.SnapshotMetadata{
+			ConfState: confState,
+			Index:     index,
+			Term:      term,
+		},
+	}
+	snapshotter := snap.New(lg, snapDir)
+	if db != nil {
+		if _, err := snapshotter.SaveDBFrom(db, index); err != nil {
+			return err
+		}
+	}
+	if err := snapshotter.SaveSnap(raftSnap); err != nil {
+		return err
+	}
+	w, err := wal.Create(lg, walDir, md.MustMarshalJSON())
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if err := w.SaveSnapshot(walpb.Snapshot{Index: index, Term: term, ConfState: &confState}); err != nil {
+		return err
+	}
+	if err := w.Save(raftpb.HardState{Term: term, Commit: index}, nil); err != nil {
+		return err
+	}
+	saveMembers(lg, dataDir, md)
+	return nil
+}
@@ -0,0 +1,107 @@
+package raftnode
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/gozssky/groupchat/pkg/backup"
+	"github.com/gozssky/groupchat/pkg/storage"
+)
+
+func TestRestore(t *testing.T) {
+	st := storage.NewStorage()
+	result := (&storage.InternalRaftCommand{
+		CreateUser: &storage.CreateUserCommand{UserName: "alice", Password: "secret"},
+	}).Execute(st)
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+	st.Index = 42
+	backend, err := storage.OpenBackend(storage.BackendMemory, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Commit(st); err != nil {
+		t.Fatal(err)
+	}
+	db, size, index, err := backend.Snapshot(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var buf bytes.Buffer
+	enc, err := backup.NewEncoder(&buf, &backup.Backup{Index: index, Term: 3, DBSize: size})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.EncodeDB(db); err != nil {
+		t.Fatal(err)
+	}
+	b, dec, err := backup.NewDecoder(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, url := listenLocal(t)
+	dataDir := t.TempDir()
+	if err := Restore(zap.NewNop(), dataDir, url, nil, b.Index, b.Term, b.Data, dec); err == nil {
+		t.Fatal("restoring without the local url in peer urls should fail")
+	}
+	if err := Restore(zap.NewNop(), dataDir, url, []string{url}, b.Index, b.Term, b.Data, dec); err != nil {
+		t.Fatal(err)
+	}
+	if err := Restore(zap.NewNop(), dataDir, url, []string{url}, b.Index, b.Term, b.Data, nil); err == nil {
+		t.Fatal("restoring into a restored data dir should fail")
+	}
+
+	node, ok := RestartRaftNode(zap.NewNop(), testConfig(), dataDir, false)
+	if !ok {
+		t.Fatal("restored node should be restarted")
+	}
+	rc := serveNode(t, node, l)
+	task := rc.nextTask(t)
+	if task.Snapshot.Metadata.Index != b.Index || task.Snapshot.Metadata.Term != b.Term {
+		t.Fatalf("unexpected snapshot at %d of term %d", task.Snapshot.Metadata.Index, task.Snapshot.Metadata.Term)
+	}
+	path, err := rc.SnapshotDBPath(b.Index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := storage.NewStorage()
+	if err := backend.Restore(restored, path); err != nil {
+		t.Fatal(err)
+	}
+	if user, ok := restored.Users["alice"]; !ok || user.Password != "secret" {
+		t.Fatal("user should be restored from the backup")
+	}
+
+	// The restored member forms a cluster and keeps appending after the
+	// snapshot.
+	waitFor(t, "leader", rc.IsLead)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	if err := rc.Propose(ctx, []byte("entry")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "entries after the snapshot to be applied", func() bool { return rc.applied.Load() > b.Index })
+	if term, err := rc.Term(b.Index); err != nil || term != b.Term {
+		t.Fatalf("unexpected term %d at the snapshot index: %v", term, err)
+	}
+}
+
+func TestRestoreNonEmptyDataDir(t *testing.T) {
+	dataDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dataDir, "state.db"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	url := "http://127.0.0.1:8080"
+	if err := Restore(zap.NewNop(), dataDir, url, []string{url}, 1, 1, nil, nil); err == nil {
+		t.Fatal("restoring into a data dir with existing state should fail")
+	}
+}
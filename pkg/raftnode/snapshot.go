@@ -0,0 +1,178 @@
+package raftnode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"path/filepath"
+	"time"
+
+	"go.etcd.io/etcd/client/pkg/v3/fileutil"
+	"go.etcd.io/etcd/raft/v3"
+	"go.etcd.io/etcd/raft/v3/raftpb"
+	"go.etcd.io/etcd/server/v3/etcdserver/api/snap"
+	"go.uber.org/zap"
+
+	"github.com/gozssky/groupchat/pkg/metadata"
+)
+
+const (
+	// maxSnapFiles and maxWALFiles are the numbers of snapshot, snapshot db
+	// and wal files kept in the data dir, the older ones are purged.
+	maxSnapFiles = 5
+	maxWALFiles  = 5
+	// purgeFileInterval is the interval of purging old files.
+	purgeFileInterval = time.Second * 30
+)
+
+// SnapshotSource provides the state machine sent to followers which lag
+// behind the compacted log.
+type SnapshotSource interface {
+	// OpenSnapshot returns a reader of the state machine as a bbolt
+	// database together with its size and applied index.
+	OpenSnapshot() (rc io.ReadCloser, size int64, index uint64, err error)
+}
+
+// snapshotMagic prefixes the data of raft snapshots which carry the members
+// at the snapshot index. It is followed by the length of the members in JSON
+// as a big endian uint32, the members and the data of the state machine.
+// Snapshots created by old versions only hold the state machine, whose gob
+// encoding never starts with a zero byte.
+var snapshotMagic = []byte("\x00groupchat-snapshot\x00")
+
+var errCorruptSnapshot = errors.New("corrupt snapshot data")
+
+// encodeSnapshotData encodes the members together with the data of the state
+// machine, the id of md is dropped since it is local to each member.
+func encodeSnapshotData(md *metadata.Metadata, data []byte) []byte {
+	members, err := json.Marshal(&metadata.Metadata{Peers: md.Peers, Removed: md.Removed})
+	if err != nil {
+		panic(err)
+	}
+	buf := make([]byte, 0, len(snapshotMagic)+4+len(members)+len(data))
+	buf = append(buf, snapshotMagic...)
+	buf = append(buf, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(buf[len(snapshotMagic):], uint32(len(members)))
+	buf = append(buf, members...)
+	return append(buf, data...)
+}
+
+// DecodeSnapshotData returns the members and the data of the state machine
+// in the data of a raft snapshot, members are nil for snapshots of old
+// versions.
+func DecodeSnapshotData(raw []byte) (*metadata.Metadata, []byte, error) {
+	if !bytes.HasPrefix(raw, snapshotMagic) {
+		return nil, raw, nil
+	}
+	raw = raw[len(snapshotMagic):]
+	if len(raw) < 4 {
+		return nil, nil, errCorruptSnapshot
+	}
+	n := binary.BigEndian.Uint32(raw)
+	raw = raw[4:]
+	if uint64(len(raw)) < uint64(n) {
+		return nil, nil, errCorruptSnapshot
+	}
+	var md metadata.Metadata
+	if err := json.Unmarshal(raw[:n], &md); err != nil {
+		return nil, nil, err
+	}
+	return &md, raw[n:], nil
+}
+
+// SetSnapshotSource sets the source of the snapshots sent to followers.
+// Without a source, snapshots are sent with the data they were created with.
+func (rc *Node) SetSnapshotSource(source SnapshotSource) {
+	rc.snapMu.Lock()
+	defer rc.snapMu.Unlock()
+	rc.snapSource = source
+}
+
+// SaveSnapshotDB saves the state machine read from r as a bbolt database
+// for the snapshot at the given index, it must be called before the snapshot
+// is created.
+func (rc *Node) SaveSnapshotDB(r io.Reader, index uint64) error {
+	_, err := rc.snapshotter.SaveDBFrom(r, index)
+	return err
+}
+
+// SnapshotDBPath returns the path of the bbolt database of the snapshot at
+// the given index, which is received from the leader or saved by
+// SaveSnapshotDB. It fails if there is no such database.
+func (rc *Node) SnapshotDBPath(index uint64) (string, error) {
+	return rc.snapshotter.DBFilePath(index)
+}
+
+// send sends the messages to other members, snapshots are streamed from the
+// snapshot source in the background.
+func (rc *Node) send(msgs []raftpb.Message) {
+	rc.snapMu.Lock()
+	source := rc.snapSource
+	rc.snapMu.Unlock()
+	if source != nil {
+		for i := range msgs {
+			if msgs[i].Type == raftpb.MsgSnap {
+				go rc.sendSnapshot(source, msgs[i])
+				// The transport drops messages to 0.
+				msgs[i].To = 0
+			}
+		}
+	}
+	rc.transport.Send(msgs)
+}
+
+// sendSnapshot replaces the snapshot of m with the current state machine of
+// the source, which is never older, and streams it to the follower. The
+// transport reports the status of the snapshot when it is sent.
+func (rc *Node) sendSnapshot(source SnapshotSource, m raftpb.Message) {
+	r, size, index, err := source.OpenSnapshot()
+	if err != nil {
+		rc.lg.Warn("failed to open snapshot", zap.Error(err))
+		rc.node.ReportSnapshot(m.To, raft.SnapshotFailure)
+		return
+	}
+	term, err := rc.storage.Term(index)
+	if err != nil {
+		r.Close()
+		rc.lg.Warn("failed to get the term of snapshot", zap.Uint64("index", index), zap.Error(err))
+		rc.node.ReportSnapshot(m.To, raft.SnapshotFailure)
+		return
+	}
+	cs, members := rc.confStateAt(index)
+	m.Snapshot = raftpb.Snapshot{
+		Data: encodeSnapshotData(members, nil),
+		Metadata: raftpb.SnapshotMetadata{
+			ConfState: cs,
+			Index:     index,
+			Term:      term,
+		},
+	}
+	rc.transport.SendSnapshot(*snap.NewMessage(m, r, size))
+}
+
+// purgeFiles purges the old snapshot and wal files in the background. The
+// wal files are purged only after they are released by newer snapshots.
+func (rc *Node) purgeFiles() {
+	snapDir := filepath.Join(rc.dataDir, "snap")
+	walDir := filepath.Join(rc.dataDir, "wal")
+	for _, p := range []struct {
+		dir    string
+		suffix string
+		max    uint
+	}{
+		{snapDir, ".snap", maxSnapFiles},
+		{snapDir, ".snap.db", maxSnapFiles},
+		{walDir, ".wal", maxWALFiles},
+	} {
+		errC := fileutil.PurgeFile(rc.lg, p.dir, p.suffix, p.max, purgeFileInterval, rc.stopC)
+		go func() {
+			select {
+			case err := <-errC:
+				rc.lg.Fatal("failed to purge files", zap.Error(err))
+			case <-rc.stopC:
+			}
+		}()
+	}
+}
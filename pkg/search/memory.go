@@ -0,0 +1,72 @@
+package search
+
+// memoryStore keeps an index in maps.
+type memoryStore struct {
+	docs        map[DocID]*Doc
+	postings    map[string]map[DocID]struct{}
+	totalLength int
+}
+
+// NewMemoryStore returns an empty store kept in memory.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		docs:     make(map[DocID]*Doc),
+		postings: make(map[string]map[DocID]struct{}),
+	}
+}
+
+func (m *memoryStore) Doc(id DocID) *Doc {
+	return m.docs[id]
+}
+
+func (m *memoryStore) PutDoc(id DocID, d *Doc) {
+	m.docs[id] = d
+}
+
+func (m *memoryStore) DeleteDoc(id DocID) {
+	delete(m.docs, id)
+}
+
+func (m *memoryStore) RoomDocs(roomID int) []DocID {
+	var ids []DocID
+	for id := range m.docs {
+		if id.RoomID == roomID {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (m *memoryStore) AddPosting(term string, id DocID) {
+	if m.postings[term] == nil {
+		m.postings[term] = make(map[DocID]struct{})
+	}
+	m.postings[term][id] = struct{}{}
+}
+
+func (m *memoryStore) DeletePosting(term string, id DocID) {
+	delete(m.postings[term], id)
+	if len(m.postings[term]) == 0 {
+		delete(m.postings, term)
+	}
+}
+
+func (m *memoryStore) Postings(term string) []DocID {
+	ids := make([]DocID, 0, len(m.postings[term]))
+	for id := range m.postings[term] {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (m *memoryStore) DocFreq(term string) int {
+	return len(m.postings[term])
+}
+
+func (m *memoryStore) Stats() (int, int) {
+	return len(m.docs), m.totalLength
+}
+
+func (m *memoryStore) SetStats(_, totalLength int) {
+	m.totalLength = totalLength
+}
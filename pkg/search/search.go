@@ -0,0 +1,234 @@
+// Package search implements an inverted index of messages over a pluggable
+// store of docs and postings.
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BM25 parameters.
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// MaxTermLength limits the length of a term in bytes, longer terms are
+// truncated so that they fit in the keys of a KV store.
+const MaxTermLength = 256
+
+// DocID identifies a message by its room and seq.
+type DocID struct {
+	RoomID int
+	Seq    uint64
+}
+
+// Doc is an indexed message.
+type Doc struct {
+	// Terms maps the terms of the doc to their frequencies.
+	Terms  map[string]int
+	Length int
+	TS     int64
+}
+
+// Hit is a doc matching a query.
+type Hit struct {
+	ID    DocID
+	Score float64
+}
+
+// Store keeps the docs and postings of an index, which are as large as the
+// messages, so that they can be kept on disk rather than in memory.
+type Store interface {
+	// Doc returns the doc, or nil if it doesn't exist.
+	Doc(id DocID) *Doc
+	PutDoc(id DocID, d *Doc)
+	DeleteDoc(id DocID)
+	// RoomDocs returns the ids of the docs of the room.
+	RoomDocs(roomID int) []DocID
+	AddPosting(term string, id DocID)
+	DeletePosting(term string, id DocID)
+	// Postings returns the ids of the docs containing the term.
+	Postings(term string) []DocID
+	// DocFreq returns the number of docs containing the term.
+	DocFreq(term string) int
+	// Stats returns the number of docs and the sum of their lengths.
+	Stats() (docs, totalLength int)
+	SetStats(docs, totalLength int)
+}
+
+// Index is an inverted index of docs, it is not safe for concurrent use.
+type Index struct {
+	store Store
+}
+
+// NewIndex returns an index kept in memory.
+func NewIndex() *Index {
+	return New(NewMemoryStore())
+}
+
+// New returns an index kept by the store.
+func New(store Store) *Index {
+	return &Index{store: store}
+}
+
+// Tokenize splits the text into lower case terms. Letters and digits form
+// terms, except that every Han, Hiragana, Katakana or Hangul character is a
+// term by itself since these scripts don't separate words by spaces.
+func Tokenize(text string) []string {
+	var terms []string
+	start := -1
+	flush := func(end int) {
+		if start >= 0 {
+			terms = append(terms, truncateTerm(strings.ToLower(text[start:end])))
+			start = -1
+		}
+	}
+	for i, r := range text {
+		switch {
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			flush(i)
+			terms = append(terms, string(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r):
+			if start < 0 {
+				start = i
+			}
+		default:
+			flush(i)
+		}
+	}
+	flush(len(text))
+	return terms
+}
+
+// truncateTerm truncates the term to MaxTermLength at a rune boundary.
+func truncateTerm(term string) string {
+	if len(term) <= MaxTermLength {
+		return term
+	}
+	n := MaxTermLength
+	for n > 0 && !utf8.RuneStart(term[n]) {
+		n--
+	}
+	return term[:n]
+}
+
+// Len returns the number of docs in the index.
+func (idx *Index) Len() int {
+	docs, _ := idx.store.Stats()
+	return docs
+}
+
+// Put indexes the text of the doc with its timestamp, replacing the text
+// indexed before.
+func (idx *Index) Put(id DocID, text string, ts int64) {
+	terms := make(map[string]int)
+	tokens := Tokenize(text)
+	for _, term := range tokens {
+		terms[term]++
+	}
+	if old := idx.store.Doc(id); old != nil {
+		if old.TS == ts && equalTerms(old.Terms, terms) {
+			return
+		}
+		idx.Delete(id)
+	}
+	idx.store.PutDoc(id, &Doc{Terms: terms, Length: len(tokens), TS: ts})
+	for term := range terms {
+		idx.store.AddPosting(term, id)
+	}
+	docs, totalLength := idx.store.Stats()
+	idx.store.SetStats(docs+1, totalLength+len(tokens))
+}
+
+func equalTerms(a, b map[string]int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for term, n := range a {
+		if b[term] != n {
+			return false
+		}
+	}
+	return true
+}
+
+// Delete removes the doc from the index if it exists.
+func (idx *Index) Delete(id DocID) {
+	d := idx.store.Doc(id)
+	if d == nil {
+		return
+	}
+	idx.store.DeleteDoc(id)
+	for term := range d.Terms {
+		idx.store.DeletePosting(term, id)
+	}
+	docs, totalLength := idx.store.Stats()
+	idx.store.SetStats(docs-1, totalLength-d.Length)
+}
+
+// DeleteRoom removes all docs of the room from the index.
+func (idx *Index) DeleteRoom(roomID int) {
+	for _, id := range idx.store.RoomDocs(roomID) {
+		idx.Delete(id)
+	}
+}
+
+// Search returns the docs containing all terms of the query ranked by BM25,
+// newer docs come first if their scores are equal.
+func (idx *Index) Search(query string) []Hit {
+	terms := Tokenize(query)
+	if len(terms) == 0 {
+		return nil
+	}
+	dfs := make(map[string]int, len(terms))
+	for _, term := range terms {
+		dfs[term] = idx.store.DocFreq(term)
+	}
+	// Start from the rarest term to examine the fewest docs.
+	sort.Slice(terms, func(i, j int) bool {
+		return dfs[terms[i]] < dfs[terms[j]]
+	})
+	docs, totalLength := idx.store.Stats()
+	avgLength := float64(totalLength) / float64(docs)
+	var hits []Hit
+	ts := make(map[DocID]int64)
+	for _, id := range idx.store.Postings(terms[0]) {
+		d := idx.store.Doc(id)
+		if d == nil {
+			continue
+		}
+		score := 0.0
+		for _, term := range terms {
+			tf := float64(d.Terms[term])
+			if tf == 0 {
+				score = -1
+				break
+			}
+			df := float64(dfs[term])
+			idf := math.Log(1 + (float64(docs)-df+0.5)/(df+0.5))
+			score += idf * tf * (k1 + 1) / (tf + k1*(1-b+b*float64(d.Length)/avgLength))
+		}
+		if score >= 0 {
+			hits = append(hits, Hit{ID: id, Score: score})
+			ts[id] = d.TS
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		ti, tj := ts[hits[i].ID], ts[hits[j].ID]
+		if ti != tj {
+			return ti > tj
+		}
+		if hits[i].ID.RoomID != hits[j].ID.RoomID {
+			return hits[i].ID.RoomID < hits[j].ID.RoomID
+		}
+		return hits[i].ID.Seq > hits[j].ID.Seq
+	})
+	return hits
+}
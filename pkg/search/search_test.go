@@ -0,0 +1,62 @@
+package search
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	for text, expected := range map[string][]string{
+		"Hello, World!": {"hello", "world"},
+		"v1.2 don't":    {"v1", "2", "don", "t"},
+		"  ":            nil,
+		"Café 你好 raft":  {"café", "你", "好", "raft"},
+	} {
+		if terms := Tokenize(text); !reflect.DeepEqual(terms, expected) {
+			t.Errorf("Tokenize(%q) = %q, expected %q", text, terms, expected)
+		}
+	}
+	// Long terms are cut at a rune boundary.
+	long := strings.Repeat("a", MaxTermLength-1) + "é"
+	if terms := Tokenize(long); len(terms) != 1 || terms[0] != long[:MaxTermLength-1] {
+		t.Errorf("Tokenize(%q) = %q", long, terms)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	idx := NewIndex()
+	idx.Put(DocID{RoomID: 1, Seq: 1}, "the raft log is replicated", 1)
+	idx.Put(DocID{RoomID: 1, Seq: 2}, "raft raft raft", 2)
+	idx.Put(DocID{RoomID: 2, Seq: 1}, "a boat, not a raft log", 3)
+	idx.Put(DocID{RoomID: 2, Seq: 2}, "nothing to see", 4)
+
+	ids := func(hits []Hit) []DocID {
+		var result []DocID
+		for _, hit := range hits {
+			result = append(result, hit.ID)
+		}
+		return result
+	}
+	// Shorter docs rank first.
+	if hits := ids(idx.Search("RAFT log")); !reflect.DeepEqual(hits, []DocID{{1, 1}, {2, 1}}) {
+		t.Fatalf("all terms should match: %v", hits)
+	}
+	if hits := idx.Search("raft"); len(hits) != 3 || hits[0].ID != (DocID{1, 2}) {
+		t.Fatalf("frequent terms should rank first: %v", hits)
+	}
+
+	// Replacing the text drops the old terms.
+	idx.Put(DocID{RoomID: 1, Seq: 2}, "edited", 2)
+	if hits := idx.Search("raft"); len(hits) != 2 {
+		t.Fatalf("unexpected hits after edit: %v", hits)
+	}
+	idx.Delete(DocID{RoomID: 1, Seq: 1})
+	idx.DeleteRoom(2)
+	if hits := idx.Search("raft"); len(hits) != 0 || idx.Len() != 1 {
+		t.Fatalf("unexpected hits after delete: %v", hits)
+	}
+	if hits := idx.Search("!!"); hits != nil {
+		t.Fatalf("empty queries should match nothing: %v", hits)
+	}
+}
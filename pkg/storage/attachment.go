@@ -0,0 +1,131 @@
+package storage
+
+import "errors"
+
+// MaxAttachments limits the number of attachments of a message.
+const MaxAttachments = 10
+
+var (
+	ErrAttachmentNotExists = errors.New("attachment not exists")
+	ErrTooManyAttachments  = errors.New("too many attachments")
+)
+
+// Attachment describes an uploaded blob, the content is kept by the blob
+// store of every member rather than raft. There is no garbage collection:
+// attachments and their blobs are kept after no message refers to them.
+type Attachment struct {
+	// ID is the blob id, i.e. the SHA-256 of the content.
+	ID       string
+	Size     int64
+	MIMEType string
+	// Uploader and TS are the first user who uploaded it and when.
+	Uploader string
+	TS       int
+	// Rooms maps ids of the rooms whose messages refer to the attachment
+	// to the number of the messages.
+	Rooms map[int]int
+}
+
+// checkAttachments returns an error if any of the attachments is not
+// uploaded.
+func (s *Storage) checkAttachments(ids []string) error {
+	if len(ids) > MaxAttachments {
+		return ErrTooManyAttachments
+	}
+	for _, id := range ids {
+		if _, ok := s.Attachments[id]; !ok {
+			return ErrAttachmentNotExists
+		}
+	}
+	return nil
+}
+
+// refAttachments records that a message of the room refers to the
+// attachments.
+func (s *Storage) refAttachments(roomID int, ids []string) {
+	for _, id := range ids {
+		attachment, ok := s.Attachments[id]
+		if !ok {
+			continue
+		}
+		if attachment.Rooms == nil {
+			attachment.Rooms = make(map[int]int)
+		}
+		attachment.Rooms[roomID]++
+		s.touchAttachment(id)
+	}
+}
+
+// unrefAttachments records that a message of the room no longer refers to
+// the attachments, because it is deleted or pruned.
+func (s *Storage) unrefAttachments(roomID int, ids []string) {
+	for _, id := range ids {
+		attachment, ok := s.Attachments[id]
+		if !ok {
+			continue
+		}
+		if attachment.Rooms[roomID]--; attachment.Rooms[roomID] <= 0 {
+			delete(attachment.Rooms, roomID)
+		}
+		s.touchAttachment(id)
+	}
+}
+
+// unrefRoomAttachments records that the messages of the deleted room no
+// longer refer to any attachment.
+func (s *Storage) unrefRoomAttachments(roomID int) {
+	for id, attachment := range s.Attachments {
+		if _, ok := attachment.Rooms[roomID]; ok {
+			delete(attachment.Rooms, roomID)
+			s.touchAttachment(id)
+		}
+	}
+}
+
+// CanDownload returns whether the user can download the attachment, which
+// is the case for its uploader and members of the rooms whose messages refer
+// to it.
+func (s *Storage) CanDownload(user *User, id string) bool {
+	attachment, ok := s.Attachments[id]
+	if !ok || user == nil {
+		return false
+	}
+	if attachment.Uploader == user.UserName {
+		return true
+	}
+	for roomID := range attachment.Rooms {
+		if user.IsMember(roomID) {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAttachmentCommand records a blob stored by the proposer, so that
+// messages can refer to it and other members can fetch it. Uploading the
+// same content again keeps the first record.
+type CreateAttachmentCommand struct {
+	UserName string
+	ID       string
+	Size     int64
+	MIMEType string
+	TS       int
+}
+
+func (c *CreateAttachmentCommand) Execute(s *Storage) *ExecuteResult {
+	if _, ok := s.Users[c.UserName]; !ok {
+		return &ExecuteResult{Err: ErrUserNotExists}
+	}
+	if _, ok := s.Attachments[c.ID]; ok {
+		return &ExecuteResult{}
+	}
+	s.Attachments[c.ID] = &Attachment{
+		ID:       c.ID,
+		Size:     c.Size,
+		MIMEType: c.MIMEType,
+		Uploader: c.UserName,
+		TS:       c.TS,
+	}
+	s.touchAttachment(c.ID)
+	return &ExecuteResult{}
+}
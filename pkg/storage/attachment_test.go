@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAttachments(t *testing.T) {
+	dir := t.TempDir()
+	b, err := OpenBackend(BackendBolt, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewStorage()
+	if err := b.Load(s); err != nil {
+		t.Fatal(err)
+	}
+	mustExecute(t, s, InternalRaftCommand{CreateUser: &CreateUserCommand{UserName: "alice"}})
+	id := mustExecute(t, s, InternalRaftCommand{CreateRoom: &CreateRoomCommand{Name: "lobby"}}).(int)
+	mustExecute(t, s, InternalRaftCommand{EnterRoom: &EnterRoomCommand{UserName: "alice", RoomID: id}})
+	create := InternalRaftCommand{CreateAttachment: &CreateAttachmentCommand{
+		UserName: "alice", ID: "blob", Size: 3, MIMEType: "image/png", TS: 1,
+	}}
+	mustExecute(t, s, create)
+	// Uploading the same content again keeps the first record.
+	create.CreateAttachment.TS = 2
+	mustExecute(t, s, create)
+	if s.Attachments["blob"].TS != 1 {
+		t.Fatalf("unexpected attachment: %+v", s.Attachments["blob"])
+	}
+
+	send := func(msgID string, attachments ...string) *ExecuteResult {
+		cmd := InternalRaftCommand{SendMessage: &SendMessageCommand{
+			ID: msgID, UserName: "alice", Attachments: attachments,
+		}}
+		return cmd.Execute(s)
+	}
+	if err := send("m1", "blob").Err; err != nil {
+		t.Fatal(err)
+	}
+	if err := send("m2", "unknown").Err; err != ErrAttachmentNotExists {
+		t.Fatalf("expected ErrAttachmentNotExists, got %v", err)
+	}
+	many := make([]string, MaxAttachments+1)
+	for i := range many {
+		many[i] = "blob"
+	}
+	if err := send("m2", many...).Err; err != ErrTooManyAttachments {
+		t.Fatalf("expected ErrTooManyAttachments, got %v", err)
+	}
+	room, _ := s.Room(id)
+	if msg, _ := room.MessageByID("m1"); !reflect.DeepEqual(msg.Attachments, []string{"blob"}) {
+		t.Fatalf("unexpected attachments: %v", msg.Attachments)
+	}
+
+	if err := b.Commit(s); err != nil {
+		t.Fatal(err)
+	}
+	b, s2 := reopen(t, b, dir)
+	defer b.Close()
+	if !reflect.DeepEqual(snapshotOf(s), snapshotOf(s2)) {
+		t.Fatalf("loaded storage mismatch, expect %+v, got %+v", s.Snapshot, s2.Snapshot)
+	}
+
+	mustExecute(t, s2, InternalRaftCommand{DeleteMessage: &DeleteMessageCommand{UserName: "alice", RoomID: id, ID: "m1"}})
+	room, _ = s2.Room(id)
+	if msg, _ := room.MessageByID("m1"); msg.Attachments != nil {
+		t.Fatalf("attachments of deleted messages should be dropped: %v", msg.Attachments)
+	}
+}
+
+func TestAttachmentAccess(t *testing.T) {
+	s, id := newTestRoom(t, CreateRoomCommand{Name: "lobby", Owner: "alice"}, "alice", "bob")
+	mustExecute(t, s, InternalRaftCommand{CreateAttachment: &CreateAttachmentCommand{
+		UserName: "alice", ID: "blob", Size: 3, MIMEType: "image/png", TS: 1,
+	}})
+	alice, bob, carol := s.Users["alice"], s.Users["bob"], s.Users["carol"]
+	if !s.CanDownload(alice, "blob") || s.CanDownload(bob, "blob") || s.CanDownload(nil, "blob") {
+		t.Fatal("only the uploader should download attachments which are not sent")
+	}
+	if s.CanDownload(alice, "unknown") {
+		t.Fatal("unknown attachments should not be downloaded")
+	}
+
+	for _, msgID := range []string{"m1", "m2"} {
+		mustExecute(t, s, InternalRaftCommand{SendMessage: &SendMessageCommand{
+			ID: msgID, UserName: "alice", Attachments: []string{"blob"},
+		}})
+	}
+	if !s.CanDownload(bob, "blob") || s.CanDownload(carol, "blob") {
+		t.Fatal("only members of the room should download sent attachments")
+	}
+	recovered := NewStorage()
+	recovered.RecoverFromSnapshot(legacySnapshot(t, s))
+	if !recovered.CanDownload(recovered.Users["bob"], "blob") || recovered.Attachments["blob"].Rooms[id] != 2 {
+		t.Fatal("references to attachments should be rebuilt from snapshots of old versions")
+	}
+	if recovered := recoverStorage(t, s); !recovered.CanDownload(recovered.Users["bob"], "blob") {
+		t.Fatal("references to attachments should be kept in snapshots")
+	}
+
+	// The attachment is referred to until every message is deleted.
+	mustExecute(t, s, InternalRaftCommand{DeleteMessage: &DeleteMessageCommand{UserName: "alice", RoomID: id, ID: "m1"}})
+	if !s.CanDownload(bob, "blob") {
+		t.Fatal("attachment should be referred to by the remaining message")
+	}
+	mustExecute(t, s, InternalRaftCommand{DeleteMessage: &DeleteMessageCommand{UserName: "alice", RoomID: id, ID: "m2"}})
+	if s.CanDownload(bob, "blob") || !s.CanDownload(alice, "blob") {
+		t.Fatal("only the uploader should download attachments of deleted messages")
+	}
+
+	mustExecute(t, s, InternalRaftCommand{SendMessage: &SendMessageCommand{
+		ID: "m3", UserName: "alice", Attachments: []string{"blob"},
+	}})
+	mustExecute(t, s, InternalRaftCommand{DeleteRoom: &DeleteRoomCommand{UserName: "alice", RoomID: id}})
+	if rooms := s.Attachments["blob"].Rooms; len(rooms) != 0 {
+		t.Fatalf("references of deleted rooms should be dropped: %v", rooms)
+	}
+}
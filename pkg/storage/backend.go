@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// BackendMemory keeps the state machine only in memory, it is rebuilt
+	// from the latest snapshot and the WAL on every start.
+	BackendMemory = "memory"
+	// BackendBolt persists the state machine together with the applied
+	// index in a bbolt database, so only the tail of the WAL is replayed on
+	// start. Messages are read from the database on demand, so they don't
+	// have to fit in memory.
+	BackendBolt = "bolt"
+
+	boltFileName = "state.db"
+)
+
+// Backend persists the state machine. All methods are called with the
+// storage locked, and the storage must have been loaded, reset or restored
+// by the backend, so that it reads messages from the backend.
+type Backend interface {
+	// Load loads the persisted state into an empty storage.
+	Load(s *Storage) error
+	// Commit persists the changes since the last commit and s.Index
+	// atomically.
+	Commit(s *Storage) error
+	// Reset replaces the whole persisted state with s, whose messages are
+	// all recorded as changes. It is used after s is recovered from a
+	// snapshot of old versions.
+	Reset(s *Storage) error
+	// Restore replaces the persisted state with the bbolt database at path,
+	// which is received with a raft snapshot, and loads it into an empty
+	// storage.
+	Restore(s *Storage, path string) error
+	// Snapshot returns a reader of the committed state as a bbolt database
+	// together with its size and applied index, the reader must be closed.
+	// It can be read after the storage is unlocked.
+	Snapshot(s *Storage) (io.ReadCloser, int64, uint64, error)
+	Close() error
+}
+
+// ValidateBackend returns an error if kind is not a known backend.
+func ValidateBackend(kind string) error {
+	switch kind {
+	case BackendMemory, BackendBolt:
+		return nil
+	default:
+		return fmt.Errorf("unknown storage backend %q", kind)
+	}
+}
+
+// OpenBackend opens the backend of the given kind in dataDir.
+func OpenBackend(kind string, dataDir string) (Backend, error) {
+	switch kind {
+	case BackendMemory:
+		return memoryBackend{}, nil
+	case BackendBolt:
+		return openBoltBackend(filepath.Join(dataDir, boltFileName))
+	default:
+		return nil, ValidateBackend(kind)
+	}
+}
+
+type memoryBackend struct{}
+
+func (memoryBackend) Load(*Storage) error { return nil }
+
+func (memoryBackend) Commit(s *Storage) error {
+	commitMessages(s.messages.(*memoryStore), s, s.TakeChanges())
+	return nil
+}
+
+func (memoryBackend) Reset(s *Storage) error {
+	s.messages = newMemoryStore()
+	commitMessages(s.messages.(*memoryStore), s, s.TakeChanges())
+	return nil
+}
+
+// Restore reads the whole database into memory, it is left in place since
+// the state is restored from it again on restart.
+func (memoryBackend) Restore(s *Storage, path string) error {
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	store := newMemoryStore()
+	err = db.View(func(tx *bolt.Tx) error {
+		if err := loadResident(tx, s); err != nil {
+			return err
+		}
+		return tx.Bucket(messagesBucket).ForEach(func(k, v []byte) error {
+			msg := &Message{}
+			if err := decodeGOB(v, msg); err != nil {
+				return err
+			}
+			store.putMessage(parseMessageKey(k), msg)
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	s.messages = store
+	s.rebuild()
+	// The indexes are rebuilt rather than read, which takes about as long.
+	for roomID, messages := range store.messages {
+		room, ok := s.Rooms[roomID]
+		if !ok {
+			continue
+		}
+		for seq, msg := range messages {
+			key := MessageKey{RoomID: roomID, Seq: seq}
+			if len(msg.ID) > 0 {
+				store.putID(MessageIDKey{RoomID: roomID, ID: msg.ID}, seq)
+			}
+			for _, names := range msg.Reactions {
+				for _, name := range names {
+					store.putReaction(ReactionKey{UserName: name, MessageKey: key})
+				}
+			}
+			indexMessage(store.index, room, key, msg)
+		}
+	}
+	return nil
+}
+
+// Snapshot writes the state to a temporary bbolt database, which is removed
+// when the reader is closed.
+func (memoryBackend) Snapshot(s *Storage) (io.ReadCloser, int64, uint64, error) {
+	f, err := os.CreateTemp("", "groupchat-snapshot-*.db")
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	path := f.Name()
+	f.Close()
+	if err := writeBoltDB(path, s); err != nil {
+		os.Remove(path)
+		return nil, 0, 0, err
+	}
+	f, err = os.Open(path)
+	if err != nil {
+		os.Remove(path)
+		return nil, 0, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, 0, 0, err
+	}
+	return &tempFile{File: f}, info.Size(), s.Index, nil
+}
+
+func (memoryBackend) Close() error { return nil }
+
+// writeBoltDB writes the state of a storage backed by the memory store to
+// a new bbolt database at path.
+func writeBoltDB(path string, s *Storage) error {
+	db, err := bolt.Open(path, 0600, &bolt.Options{NoSync: true})
+	if err != nil {
+		return err
+	}
+	store := s.messages.(*memoryStore)
+	changes := newChanges()
+	store.forEach(func(key MessageKey, msg *Message) {
+		changes.Messages[key] = msg
+	})
+	for roomID, ids := range store.ids {
+		for id, seq := range ids {
+			changes.IDs[MessageIDKey{RoomID: roomID, ID: id}] = seq
+		}
+	}
+	for name, keys := range store.reactions {
+		for key := range keys {
+			changes.Reactions[ReactionKey{UserName: name, MessageKey: key}] = true
+		}
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		return (&boltWriter{tx: tx}).reset(s, changes)
+	})
+	if err == nil {
+		err = db.Sync()
+	}
+	if cerr := db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// tempFile removes the file when it is closed.
+type tempFile struct {
+	*os.File
+}
+
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
@@ -0,0 +1,608 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"io"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/gozssky/groupchat/pkg/search"
+)
+
+// schemaVersion is the version of the layout of the buckets. Databases of
+// old versions have no version and keep all messages in memory, they are
+// migrated on load.
+const schemaVersion = 1
+
+// boltMmapSize is the initial size of the memory map of the database, a
+// large one keeps long read transactions streaming snapshots from blocking
+// the writes which grow the database.
+const boltMmapSize = 10 * 1024 * 1024 * 1024
+
+// messageCacheSize bounds the number of decoded messages kept in memory.
+const messageCacheSize = 100000
+
+var (
+	metaBucket     = []byte("meta")
+	usersBucket    = []byte("users")
+	roomsBucket    = []byte("rooms")
+	messagesBucket = []byte("messages")
+	// attachmentsBucket is keyed by blob id.
+	attachmentsBucket = []byte("attachments")
+	// idsBucket maps room ids and message ids to seqs.
+	idsBucket = []byte("ids")
+	// reactionsBucket is keyed by the length of user names as a big endian
+	// uint16, user names and message keys.
+	reactionsBucket = []byte("reactions")
+	// docsBucket, postingsBucket and termsBucket keep the search index,
+	// postings are keyed by terms, a zero byte and doc ids, and terms map
+	// to the number of docs containing them.
+	docsBucket     = []byte("docs")
+	postingsBucket = []byte("postings")
+	termsBucket    = []byte("terms")
+
+	indexKey       = []byte("index")
+	secretKeyKey   = []byte("secretKey")
+	nextRoomIDKey  = []byte("nextRoomID")
+	retentionKey   = []byte("retention")
+	schemaKey      = []byte("schema")
+	searchStatsKey = []byte("searchStats")
+
+	allBuckets = [][]byte{
+		metaBucket, usersBucket, roomsBucket, messagesBucket, attachmentsBucket,
+		idsBucket, reactionsBucket, docsBucket, postingsBucket, termsBucket,
+	}
+)
+
+// boltBackend stores users, rooms and attachments in their own buckets,
+// which are loaded into memory, and messages in a bucket keyed by room id
+// and seq together with their indexes, which are read on demand through a
+// bounded cache. So the messages don't have to fit in memory.
+type boltBackend struct {
+	path  string
+	db    *bolt.DB
+	cache *messageCache
+}
+
+func openBoltDB(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second, InitialMmapSize: boltMmapSize})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range allBuckets {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		// A new database has neither the version nor the index.
+		meta := tx.Bucket(metaBucket)
+		if meta.Get(schemaKey) == nil && meta.Get(indexKey) == nil {
+			return meta.Put(schemaKey, uint64Key(schemaVersion))
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func openBoltBackend(path string) (*boltBackend, error) {
+	db, err := openBoltDB(path)
+	if err != nil {
+		return nil, err
+	}
+	return &boltBackend{path: path, db: db, cache: newMessageCache(messageCacheSize)}, nil
+}
+
+func uint64Key(v uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, v)
+	return key
+}
+
+func messageKey(key MessageKey) []byte {
+	return append(uint64Key(uint64(key.RoomID)), uint64Key(key.Seq)...)
+}
+
+func parseMessageKey(k []byte) MessageKey {
+	return MessageKey{RoomID: int(binary.BigEndian.Uint64(k[:8])), Seq: binary.BigEndian.Uint64(k[8:16])}
+}
+
+func idKey(key MessageIDKey) []byte {
+	return append(uint64Key(uint64(key.RoomID)), key.ID...)
+}
+
+func reactionPrefix(name string) []byte {
+	prefix := make([]byte, 2, 2+len(name)+16)
+	binary.BigEndian.PutUint16(prefix, uint16(len(name)))
+	return append(prefix, name...)
+}
+
+func reactionKey(key ReactionKey) []byte {
+	return append(reactionPrefix(key.UserName), messageKey(key.MessageKey)...)
+}
+
+func docKey(id search.DocID) []byte {
+	return messageKey(MessageKey{RoomID: id.RoomID, Seq: id.Seq})
+}
+
+func postingPrefix(term string) []byte {
+	return append([]byte(term), 0)
+}
+
+func encodeGOB(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeGOB(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// loadResident reads the data kept in memory, i.e. everything but messages
+// and their indexes, into s.
+func loadResident(tx *bolt.Tx, s *Storage) error {
+	meta := tx.Bucket(metaBucket)
+	if v := meta.Get(indexKey); v != nil {
+		s.Index = binary.BigEndian.Uint64(v)
+	}
+	if v := meta.Get(secretKeyKey); v != nil {
+		s.SecretKey = append([]byte(nil), v...)
+	}
+	if v := meta.Get(nextRoomIDKey); v != nil {
+		s.NextRoomID = int(binary.BigEndian.Uint64(v))
+	}
+	if v := meta.Get(retentionKey); v != nil {
+		if err := decodeGOB(v, &s.Retention); err != nil {
+			return err
+		}
+	}
+	err := tx.Bucket(usersBucket).ForEach(func(_, v []byte) error {
+		user := &User{}
+		if err := decodeGOB(v, user); err != nil {
+			return err
+		}
+		s.Users[user.UserName] = user
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = tx.Bucket(roomsBucket).ForEach(func(_, v []byte) error {
+		room := &Room{}
+		if err := decodeGOB(v, room); err != nil {
+			return err
+		}
+		s.Rooms[room.ID] = room
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(attachmentsBucket).ForEach(func(_, v []byte) error {
+		attachment := &Attachment{}
+		if err := decodeGOB(v, attachment); err != nil {
+			return err
+		}
+		s.Attachments[attachment.ID] = attachment
+		return nil
+	})
+}
+
+// loadLegacyMessages reads the messages of a database of old versions into
+// their rooms, they are moved to the message store by rebuild.
+func loadLegacyMessages(tx *bolt.Tx, s *Storage) error {
+	return tx.Bucket(messagesBucket).ForEach(func(k, v []byte) error {
+		room, ok := s.Rooms[int(binary.BigEndian.Uint64(k[:8]))]
+		if !ok {
+			return nil
+		}
+		msg := &Message{}
+		if err := decodeGOB(v, msg); err != nil {
+			return err
+		}
+		room.Messages = append(room.Messages, msg)
+		return nil
+	})
+}
+
+// Load reads users, rooms and attachments into s, messages are read on
+// demand. A database of old versions is migrated to the current layout,
+// which reads all its messages into memory once.
+func (b *boltBackend) Load(s *Storage) error {
+	legacy := false
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if err := loadResident(tx, s); err != nil {
+			return err
+		}
+		if tx.Bucket(metaBucket).Get(schemaKey) == nil {
+			legacy = true
+			return loadLegacyMessages(tx, s)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.messages = b
+	s.rebuild()
+	if legacy {
+		return b.Reset(s)
+	}
+	return nil
+}
+
+func (b *boltBackend) message(key MessageKey) *Message {
+	if msg, ok := b.cache.get(key); ok {
+		return msg
+	}
+	var msg *Message
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(messagesBucket).Get(messageKey(key))
+		if v == nil {
+			return nil
+		}
+		msg = &Message{}
+		return decodeGOB(v, msg)
+	})
+	if err != nil {
+		panic(err)
+	}
+	if msg != nil {
+		b.cache.add(key, msg)
+	}
+	return msg
+}
+
+func (b *boltBackend) messageSeq(roomID int, id string) (uint64, bool) {
+	var seq uint64
+	var ok bool
+	b.mustView(func(tx *bolt.Tx) {
+		if v := tx.Bucket(idsBucket).Get(idKey(MessageIDKey{RoomID: roomID, ID: id})); v != nil {
+			seq, ok = binary.BigEndian.Uint64(v), true
+		}
+	})
+	return seq, ok
+}
+
+func (b *boltBackend) reactedMessages(name string) []MessageKey {
+	var keys []MessageKey
+	prefix := reactionPrefix(name)
+	b.mustView(func(tx *bolt.Tx) {
+		c := tx.Bucket(reactionsBucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			keys = append(keys, parseMessageKey(k[len(prefix):]))
+		}
+	})
+	return keys
+}
+
+func (b *boltBackend) search(query string) []search.Hit {
+	var hits []search.Hit
+	b.mustView(func(tx *bolt.Tx) {
+		hits = search.New(&boltWriter{tx: tx}).Search(query)
+	})
+	return hits
+}
+
+func (b *boltBackend) mustView(fn func(tx *bolt.Tx)) {
+	err := b.db.View(func(tx *bolt.Tx) error {
+		fn(tx)
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// boltWriter writes the changes of messages in a transaction, it is also
+// the store of the search index. The first failure is kept in err and
+// later writes are skipped.
+type boltWriter struct {
+	tx  *bolt.Tx
+	err error
+}
+
+func (w *boltWriter) put(bucket, k, v []byte) {
+	if w.err == nil {
+		w.err = w.tx.Bucket(bucket).Put(k, v)
+	}
+}
+
+func (w *boltWriter) delete(bucket, k []byte) {
+	if w.err == nil {
+		w.err = w.tx.Bucket(bucket).Delete(k)
+	}
+}
+
+// deletePrefix deletes the keys with the prefix.
+func (w *boltWriter) deletePrefix(bucket, prefix []byte) {
+	c := w.tx.Bucket(bucket).Cursor()
+	for k, _ := c.Seek(prefix); w.err == nil && k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Seek(prefix) {
+		w.err = c.Delete()
+	}
+}
+
+func (w *boltWriter) putGOB(bucket, k []byte, v interface{}) {
+	if w.err != nil {
+		return
+	}
+	data, err := encodeGOB(v)
+	if err != nil {
+		w.err = err
+		return
+	}
+	w.put(bucket, k, data)
+}
+
+func (w *boltWriter) putMessage(key MessageKey, msg *Message) {
+	w.putGOB(messagesBucket, messageKey(key), msg)
+}
+
+func (w *boltWriter) deleteMessage(key MessageKey) {
+	w.delete(messagesBucket, messageKey(key))
+}
+
+func (w *boltWriter) putID(key MessageIDKey, seq uint64) {
+	w.put(idsBucket, idKey(key), uint64Key(seq))
+}
+
+func (w *boltWriter) deleteID(key MessageIDKey) {
+	w.delete(idsBucket, idKey(key))
+}
+
+func (w *boltWriter) putReaction(key ReactionKey) {
+	w.put(reactionsBucket, reactionKey(key), nil)
+}
+
+func (w *boltWriter) deleteReaction(key ReactionKey) {
+	w.delete(reactionsBucket, reactionKey(key))
+}
+
+func (w *boltWriter) deleteRoom(roomID int) {
+	prefix := uint64Key(uint64(roomID))
+	w.deletePrefix(messagesBucket, prefix)
+	w.deletePrefix(idsBucket, prefix)
+}
+
+func (w *boltWriter) searchIndex() *search.Index {
+	return search.New(w)
+}
+
+func (w *boltWriter) Doc(id search.DocID) *search.Doc {
+	v := w.tx.Bucket(docsBucket).Get(docKey(id))
+	if v == nil {
+		return nil
+	}
+	d := &search.Doc{}
+	if err := decodeGOB(v, d); err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func (w *boltWriter) PutDoc(id search.DocID, d *search.Doc) {
+	w.putGOB(docsBucket, docKey(id), d)
+}
+
+func (w *boltWriter) DeleteDoc(id search.DocID) {
+	w.delete(docsBucket, docKey(id))
+}
+
+func (w *boltWriter) RoomDocs(roomID int) []search.DocID {
+	var ids []search.DocID
+	prefix := uint64Key(uint64(roomID))
+	c := w.tx.Bucket(docsBucket).Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		key := parseMessageKey(k)
+		ids = append(ids, search.DocID{RoomID: key.RoomID, Seq: key.Seq})
+	}
+	return ids
+}
+
+func (w *boltWriter) AddPosting(term string, id search.DocID) {
+	w.put(postingsBucket, append(postingPrefix(term), docKey(id)...), nil)
+	w.put(termsBucket, []byte(term), uint64Key(uint64(w.DocFreq(term)+1)))
+}
+
+func (w *boltWriter) DeletePosting(term string, id search.DocID) {
+	w.delete(postingsBucket, append(postingPrefix(term), docKey(id)...))
+	if df := w.DocFreq(term); df > 1 {
+		w.put(termsBucket, []byte(term), uint64Key(uint64(df-1)))
+	} else {
+		w.delete(termsBucket, []byte(term))
+	}
+}
+
+func (w *boltWriter) Postings(term string) []search.DocID {
+	var ids []search.DocID
+	prefix := postingPrefix(term)
+	c := w.tx.Bucket(postingsBucket).Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		key := parseMessageKey(k[len(prefix):])
+		ids = append(ids, search.DocID{RoomID: key.RoomID, Seq: key.Seq})
+	}
+	return ids
+}
+
+func (w *boltWriter) DocFreq(term string) int {
+	if v := w.tx.Bucket(termsBucket).Get([]byte(term)); v != nil {
+		return int(binary.BigEndian.Uint64(v))
+	}
+	return 0
+}
+
+func (w *boltWriter) Stats() (int, int) {
+	v := w.tx.Bucket(metaBucket).Get(searchStatsKey)
+	if v == nil {
+		return 0, 0
+	}
+	return int(binary.BigEndian.Uint64(v[:8])), int(binary.BigEndian.Uint64(v[8:]))
+}
+
+func (w *boltWriter) SetStats(docs, totalLength int) {
+	w.put(metaBucket, searchStatsKey, append(uint64Key(uint64(docs)), uint64Key(uint64(totalLength))...))
+}
+
+// putMeta writes the fields of the meta bucket besides the applied index.
+func (w *boltWriter) putMeta(s *Storage) {
+	w.put(metaBucket, secretKeyKey, s.SecretKey)
+	w.put(metaBucket, nextRoomIDKey, uint64Key(uint64(s.NextRoomID)))
+	w.putGOB(metaBucket, retentionKey, &s.Retention)
+}
+
+func (w *boltWriter) putUser(user *User) {
+	w.putGOB(usersBucket, []byte(user.UserName), user)
+}
+
+func (w *boltWriter) putRoom(room *Room) {
+	w.putGOB(roomsBucket, uint64Key(uint64(room.ID)), room)
+}
+
+func (w *boltWriter) putAttachment(attachment *Attachment) {
+	w.putGOB(attachmentsBucket, []byte(attachment.ID), attachment)
+}
+
+// commit writes the changes and the applied index.
+func (w *boltWriter) commit(s *Storage, changes *Changes) error {
+	if changes.Meta {
+		w.putMeta(s)
+	}
+	for name := range changes.Users {
+		if user, ok := s.Users[name]; ok {
+			w.putUser(user)
+		} else {
+			w.delete(usersBucket, []byte(name))
+		}
+	}
+	for id := range changes.Rooms {
+		if room, ok := s.Rooms[id]; ok {
+			w.putRoom(room)
+		} else {
+			w.delete(roomsBucket, uint64Key(uint64(id)))
+		}
+	}
+	for id := range changes.Attachments {
+		w.putAttachment(s.Attachments[id])
+	}
+	commitMessages(w, s, changes)
+	w.put(metaBucket, indexKey, uint64Key(s.Index))
+	return w.err
+}
+
+// reset replaces everything in the database with s, whose messages are all
+// recorded as changes.
+func (w *boltWriter) reset(s *Storage, changes *Changes) error {
+	for _, name := range allBuckets {
+		if err := w.tx.DeleteBucket(name); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		if _, err := w.tx.CreateBucket(name); err != nil {
+			return err
+		}
+	}
+	w.put(metaBucket, schemaKey, uint64Key(schemaVersion))
+	w.putMeta(s)
+	for _, user := range s.Users {
+		w.putUser(user)
+	}
+	for _, room := range s.Rooms {
+		w.putRoom(room)
+	}
+	for _, attachment := range s.Attachments {
+		w.putAttachment(attachment)
+	}
+	commitMessages(w, s, changes)
+	w.put(metaBucket, indexKey, uint64Key(s.Index))
+	return w.err
+}
+
+func (b *boltBackend) Commit(s *Storage) error {
+	changes := s.TakeChanges()
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		return (&boltWriter{tx: tx}).commit(s, changes)
+	})
+	if err != nil {
+		b.cache.purge()
+		return err
+	}
+	b.updateCache(s, changes)
+	return nil
+}
+
+// updateCache caches the committed messages, which are likely to be read
+// soon, and drops the deleted ones.
+func (b *boltBackend) updateCache(s *Storage, changes *Changes) {
+	for key, msg := range changes.Messages {
+		if _, ok := s.Rooms[key.RoomID]; ok && msg != nil {
+			b.cache.add(key, msg)
+		} else {
+			b.cache.remove(key)
+		}
+	}
+	for id := range changes.Rooms {
+		if _, ok := s.Rooms[id]; !ok {
+			b.cache.removeRoom(id)
+		}
+	}
+}
+
+func (b *boltBackend) Reset(s *Storage) error {
+	changes := s.TakeChanges()
+	b.cache.purge()
+	s.messages = b
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return (&boltWriter{tx: tx}).reset(s, changes)
+	})
+}
+
+// Restore replaces the database with the one at path, which is moved.
+func (b *boltBackend) Restore(s *Storage, path string) error {
+	if err := b.db.Close(); err != nil {
+		return err
+	}
+	b.cache.purge()
+	if err := os.Rename(path, b.path); err != nil {
+		return err
+	}
+	db, err := openBoltDB(b.path)
+	if err != nil {
+		return err
+	}
+	b.db = db
+	return b.Load(s)
+}
+
+// Snapshot streams the database as of the latest commit from a read
+// transaction, which doesn't block later commits.
+func (b *boltBackend) Snapshot(*Storage) (io.ReadCloser, int64, uint64, error) {
+	tx, err := b.db.Begin(false)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	var index uint64
+	if v := tx.Bucket(metaBucket).Get(indexKey); v != nil {
+		index = binary.BigEndian.Uint64(v)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := tx.WriteTo(pw)
+		tx.Rollback()
+		pw.CloseWithError(err)
+	}()
+	return pr, tx.Size(), index, nil
+}
+
+func (b *boltBackend) Close() error {
+	return b.db.Close()
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func reopen(t *testing.T, b Backend, dir string) (Backend, *Storage) {
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := OpenBackend(BackendBolt, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewStorage()
+	if err := b.Load(s); err != nil {
+		t.Fatal(err)
+	}
+	return b, s
+}
+
+func TestBoltBackend(t *testing.T) {
+	dir := t.TempDir()
+	b, err := OpenBackend(BackendBolt, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewStorage()
+	if err := b.Load(s); err != nil {
+		t.Fatal(err)
+	}
+	mustExecute(t, s, InternalRaftCommand{InitSecretKey: &InitSecretKeyCommand{SecretKey: []byte("secret")}})
+	mustExecute(t, s, InternalRaftCommand{CreateUser: &CreateUserCommand{UserName: "alice"}})
+	roomID := mustExecute(t, s, InternalRaftCommand{CreateRoom: &CreateRoomCommand{Name: "room"}}).(int)
+	mustExecute(t, s, InternalRaftCommand{EnterRoom: &EnterRoomCommand{UserName: "alice", RoomID: roomID}})
+	s.Index = 4
+	if err := b.Commit(s); err != nil {
+		t.Fatal(err)
+	}
+	for i, text := range []string{"hello", "world"} {
+		mustExecute(t, s, InternalRaftCommand{SendMessage: &SendMessageCommand{UserName: "alice", Text: text}})
+		s.Index = uint64(5 + i)
+		if err := b.Commit(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b, s2 := reopen(t, b, dir)
+	if !reflect.DeepEqual(snapshotOf(s), snapshotOf(s2)) {
+		t.Fatalf("loaded storage mismatch, expect %+v, got %+v", s.Snapshot, s2.Snapshot)
+	}
+	if s2.NextRoomID != roomID+1 || len(s2.RoomList) != 1 {
+		t.Fatalf("derived fields are not rebuilt: %+v", s2)
+	}
+	if s2.Rooms[roomID].NextSeq != 3 {
+		t.Fatalf("expect next seq 3, got %d", s2.Rooms[roomID].NextSeq)
+	}
+
+	if msg := s2.Rooms[roomID].MessageBySeq(2); msg == nil || msg.Text != "world" {
+		t.Fatalf("messages should be read from the database: %+v", msg)
+	}
+	if hits := s2.Search("hello"); len(hits) != 1 || hits[0].ID.Seq != 1 {
+		t.Fatalf("unexpected search hits %v", hits)
+	}
+
+	// The database streamed by Snapshot restores the same state.
+	r, size, index, err := b.Snapshot(s2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "snapshot.db")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := io.Copy(f, r); err != nil || n != size {
+		t.Fatalf("failed to copy snapshot of %d bytes: %d %v", size, n, err)
+	}
+	f.Close()
+	r.Close()
+	if index != s2.Index {
+		t.Fatalf("expect snapshot at %d, got %d", s2.Index, index)
+	}
+	restoreDir := t.TempDir()
+	rb, err := OpenBackend(BackendBolt, restoreDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewStorage()
+	if err := rb.Restore(restored, path); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(snapshotOf(s2), snapshotOf(restored)) {
+		t.Fatalf("restored storage mismatch, expect %+v, got %+v", s2.Snapshot, restored.Snapshot)
+	}
+	if msg := restored.Rooms[roomID].MessageBySeq(1); msg == nil || msg.Text != "hello" {
+		t.Fatalf("messages should be restored: %+v", msg)
+	}
+	rb.Close()
+
+	// Reset replaces everything persisted before.
+	s3 := NewStorage()
+	s3.Index = 10
+	mustExecute(t, s3, InternalRaftCommand{CreateUser: &CreateUserCommand{UserName: "bob"}})
+	if err := b.Reset(s3); err != nil {
+		t.Fatal(err)
+	}
+	b, s4 := reopen(t, b, dir)
+	defer b.Close()
+	if !reflect.DeepEqual(snapshotOf(s3), snapshotOf(s4)) {
+		t.Fatalf("reset storage mismatch, expect %+v, got %+v", s3.Snapshot, s4.Snapshot)
+	}
+}
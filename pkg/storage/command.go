@@ -11,6 +11,9 @@ var (
 	ErrUserAlreadyExists = errors.New("user already exists")
 	ErrRoomNotExists     = errors.New("room not exists")
 	ErrUserOutOfRoom     = errors.New("user out of room")
+	ErrRoomArchived      = errors.New("room is archived")
+	ErrPasswordWrong     = errors.New("password is wrong")
+	ErrNotRoomMember     = errors.New("user is not a member of the room")
 )
 
 type Command interface {
@@ -29,6 +32,7 @@ type InitSecretKeyCommand struct {
 func (c *InitSecretKeyCommand) Execute(s *Storage) *ExecuteResult {
 	if len(s.SecretKey) == 0 {
 		s.SecretKey = append(s.SecretKey, c.SecretKey...)
+		s.touchMeta()
 	}
 	return &ExecuteResult{Result: append([]byte(nil), s.SecretKey...)}
 }
@@ -47,29 +51,122 @@ func (c *CreateUserCommand) Execute(s *Storage) *ExecuteResult {
 		return &ExecuteResult{Err: ErrUserAlreadyExists}
 	}
 	s.Users[c.UserName] = &User{
-		UserName:  c.UserName,
-		FirstName: c.FirstName,
-		LastName:  c.LastName,
-		Email:     c.Email,
-		Password:  c.Password,
-		Phone:     c.Phone,
-		RoomID:    0,
+		UserName:   c.UserName,
+		FirstName:  c.FirstName,
+		LastName:   c.LastName,
+		Email:      c.Email,
+		Password:   c.Password,
+		Phone:      c.Phone,
+		RoomID:     0,
+		TokenEpoch: s.Index,
 	}
+	s.touchUser(c.UserName)
 	return &ExecuteResult{}
 }
 
+// UpdateUserCommand updates the profile of a user, nil fields are left
+// unchanged.
+type UpdateUserCommand struct {
+	UserName  string
+	FirstName *string
+	LastName  *string
+	Email     *string
+	Phone     *string
+}
+
+func (c *UpdateUserCommand) Execute(s *Storage) *ExecuteResult {
+	user, ok := s.Users[c.UserName]
+	if !ok {
+		return &ExecuteResult{Err: ErrUserNotExists}
+	}
+	if c.FirstName != nil {
+		user.FirstName = *c.FirstName
+	}
+	if c.LastName != nil {
+		user.LastName = *c.LastName
+	}
+	if c.Email != nil {
+		user.Email = *c.Email
+	}
+	if c.Phone != nil {
+		user.Phone = *c.Phone
+	}
+	s.touchUser(user.UserName)
+	return &ExecuteResult{}
+}
+
+// ChangePasswordCommand changes the password of a user if the old password
+// is right, and revokes all tokens of the user. The result is the new token
+// epoch.
+type ChangePasswordCommand struct {
+	UserName    string
+	OldPassword string
+	NewPassword string
+}
+
+func (c *ChangePasswordCommand) Execute(s *Storage) *ExecuteResult {
+	user, ok := s.Users[c.UserName]
+	if !ok {
+		return &ExecuteResult{Err: ErrUserNotExists}
+	}
+	if user.Password != c.OldPassword {
+		return &ExecuteResult{Err: ErrPasswordWrong}
+	}
+	user.Password = c.NewPassword
+	user.TokenEpoch = s.Index
+	s.touchUser(user.UserName)
+	return &ExecuteResult{Result: user.TokenEpoch}
+}
+
+// DeleteUserCommand deletes a user and moves it out of its rooms and direct
+// conversations. Tokens of the user are revoked,
+// even if a user with the same name is created later.
+type DeleteUserCommand struct {
+	UserName string
+}
+
+func (c *DeleteUserCommand) Execute(s *Storage) *ExecuteResult {
+	user, ok := s.Users[c.UserName]
+	if !ok {
+		return &ExecuteResult{Err: ErrUserNotExists}
+	}
+	for _, id := range user.Rooms {
+		if room, ok := s.Rooms[id]; ok {
+			room.Users = removeUser(room.Users, user.UserName)
+			s.touchRoom(room.ID)
+		}
+	}
+	s.deleteDirects(user.UserName)
+	s.forgetUser(user.UserName)
+	delete(s.Users, user.UserName)
+	s.touchUser(user.UserName)
+	return &ExecuteResult{}
+}
+
+// CreateRoomCommand creates a room owned by Owner, the owner joins the room
+// if it is private.
 type CreateRoomCommand struct {
-	Name string
+	Name    string
+	Owner   string
+	Private bool
 }
 
 func (c *CreateRoomCommand) Execute(s *Storage) *ExecuteResult {
 	room := &Room{
-		ID:   s.NextRoomID,
-		Name: c.Name,
+		ID:      s.NextRoomID,
+		Name:    c.Name,
+		NextSeq: 1,
+		Owner:   c.Owner,
+		Private: c.Private,
 	}
 	s.NextRoomID++
-	s.Rooms[room.ID] = room
+	s.addRoom(room)
 	s.RoomList = append(s.RoomList, room)
+	s.touchMeta()
+	s.touchRoom(room.ID)
+	if owner, ok := s.Users[c.Owner]; ok && room.Private {
+		s.joinRoom(owner, room)
+	}
 	return &ExecuteResult{Result: room.ID}
 }
 
@@ -84,9 +181,13 @@ func removeUser(users []string, user string) []string {
 	return users[:j]
 }
 
+// EnterRoomCommand makes the room the current room of the user, the user
+// leaves its previous current room. TS is given by the proposer to check
+// bans of the room.
 type EnterRoomCommand struct {
 	UserName string
 	RoomID   int
+	TS       int
 }
 
 func (c *EnterRoomCommand) Execute(s *Storage) *ExecuteResult {
@@ -94,24 +195,53 @@ func (c *EnterRoomCommand) Execute(s *Storage) *ExecuteResult {
 	if !ok {
 		return &ExecuteResult{Err: ErrUserNotExists}
 	}
-	if _, ok := s.Rooms[c.RoomID]; !ok {
+	room, ok := s.Room(c.RoomID)
+	if !ok {
 		return &ExecuteResult{Err: ErrRoomNotExists}
 	}
-	if user.RoomID > 0 {
-		if user.RoomID == c.RoomID {
-			return &ExecuteResult{}
-		}
-		room := s.Rooms[user.RoomID]
-		room.Users = removeUser(room.Users, user.UserName)
+	if user.RoomID == room.ID {
+		return &ExecuteResult{}
+	}
+	if err := s.checkJoin(user, room, c.TS); err != nil {
+		return &ExecuteResult{Err: err}
+	}
+	if prev, ok := s.Rooms[user.RoomID]; ok {
+		s.leaveRoom(user, prev)
 	}
-	user.RoomID = c.RoomID
-	room := s.Rooms[user.RoomID]
-	room.Users = append(room.Users, user.UserName)
+	s.joinRoom(user, room)
+	user.RoomID = room.ID
 	return &ExecuteResult{}
 }
 
+// JoinRoomCommand adds the user to the members of the room without changing
+// its current room. TS is given by the proposer to check bans of the room.
+type JoinRoomCommand struct {
+	UserName string
+	RoomID   int
+	TS       int
+}
+
+func (c *JoinRoomCommand) Execute(s *Storage) *ExecuteResult {
+	user, ok := s.Users[c.UserName]
+	if !ok {
+		return &ExecuteResult{Err: ErrUserNotExists}
+	}
+	room, ok := s.Room(c.RoomID)
+	if !ok {
+		return &ExecuteResult{Err: ErrRoomNotExists}
+	}
+	if err := s.checkJoin(user, room, c.TS); err != nil {
+		return &ExecuteResult{Err: err}
+	}
+	s.joinRoom(user, room)
+	return &ExecuteResult{}
+}
+
+// LeaveRoomCommand removes the user from the members of the room, or from
+// its current room if RoomID is 0.
 type LeaveRoomCommand struct {
 	UserName string
+	RoomID   int
 }
 
 func (c *LeaveRoomCommand) Execute(s *Storage) *ExecuteResult {
@@ -119,20 +249,35 @@ func (c *LeaveRoomCommand) Execute(s *Storage) *ExecuteResult {
 	if !ok {
 		return &ExecuteResult{Err: ErrUserNotExists}
 	}
-	if user.RoomID <= 0 {
+	roomID := c.RoomID
+	if roomID == 0 {
+		roomID = user.RoomID
+	}
+	if !user.IsMember(roomID) {
 		return &ExecuteResult{}
 	}
-	room := s.Rooms[user.RoomID]
-	room.Users = removeUser(room.Users, user.UserName)
-	user.RoomID = 0
+	if room, ok := s.Rooms[roomID]; ok {
+		s.leaveRoom(user, room)
+	}
 	return &ExecuteResult{}
 }
 
+// SendMessageCommand sends a message to the room, or to the current room of
+// the user if RoomID is 0. The message replies to the message with the id
+// ReplyTo if it is not empty. Ids of messages must be unique in the room,
+// resending a message with the same id, author and text succeeds without
+// sending it again. Messages without id are allowed but can't be referred
+// to.
 type SendMessageCommand struct {
 	ID       string
 	TS       int
+	TSMilli  int64
 	Text     string
 	UserName string
+	RoomID   int
+	ReplyTo  string
+	// Attachments are the ids of uploaded attachments.
+	Attachments []string
 }
 
 func (c *SendMessageCommand) Execute(s *Storage) *ExecuteResult {
@@ -140,29 +285,241 @@ func (c *SendMessageCommand) Execute(s *Storage) *ExecuteResult {
 	if !ok {
 		return &ExecuteResult{Err: ErrUserNotExists}
 	}
-	if user.RoomID <= 0 {
-		return &ExecuteResult{Err: ErrUserOutOfRoom}
+	roomID := c.RoomID
+	if roomID == 0 {
+		if user.RoomID <= 0 {
+			return &ExecuteResult{Err: ErrUserOutOfRoom}
+		}
+		roomID = user.RoomID
+	} else if !user.IsMember(roomID) {
+		return &ExecuteResult{Err: ErrNotRoomMember}
+	}
+	room, ok := s.Rooms[roomID]
+	if !ok {
+		return &ExecuteResult{Err: ErrRoomNotExists}
+	}
+	if sent, err := room.isSent(c.ID, user.UserName, c.Text); err != nil {
+		return &ExecuteResult{Err: err}
+	} else if sent {
+		return &ExecuteResult{}
+	}
+	if room.Archived {
+		return &ExecuteResult{Err: ErrRoomArchived}
+	}
+	if room.IsMuted(user.UserName, c.TS) {
+		return &ExecuteResult{Err: ErrUserMuted}
+	}
+	if err := s.checkAttachments(c.Attachments); err != nil {
+		return &ExecuteResult{Err: err}
+	}
+	var replyTo uint64
+	if len(c.ReplyTo) > 0 {
+		root, err := room.threadRoot(c.ReplyTo)
+		if err != nil {
+			return &ExecuteResult{Err: err}
+		}
+		replyTo = root
+	}
+	msg := &Message{
+		Seq:         room.NextSeq,
+		ID:          c.ID,
+		TS:          c.TS,
+		TSMilli:     c.TSMilli,
+		Text:        c.Text,
+		Author:      user.UserName,
+		ReplyTo:     replyTo,
+		Attachments: c.Attachments,
+	}
+	room.NextSeq++
+	s.putMessage(room.ID, msg)
+	s.indexReply(room, msg)
+	s.indexID(room.ID, msg)
+	s.refAttachments(room.ID, msg.Attachments)
+	s.touchRoom(room.ID)
+	// Users have read the messages before their own.
+	s.markRead(user, room.ID, msg.Seq)
+	s.emit(EventMessage, room.ID, msg)
+	s.notifyMentions(room, msg)
+	return &ExecuteResult{}
+}
+
+// RenameRoomCommand renames the room, only the owner, moderators and admins
+// can do it. Rooms created by old versions have no owner, only admins can
+// manage them.
+type RenameRoomCommand struct {
+	UserName string
+	Admin    bool
+	RoomID   int
+	Name     string
+}
+
+func (c *RenameRoomCommand) Execute(s *Storage) *ExecuteResult {
+	room, ok := s.Room(c.RoomID)
+	if !ok {
+		return &ExecuteResult{Err: ErrRoomNotExists}
+	}
+	if !c.Admin && !room.CanModerate(c.UserName) {
+		return &ExecuteResult{Err: ErrPermissionDenied}
+	}
+	room.Name = c.Name
+	s.touchRoom(room.ID)
+	return &ExecuteResult{}
+}
+
+// ArchiveRoomCommand archives the room, or restores it if Archived is false.
+// Only the owner, moderators and admins can do it.
+type ArchiveRoomCommand struct {
+	UserName string
+	Admin    bool
+	RoomID   int
+	Archived bool
+}
+
+func (c *ArchiveRoomCommand) Execute(s *Storage) *ExecuteResult {
+	room, ok := s.Room(c.RoomID)
+	if !ok {
+		return &ExecuteResult{Err: ErrRoomNotExists}
+	}
+	if !c.Admin && !room.CanModerate(c.UserName) {
+		return &ExecuteResult{Err: ErrPermissionDenied}
+	}
+	if room.Archived == c.Archived {
+		return &ExecuteResult{}
+	}
+	room.Archived = c.Archived
+	if room.Archived {
+		s.unlistRoom(room)
+	} else {
+		s.listRoom(room)
 	}
-	room, ok := s.Rooms[user.RoomID]
+	s.touchRoom(room.ID)
+	return &ExecuteResult{}
+}
+
+// DeleteRoomCommand deletes the room with all its messages, members of the
+// room are moved out of it. Only the owner and admins can do it.
+type DeleteRoomCommand struct {
+	UserName string
+	Admin    bool
+	RoomID   int
+}
+
+func (c *DeleteRoomCommand) Execute(s *Storage) *ExecuteResult {
+	room, ok := s.Room(c.RoomID)
 	if !ok {
 		return &ExecuteResult{Err: ErrRoomNotExists}
 	}
-	room.Messages = append(room.Messages, &Message{
-		ID:   c.ID,
-		TS:   c.TS,
-		Text: c.Text,
-	})
+	if !c.Admin && (len(room.Owner) == 0 || room.Owner != c.UserName) {
+		return &ExecuteResult{Err: ErrPermissionDenied}
+	}
+	for _, name := range append([]string(nil), room.Users...) {
+		if user, ok := s.Users[name]; ok {
+			s.leaveRoom(user, room)
+		}
+	}
+	s.unrefRoomAttachments(room.ID)
+	delete(s.Rooms, room.ID)
+	s.unlistRoom(room)
+	s.touchRoom(room.ID)
 	return &ExecuteResult{}
 }
 
+// SetRetentionCommand sets the retention policy of a room, or the global one
+// if RoomID is 0. A nil Retention clears the policy of the room so that it
+// follows the global one again. Only admins can set the global policy, the
+// policy of a room can also be set by its owner and moderators.
+type SetRetentionCommand struct {
+	UserName  string
+	Admin     bool
+	RoomID    int
+	Retention *Retention
+}
+
+func (c *SetRetentionCommand) Execute(s *Storage) *ExecuteResult {
+	if c.RoomID == 0 {
+		if !c.Admin {
+			return &ExecuteResult{Err: ErrPermissionDenied}
+		}
+		if c.Retention != nil {
+			s.Retention = *c.Retention
+		} else {
+			s.Retention = Retention{}
+		}
+		s.touchMeta()
+		return &ExecuteResult{}
+	}
+	room, ok := s.Room(c.RoomID)
+	if !ok {
+		return &ExecuteResult{Err: ErrRoomNotExists}
+	}
+	if !c.Admin && !room.CanModerate(c.UserName) {
+		return &ExecuteResult{Err: ErrPermissionDenied}
+	}
+	room.Retention = c.Retention
+	s.touchRoom(room.ID)
+	return &ExecuteResult{}
+}
+
+// PruneMessagesCommand drops the messages which exceed the retention
+// policies. Now is given by the proposer so that every replica drops the
+// same messages.
+type PruneMessagesCommand struct {
+	Now int
+}
+
+func (c *PruneMessagesCommand) Execute(s *Storage) *ExecuteResult {
+	pruned := 0
+	for _, room := range s.Rooms {
+		n := s.expiredMessages(room, c.Now)
+		if n == 0 {
+			continue
+		}
+		for i := 0; i < n; i++ {
+			msg := room.MessageAt(i)
+			s.unindexID(room.ID, msg)
+			s.unindexReactions(room.ID, msg)
+			s.unrefAttachments(room.ID, msg.Attachments)
+			s.dropMessage(room.ID, msg.Seq)
+		}
+		room.FirstSeq += uint64(n)
+		s.touchRoom(room.ID)
+		pruned += n
+	}
+	return &ExecuteResult{Result: pruned}
+}
+
 type InternalRaftCommand struct {
-	ID            uint64
-	InitSecretKey *InitSecretKeyCommand
-	CreateUser    *CreateUserCommand
-	CreateRoom    *CreateRoomCommand
-	EnterRoom     *EnterRoomCommand
-	LeaveRoom     *LeaveRoomCommand
-	SendMessage   *SendMessageCommand
+	ID                uint64
+	InitSecretKey     *InitSecretKeyCommand
+	CreateUser        *CreateUserCommand
+	CreateRoom        *CreateRoomCommand
+	EnterRoom         *EnterRoomCommand
+	LeaveRoom         *LeaveRoomCommand
+	SendMessage       *SendMessageCommand
+	SetRetention      *SetRetentionCommand
+	PruneMessages     *PruneMessagesCommand
+	RenameRoom        *RenameRoomCommand
+	ArchiveRoom       *ArchiveRoomCommand
+	DeleteRoom        *DeleteRoomCommand
+	JoinRoom          *JoinRoomCommand
+	UpdateUser        *UpdateUserCommand
+	ChangePassword    *ChangePasswordCommand
+	DeleteUser        *DeleteUserCommand
+	SendDirectMessage *SendDirectMessageCommand
+	SetRoomVisibility *SetRoomVisibilityCommand
+	Invite            *InviteCommand
+	RequestJoin       *RequestJoinCommand
+	AnswerJoinRequest *AnswerJoinRequestCommand
+	SetModerator      *SetModeratorCommand
+	KickUser          *KickUserCommand
+	BanUser           *BanUserCommand
+	MuteUser          *MuteUserCommand
+	EditMessage       *EditMessageCommand
+	DeleteMessage     *DeleteMessageCommand
+	AddReaction       *AddReactionCommand
+	RemoveReaction    *RemoveReactionCommand
+	MarkRead          *MarkReadCommand
+	CreateAttachment  *CreateAttachmentCommand
 }
 
 func (c *InternalRaftCommand) Execute(s *Storage) *ExecuteResult {
@@ -180,6 +537,54 @@ func (c *InternalRaftCommand) Execute(s *Storage) *ExecuteResult {
 		result = c.LeaveRoom.Execute(s)
 	case c.SendMessage != nil:
 		result = c.SendMessage.Execute(s)
+	case c.SetRetention != nil:
+		result = c.SetRetention.Execute(s)
+	case c.PruneMessages != nil:
+		result = c.PruneMessages.Execute(s)
+	case c.RenameRoom != nil:
+		result = c.RenameRoom.Execute(s)
+	case c.ArchiveRoom != nil:
+		result = c.ArchiveRoom.Execute(s)
+	case c.DeleteRoom != nil:
+		result = c.DeleteRoom.Execute(s)
+	case c.JoinRoom != nil:
+		result = c.JoinRoom.Execute(s)
+	case c.UpdateUser != nil:
+		result = c.UpdateUser.Execute(s)
+	case c.ChangePassword != nil:
+		result = c.ChangePassword.Execute(s)
+	case c.DeleteUser != nil:
+		result = c.DeleteUser.Execute(s)
+	case c.SendDirectMessage != nil:
+		result = c.SendDirectMessage.Execute(s)
+	case c.SetRoomVisibility != nil:
+		result = c.SetRoomVisibility.Execute(s)
+	case c.Invite != nil:
+		result = c.Invite.Execute(s)
+	case c.RequestJoin != nil:
+		result = c.RequestJoin.Execute(s)
+	case c.AnswerJoinRequest != nil:
+		result = c.AnswerJoinRequest.Execute(s)
+	case c.SetModerator != nil:
+		result = c.SetModerator.Execute(s)
+	case c.KickUser != nil:
+		result = c.KickUser.Execute(s)
+	case c.BanUser != nil:
+		result = c.BanUser.Execute(s)
+	case c.MuteUser != nil:
+		result = c.MuteUser.Execute(s)
+	case c.EditMessage != nil:
+		result = c.EditMessage.Execute(s)
+	case c.DeleteMessage != nil:
+		result = c.DeleteMessage.Execute(s)
+	case c.AddReaction != nil:
+		result = c.AddReaction.Execute(s)
+	case c.RemoveReaction != nil:
+		result = c.RemoveReaction.Execute(s)
+	case c.MarkRead != nil:
+		result = c.MarkRead.Execute(s)
+	case c.CreateAttachment != nil:
+		result = c.CreateAttachment.Execute(s)
 	}
 	return result
 }
@@ -192,8 +597,12 @@ func (c *InternalRaftCommand) MustMarshalGOB() []byte {
 	return buf.Bytes()
 }
 
+func (c *InternalRaftCommand) UnmarshalGOB(data []byte) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(c)
+}
+
 func (c *InternalRaftCommand) MustUnmarshalGOB(data []byte) {
-	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(c); err != nil {
+	if err := c.UnmarshalGOB(data); err != nil {
 		panic(err)
 	}
 }
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRoomLifecycle(t *testing.T) {
+	s := NewStorage()
+	mustExecute(t, s, InternalRaftCommand{CreateUser: &CreateUserCommand{UserName: "alice"}})
+	for i := 0; i < 3; i++ {
+		mustExecute(t, s, InternalRaftCommand{CreateRoom: &CreateRoomCommand{Name: "room"}})
+	}
+	// Rooms without owner can only be managed by admins.
+	for _, cmd := range []InternalRaftCommand{
+		{RenameRoom: &RenameRoomCommand{UserName: "alice", RoomID: 1, Name: "lobby"}},
+		{ArchiveRoom: &ArchiveRoomCommand{UserName: "alice", RoomID: 1, Archived: true}},
+		{DeleteRoom: &DeleteRoomCommand{UserName: "alice", RoomID: 1}},
+	} {
+		if result := cmd.Execute(s); result.Err != ErrPermissionDenied {
+			t.Fatalf("expect %v, got %v", ErrPermissionDenied, result.Err)
+		}
+	}
+	mustExecute(t, s, InternalRaftCommand{RenameRoom: &RenameRoomCommand{Admin: true, RoomID: 1, Name: "lobby"}})
+	if name := s.Rooms[1].Name; name != "lobby" {
+		t.Fatalf("expect room renamed to lobby, got %s", name)
+	}
+
+	mustExecute(t, s, InternalRaftCommand{EnterRoom: &EnterRoomCommand{UserName: "alice", RoomID: 2}})
+	mustExecute(t, s, InternalRaftCommand{ArchiveRoom: &ArchiveRoomCommand{Admin: true, RoomID: 2, Archived: true}})
+	if len(s.RoomList) != 2 || s.RoomList[0].ID != 1 || s.RoomList[1].ID != 3 {
+		t.Fatalf("archived room should be hidden from the room list")
+	}
+	cmd := InternalRaftCommand{SendMessage: &SendMessageCommand{UserName: "alice"}}
+	if result := cmd.Execute(s); result.Err != ErrRoomArchived {
+		t.Fatalf("expect %v, got %v", ErrRoomArchived, result.Err)
+	}
+	mustExecute(t, s, InternalRaftCommand{ArchiveRoom: &ArchiveRoomCommand{Admin: true, RoomID: 2}})
+	if len(s.RoomList) != 3 || s.RoomList[1].ID != 2 {
+		t.Fatalf("restored room should be listed in order")
+	}
+
+	mustExecute(t, s, InternalRaftCommand{DeleteRoom: &DeleteRoomCommand{Admin: true, RoomID: 3}})
+	mustExecute(t, s, InternalRaftCommand{DeleteRoom: &DeleteRoomCommand{Admin: true, RoomID: 2}})
+	if s.Users["alice"].RoomID != 0 {
+		t.Fatal("members of the deleted room should be moved out")
+	}
+	if len(s.RoomList) != 1 || len(s.Rooms) != 1 {
+		t.Fatal("deleted rooms should be removed")
+	}
+
+	// Ids of deleted rooms are not reused even after recovering from a
+	// snapshot.
+	s2 := recoverStorage(t, s)
+	id := mustExecute(t, s2, InternalRaftCommand{CreateRoom: &CreateRoomCommand{Name: "room"}}).(int)
+	if id != 4 {
+		t.Fatalf("expect new room id 4, got %d", id)
+	}
+}
+
+func TestUserLifecycle(t *testing.T) {
+	s := NewStorage()
+	s.Index = 1
+	mustExecute(t, s, InternalRaftCommand{CreateUser: &CreateUserCommand{UserName: "alice", Password: "old", Email: "a@x"}})
+	mustExecute(t, s, InternalRaftCommand{CreateRoom: &CreateRoomCommand{Name: "room"}})
+	mustExecute(t, s, InternalRaftCommand{EnterRoom: &EnterRoomCommand{UserName: "alice", RoomID: 1}})
+	if epoch := s.Users["alice"].TokenEpoch; epoch != 1 {
+		t.Fatalf("expect token epoch 1, got %d", epoch)
+	}
+
+	phone := "123"
+	mustExecute(t, s, InternalRaftCommand{UpdateUser: &UpdateUserCommand{UserName: "alice", Phone: &phone}})
+	if user := s.Users["alice"]; user.Phone != phone || user.Email != "a@x" {
+		t.Fatalf("unexpected user after update: %+v", user)
+	}
+
+	s.Index = 5
+	cmd := InternalRaftCommand{ChangePassword: &ChangePasswordCommand{UserName: "alice", OldPassword: "bad", NewPassword: "new"}}
+	if result := cmd.Execute(s); result.Err != ErrPasswordWrong {
+		t.Fatalf("expect %v, got %v", ErrPasswordWrong, result.Err)
+	}
+	epoch := mustExecute(t, s, InternalRaftCommand{ChangePassword: &ChangePasswordCommand{
+		UserName: "alice", OldPassword: "old", NewPassword: "new",
+	}}).(uint64)
+	if user := s.Users["alice"]; epoch != 5 || user.TokenEpoch != 5 || user.Password != "new" {
+		t.Fatalf("unexpected user after changing password: %+v", user)
+	}
+
+	mustExecute(t, s, InternalRaftCommand{DeleteUser: &DeleteUserCommand{UserName: "alice"}})
+	if _, ok := s.Users["alice"]; ok {
+		t.Fatal("user should be deleted")
+	}
+	if len(s.Rooms[1].Users) != 0 {
+		t.Fatal("deleted user should be removed from its room")
+	}
+}
+
+func TestMultipleRooms(t *testing.T) {
+	s := NewStorage()
+	mustExecute(t, s, InternalRaftCommand{CreateUser: &CreateUserCommand{UserName: "alice"}})
+	for i := 0; i < 3; i++ {
+		mustExecute(t, s, InternalRaftCommand{CreateRoom: &CreateRoomCommand{Name: "room"}})
+	}
+	mustExecute(t, s, InternalRaftCommand{JoinRoom: &JoinRoomCommand{UserName: "alice", RoomID: 3}})
+	mustExecute(t, s, InternalRaftCommand{EnterRoom: &EnterRoomCommand{UserName: "alice", RoomID: 1}})
+	user := s.Users["alice"]
+	if !reflect.DeepEqual(user.Rooms, []int{1, 3}) || user.RoomID != 1 {
+		t.Fatalf("unexpected memberships %v, current room %d", user.Rooms, user.RoomID)
+	}
+
+	// Entering another room leaves the current room only.
+	mustExecute(t, s, InternalRaftCommand{EnterRoom: &EnterRoomCommand{UserName: "alice", RoomID: 2}})
+	if !reflect.DeepEqual(user.Rooms, []int{2, 3}) || user.RoomID != 2 {
+		t.Fatalf("unexpected memberships %v, current room %d", user.Rooms, user.RoomID)
+	}
+
+	mustExecute(t, s, InternalRaftCommand{SendMessage: &SendMessageCommand{UserName: "alice", RoomID: 3, Text: "a"}})
+	mustExecute(t, s, InternalRaftCommand{SendMessage: &SendMessageCommand{UserName: "alice", Text: "b"}})
+	if s.Rooms[3].MessageCount() != 1 || s.Rooms[2].MessageCount() != 1 {
+		t.Fatal("messages should be sent to the given room or the current room")
+	}
+	cmd := InternalRaftCommand{SendMessage: &SendMessageCommand{UserName: "alice", RoomID: 1}}
+	if result := cmd.Execute(s); result.Err != ErrNotRoomMember {
+		t.Fatalf("expect %v, got %v", ErrNotRoomMember, result.Err)
+	}
+
+	mustExecute(t, s, InternalRaftCommand{LeaveRoom: &LeaveRoomCommand{UserName: "alice", RoomID: 3}})
+	mustExecute(t, s, InternalRaftCommand{LeaveRoom: &LeaveRoomCommand{UserName: "alice"}})
+	if len(user.Rooms) != 0 || user.RoomID != 0 || len(s.Rooms[2].Users) != 0 || len(s.Rooms[3].Users) != 0 {
+		t.Fatalf("unexpected memberships %v, current room %d", user.Rooms, user.RoomID)
+	}
+}
+
+func TestRecreateDeletedUser(t *testing.T) {
+	s, id := newTestRoom(t, CreateRoomCommand{Name: "room", Owner: "alice"}, "bob")
+	mustExecute(t, s, InternalRaftCommand{SetRoomVisibility: &SetRoomVisibilityCommand{UserName: "alice", RoomID: id, Private: true}})
+	mustExecute(t, s, InternalRaftCommand{SetModerator: &SetModeratorCommand{UserName: "alice", RoomID: id, Target: "bob", Moderator: true}})
+	mustExecute(t, s, InternalRaftCommand{DeleteUser: &DeleteUserCommand{UserName: "alice"}})
+	mustExecute(t, s, InternalRaftCommand{DeleteUser: &DeleteUserCommand{UserName: "bob"}})
+	mustExecute(t, s, InternalRaftCommand{CreateUser: &CreateUserCommand{UserName: "alice"}})
+	mustExecute(t, s, InternalRaftCommand{CreateUser: &CreateUserCommand{UserName: "bob"}})
+
+	room := s.Rooms[id]
+	if len(room.Owner) != 0 || len(room.Moderators) != 0 {
+		t.Fatalf("re-created users should not inherit roles, owner %q, moderators %v", room.Owner, room.Moderators)
+	}
+	if room.CanModerate("alice") || room.CanModerate("bob") {
+		t.Fatal("re-created users should not moderate the room")
+	}
+	for _, cmd := range []InternalRaftCommand{
+		{EnterRoom: &EnterRoomCommand{UserName: "alice", RoomID: id}},
+		{RenameRoom: &RenameRoomCommand{UserName: "alice", RoomID: id, Name: "mine"}},
+		{DeleteRoom: &DeleteRoomCommand{UserName: "alice", RoomID: id}},
+	} {
+		if result := cmd.Execute(s); result.Err == nil {
+			t.Fatalf("re-created user should not be able to run %+v", cmd)
+		}
+	}
+	mustExecute(t, s, InternalRaftCommand{DeleteRoom: &DeleteRoomCommand{RoomID: id, Admin: true}})
+}
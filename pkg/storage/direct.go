@@ -0,0 +1,189 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// MaxDirectParticipants limits the number of participants of a direct
+// conversation.
+const MaxDirectParticipants = 8
+
+var ErrInvalidParticipants = errors.New("direct conversation needs 2 to 8 participants")
+
+// DirectParticipants returns the sorted and deduplicated participants.
+func DirectParticipants(names []string) []string {
+	participants := append([]string(nil), names...)
+	sort.Strings(participants)
+	j := 0
+	for i, name := range participants {
+		if i == 0 || name != participants[j-1] {
+			participants[j] = name
+			j++
+		}
+	}
+	return participants[:j]
+}
+
+// DirectID returns the id of the direct conversation between the
+// participants, it doesn't depend on the order of them.
+func DirectID(names []string) string {
+	sum := sha256.Sum256([]byte(strings.Join(DirectParticipants(names), "\x00")))
+	return "dm-" + hex.EncodeToString(sum[:16])
+}
+
+// Room returns the chat room with the id, direct conversations are not
+// visible as chat rooms.
+func (s *Storage) Room(id int) (*Room, bool) {
+	room, ok := s.Rooms[id]
+	if !ok || room.Direct {
+		return nil, false
+	}
+	return room, true
+}
+
+// Direct returns the direct conversation between the participants.
+func (s *Storage) Direct(names []string) (*Room, bool) {
+	id, ok := s.directs[DirectID(names)]
+	if !ok {
+		return nil, false
+	}
+	room, ok := s.Rooms[id]
+	return room, ok
+}
+
+// SendDirectMessageCommand sends a message to the direct conversation
+// between the sender and the participants, the conversation is created on
+// the first message. Like in rooms, resending a message with the same id
+// succeeds without sending it again. The result is the id of the
+// conversation.
+type SendDirectMessageCommand struct {
+	ID           string
+	TS           int
+	TSMilli      int64
+	Text         string
+	UserName     string
+	Participants []string
+}
+
+func (c *SendDirectMessageCommand) Execute(s *Storage) *ExecuteResult {
+	participants := DirectParticipants(append([]string{c.UserName}, c.Participants...))
+	if len(participants) < 2 || len(participants) > MaxDirectParticipants {
+		return &ExecuteResult{Err: ErrInvalidParticipants}
+	}
+	for _, name := range participants {
+		if _, ok := s.Users[name]; !ok {
+			return &ExecuteResult{Err: ErrUserNotExists}
+		}
+	}
+	directID := DirectID(participants)
+	room, ok := s.Direct(participants)
+	if !ok {
+		room = &Room{
+			ID:      s.NextRoomID,
+			Users:   participants,
+			NextSeq: 1,
+			Direct:  true,
+		}
+		s.NextRoomID++
+		s.addRoom(room)
+		s.directs[directID] = room.ID
+		s.touchMeta()
+	} else if sent, err := room.isSent(c.ID, c.UserName, c.Text); err != nil {
+		return &ExecuteResult{Err: err}
+	} else if sent {
+		return &ExecuteResult{Result: directID}
+	}
+	msg := &Message{
+		Seq:     room.NextSeq,
+		ID:      c.ID,
+		TS:      c.TS,
+		TSMilli: c.TSMilli,
+		Text:    c.Text,
+		Author:  c.UserName,
+	}
+	room.NextSeq++
+	s.putMessage(room.ID, msg)
+	s.indexID(room.ID, msg)
+	s.touchRoom(room.ID)
+	s.emit(EventMessage, room.ID, msg)
+	return &ExecuteResult{Result: directID}
+}
+
+// DirectTombstone returns the latest archived direct conversation between
+// the participants, deleted ones included, which the user still takes part
+// in.
+func (s *Storage) DirectTombstone(names []string, user string) (*Room, bool) {
+	id, ok := s.tombstones[DirectID(names)]
+	if !ok {
+		return nil, false
+	}
+	room, ok := s.Rooms[id]
+	if !ok || !containsName(room.Users, user) {
+		return nil, false
+	}
+	return room, true
+}
+
+// deleteDirects removes the user from its direct conversations. A group
+// conversation goes on between the other participants unless they already
+// have one. Otherwise, as well as for conversations between two users, it is
+// archived as a tombstone which keeps its messages readable by the other
+// participants, but not by a user created later with the same name.
+// Tombstones without participants are deleted.
+func (s *Storage) deleteDirects(name string) {
+	var rooms []*Room
+	for _, room := range s.Rooms {
+		if room.Direct && containsName(room.Users, name) {
+			rooms = append(rooms, room)
+		}
+	}
+	// The order decides which conversation takes over the participants of
+	// others, it must be the same on all members.
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].ID < rooms[j].ID
+	})
+	for _, room := range rooms {
+		if !room.Archived {
+			delete(s.directs, DirectID(room.Users))
+		}
+		room.Users = removeUser(room.Users, name)
+		room.FormerUsers = insertName(room.FormerUsers, name)
+		s.touchRoom(room.ID)
+		if len(room.Users) == 0 {
+			if room.Archived {
+				s.unindexTombstone(room)
+			}
+			s.unrefRoomAttachments(room.ID)
+			delete(s.Rooms, room.ID)
+			continue
+		}
+		if !room.Archived {
+			if _, exists := s.directs[DirectID(room.Users)]; !exists && len(room.Users) >= 2 {
+				s.directs[DirectID(room.Users)] = room.ID
+				continue
+			}
+			room.Archived = true
+		}
+		s.indexTombstone(room)
+	}
+}
+
+// indexTombstone makes the archived direct conversation the tombstone of its
+// participants unless there is a later one.
+func (s *Storage) indexTombstone(room *Room) {
+	id := DirectID(append(append([]string(nil), room.Users...), room.FormerUsers...))
+	if room.ID > s.tombstones[id] {
+		s.tombstones[id] = room.ID
+	}
+}
+
+func (s *Storage) unindexTombstone(room *Room) {
+	id := DirectID(append(append([]string(nil), room.Users...), room.FormerUsers...))
+	if s.tombstones[id] == room.ID {
+		delete(s.tombstones, id)
+	}
+}
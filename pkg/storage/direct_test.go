@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestDirectMessages(t *testing.T) {
+	s := newTestStorage(t)
+	id := mustExecute(t, s, InternalRaftCommand{SendDirectMessage: &SendDirectMessageCommand{
+		UserName: "alice", Participants: []string{"bob"}, Text: "hi",
+	}}).(string)
+	id2 := mustExecute(t, s, InternalRaftCommand{SendDirectMessage: &SendDirectMessageCommand{
+		UserName: "bob", Participants: []string{"alice", "bob"}, Text: "hello",
+	}}).(string)
+	if id != id2 || id != DirectID([]string{"bob", "alice"}) {
+		t.Fatalf("both sides should resolve to the same conversation, got %s and %s", id, id2)
+	}
+	room, ok := s.Direct([]string{"alice", "bob"})
+	if !ok || room.MessageCount() != 2 || room.MessageAt(1).Author != "bob" {
+		t.Fatal("unexpected messages of the conversation")
+	}
+	retry := InternalRaftCommand{SendDirectMessage: &SendDirectMessageCommand{
+		ID: "m", UserName: "alice", Participants: []string{"bob"}, Text: "again",
+	}}
+	mustExecute(t, s, retry)
+	if result := retry.Execute(s); result.Err != nil || result.Result.(string) != id || room.MessageCount() != 3 {
+		t.Fatalf("retry should succeed without sending again, got %+v", result)
+	}
+	retry.SendDirectMessage.Text = "other"
+	if result := retry.Execute(s); result.Err != ErrMessageExists {
+		t.Fatalf("expect %v, got %v", ErrMessageExists, result.Err)
+	}
+	if _, ok := s.Room(room.ID); ok || len(s.RoomList) != 0 {
+		t.Fatal("direct conversations should not be visible as rooms")
+	}
+	if _, ok := s.Direct([]string{"alice", "bob", "carol"}); ok {
+		t.Fatal("group conversation should not exist")
+	}
+	for _, participants := range [][]string{{"alice"}, {"nobody"}} {
+		cmd := InternalRaftCommand{SendDirectMessage: &SendDirectMessageCommand{UserName: "alice", Participants: participants}}
+		if result := cmd.Execute(s); result.Err == nil {
+			t.Fatalf("expect error for participants %v", participants)
+		}
+	}
+
+	// Conversations survive recovering from a snapshot.
+	s2 := recoverStorage(t, s)
+	if _, ok := s2.Direct([]string{"alice", "bob"}); !ok {
+		t.Fatal("conversation should be recovered")
+	}
+}
+
+func TestDeleteDirectParticipant(t *testing.T) {
+	s := newTestStorage(t)
+	mustExecute(t, s, InternalRaftCommand{CreateUser: &CreateUserCommand{UserName: "dave"}})
+	send := func(from string, to ...string) {
+		mustExecute(t, s, InternalRaftCommand{SendDirectMessage: &SendDirectMessageCommand{
+			UserName: from, Participants: to, Text: "hi",
+		}})
+	}
+	send("alice", "bob")
+	send("alice", "bob", "carol")
+	send("alice", "carol", "dave")
+	send("alice", "dave")
+	group, _ := s.Direct([]string{"alice", "carol", "dave"})
+	pair, _ := s.Direct([]string{"alice", "dave"})
+	mustExecute(t, s, InternalRaftCommand{DeleteUser: &DeleteUserCommand{UserName: "bob"}})
+
+	// The group conversation goes on without bob, the one between two
+	// users is left as a tombstone readable by alice only.
+	room, ok := s.Direct([]string{"alice", "carol"})
+	if !ok || room.MessageCount() != 1 || len(room.Users) != 2 || room.Archived {
+		t.Fatal("group conversation should go on between the others")
+	}
+	if _, ok := s.Direct([]string{"alice", "bob"}); ok {
+		t.Fatal("conversation with a deleted user should not be found")
+	}
+	tombstone, ok := s.DirectTombstone([]string{"alice", "bob"}, "alice")
+	if !ok || !tombstone.Archived || tombstone.MessageCount() != 1 {
+		t.Fatal("tombstone should be readable by alice")
+	}
+	mustExecute(t, s, InternalRaftCommand{CreateUser: &CreateUserCommand{UserName: "bob"}})
+	if _, ok := s.DirectTombstone([]string{"alice", "bob"}, "bob"); ok {
+		t.Fatal("tombstone should not be readable by a new user with the same name")
+	}
+	cmd := InternalRaftCommand{SendDirectMessage: &SendDirectMessageCommand{UserName: "bob", Participants: []string{"alice"}}}
+	if result := cmd.Execute(s); result.Err != nil || result.Result.(string) != DirectID([]string{"alice", "bob"}) {
+		t.Fatalf("new user should start a new conversation, got %+v", result)
+	}
+	if room, _ := s.Direct([]string{"alice", "bob"}); room.ID == tombstone.ID || room.MessageCount() != 1 {
+		t.Fatal("new conversation should not inherit the tombstone")
+	}
+
+	// The group without carol collides with the conversation between alice
+	// and dave, so it becomes a tombstone too.
+	mustExecute(t, s, InternalRaftCommand{DeleteUser: &DeleteUserCommand{UserName: "carol"}})
+	if room, ok := s.Direct([]string{"alice", "dave"}); !ok || room.ID != pair.ID {
+		t.Fatal("existing conversation should be kept")
+	}
+	if room, ok := s.DirectTombstone([]string{"alice", "carol", "dave"}, "dave"); !ok || room.ID != group.ID {
+		t.Fatal("group conversation should be a tombstone")
+	}
+
+	// Tombstones survive recovering from a snapshot and are deleted with
+	// their last participant.
+	s2 := recoverStorage(t, s)
+	if _, ok := s2.DirectTombstone([]string{"alice", "bob"}, "alice"); !ok {
+		t.Fatal("tombstone should be recovered")
+	}
+	mustExecute(t, s2, InternalRaftCommand{DeleteUser: &DeleteUserCommand{UserName: "alice"}})
+	if _, ok := s2.Rooms[tombstone.ID]; ok {
+		t.Fatal("tombstone without participants should be deleted")
+	}
+	if _, ok := s2.DirectTombstone([]string{"alice", "bob"}, "alice"); ok {
+		t.Fatal("deleted tombstone should not be found")
+	}
+}
@@ -0,0 +1,51 @@
+package storage
+
+// EventType is the type of a change pushed to real-time subscribers.
+type EventType string
+
+const (
+	EventMessage  EventType = "message"
+	EventEdit     EventType = "edit"
+	EventDelete   EventType = "delete"
+	EventReaction EventType = "reaction"
+	// EventMention is sent to the mentioned user instead of the room.
+	EventMention EventType = "mention"
+	// EventTyping is never emitted by commands, it is published by the
+	// members which receive the typing notifications.
+	EventTyping EventType = "typing"
+)
+
+// Event describes a change of a message in a room. Message is a copy of the
+// message after the change without its revisions, so it can be used after
+// the storage lock is released.
+type Event struct {
+	Type    EventType
+	RoomID  int
+	Message Message
+	// UserName and MentionSeq are the mentioned user and the seq of the
+	// mention for mention events, UserName is the typing user for typing
+	// events.
+	UserName   string
+	MentionSeq uint64
+}
+
+func (s *Storage) emit(typ EventType, roomID int, msg *Message) {
+	e := Event{Type: typ, RoomID: roomID, Message: *msg}
+	e.Message.Revisions = nil
+	e.Message.Reactions = copyReactions(msg.Reactions)
+	s.events = append(s.events, e)
+}
+
+func (s *Storage) emitMention(name string, seq uint64, roomID int, msg *Message) {
+	s.emit(EventMention, roomID, msg)
+	e := &s.events[len(s.events)-1]
+	e.UserName = name
+	e.MentionSeq = seq
+}
+
+// TakeEvents returns the events since the last call and resets them.
+func (s *Storage) TakeEvents() []Event {
+	events := s.events
+	s.events = nil
+	return events
+}
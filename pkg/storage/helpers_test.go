@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func mustExecute(t *testing.T, s *Storage, cmd InternalRaftCommand) interface{} {
+	t.Helper()
+	result := cmd.Execute(s)
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+	return result.Result
+}
+
+// newTestStorage returns a storage with the users alice, bob and carol.
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+	s := NewStorage()
+	for _, name := range []string{"alice", "bob", "carol"} {
+		mustExecute(t, s, InternalRaftCommand{CreateUser: &CreateUserCommand{UserName: name}})
+	}
+	return s
+}
+
+// newTestRoom returns a storage created by newTestStorage and the id of the
+// room created by the command, which the members have entered.
+func newTestRoom(t *testing.T, create CreateRoomCommand, members ...string) (*Storage, int) {
+	t.Helper()
+	s := newTestStorage(t)
+	id := mustExecute(t, s, InternalRaftCommand{CreateRoom: &create}).(int)
+	for _, name := range members {
+		mustExecute(t, s, InternalRaftCommand{EnterRoom: &EnterRoomCommand{UserName: name, RoomID: id}})
+	}
+	return s, id
+}
+
+// recoverStorage commits the changes of s and returns a storage recovered
+// from the snapshot of it, like a member receiving the snapshot does.
+func recoverStorage(t *testing.T, s *Storage) *Storage {
+	t.Helper()
+	var b memoryBackend
+	if err := b.Commit(s); err != nil {
+		t.Fatal(err)
+	}
+	r, _, _, err := b.Snapshot(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	path := filepath.Join(t.TempDir(), "snapshot.db")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		t.Fatal(err)
+	}
+	recovered := NewStorage()
+	if err := b.Restore(recovered, path); err != nil {
+		t.Fatal(err)
+	}
+	return recovered
+}
+
+// legacySnapshot returns a snapshot of s in the format of old versions,
+// which keep the messages in their rooms.
+func legacySnapshot(t *testing.T, s *Storage) []byte {
+	t.Helper()
+	snapshot := s.Snapshot
+	snapshot.Rooms = make(map[int]*Room)
+	for id, room := range s.Rooms {
+		r := *room
+		r.FirstSeq = 0
+		for i := 0; i < room.MessageCount(); i++ {
+			msg := *room.MessageAt(i)
+			msg.Replies = nil
+			r.Messages = append(r.Messages, &msg)
+		}
+		snapshot.Rooms[id] = &r
+	}
+	snapshot.Attachments = make(map[string]*Attachment)
+	for id, attachment := range s.Attachments {
+		a := *attachment
+		a.Rooms = nil
+		snapshot.Attachments[id] = &a
+	}
+	data, err := encodeGOB(&snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// snapshotOf returns the fields of s kept in memory, without the references
+// of rooms to s.
+func snapshotOf(s *Storage) Snapshot {
+	snapshot := s.Snapshot
+	snapshot.Rooms = make(map[int]*Room)
+	for id, room := range s.Rooms {
+		r := *room
+		r.storage = nil
+		snapshot.Rooms[id] = &r
+	}
+	return snapshot
+}
@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxMentions limits the number of mentions kept in the inbox of a user, the
+// oldest ones are dropped first.
+const MaxMentions = 1000
+
+// Mention refers to a message mentioning the user.
+type Mention struct {
+	// Seq increases with every mention of the user, it is used as the
+	// cursor of the inbox.
+	Seq        uint64
+	RoomID     int
+	MessageSeq uint64
+}
+
+// isMentionRune returns whether r can be part of a mentioned user name.
+func isMentionRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.'
+}
+
+// parseMentions returns the distinct names following '@' in the text in the
+// order they appear. '@' in the middle of a word, e.g. an email address, is
+// not a mention, and trailing dots are dropped as they usually end a
+// sentence.
+func parseMentions(text string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for i := 0; i < len(text); {
+		j := strings.IndexByte(text[i:], '@')
+		if j < 0 {
+			break
+		}
+		at := i + j
+		i = at + 1
+		if at > 0 {
+			if r, _ := utf8.DecodeLastRuneInString(text[:at]); isMentionRune(r) {
+				continue
+			}
+		}
+		end := strings.IndexFunc(text[i:], func(r rune) bool { return !isMentionRune(r) })
+		if end < 0 {
+			end = len(text) - i
+		}
+		name := strings.TrimRight(text[i:i+end], ".")
+		i += end
+		if len(name) > 0 && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// notifyMentions records the message in the inboxes of the existing users
+// mentioned by it who can access the room, except its author.
+func (s *Storage) notifyMentions(room *Room, msg *Message) {
+	for _, name := range parseMentions(msg.Text) {
+		user, ok := s.Users[name]
+		if !ok || name == msg.Author || !room.CanAccess(user) {
+			continue
+		}
+		var seq uint64 = 1
+		if n := len(user.Mentions); n > 0 {
+			seq = user.Mentions[n-1].Seq + 1
+		}
+		user.Mentions = append(user.Mentions, Mention{Seq: seq, RoomID: room.ID, MessageSeq: msg.Seq})
+		if n := len(user.Mentions); n > MaxMentions {
+			user.Mentions = append([]Mention(nil), user.Mentions[n-MaxMentions:]...)
+		}
+		s.touchUser(name)
+		s.emitMention(name, seq, room.ID, msg)
+	}
+}
+
+// MentionsBefore returns the mentions of the user with seq less than cursor
+// in ascending order of seq, or all of them if cursor is 0.
+func (user *User) MentionsBefore(cursor uint64) []Mention {
+	if cursor == 0 {
+		return user.Mentions
+	}
+	end := sort.Search(len(user.Mentions), func(i int) bool {
+		return user.Mentions[i].Seq >= cursor
+	})
+	return user.Mentions[:end]
+}
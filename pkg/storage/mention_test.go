@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	for text, expected := range map[string][]string{
+		"hi @bob":                 {"bob"},
+		"@bob, @carol and @bob.":  {"bob", "carol"},
+		"mail alice@example.com":  nil,
+		"@@bob @ @first.last...!": {"bob", "first.last"},
+		"(@bob)":                  {"bob"},
+	} {
+		if names := parseMentions(text); !reflect.DeepEqual(names, expected) {
+			t.Errorf("parseMentions(%q) = %v, expected %v", text, names, expected)
+		}
+	}
+}
+
+func TestMentions(t *testing.T) {
+	s, id := newTestRoom(t, CreateRoomCommand{Name: "lobby", Owner: "alice"}, "alice")
+	send := func(id, text string) {
+		mustExecute(t, s, InternalRaftCommand{SendMessage: &SendMessageCommand{ID: id, Text: text, UserName: "alice"}})
+	}
+	send("m1", "hi @bob and @dave, I'm @alice")
+	send("m2", "@bob @carol")
+
+	bob, carol := s.Users["bob"], s.Users["carol"]
+	expected := []Mention{{Seq: 1, RoomID: id, MessageSeq: 1}, {Seq: 2, RoomID: id, MessageSeq: 2}}
+	if !reflect.DeepEqual(bob.Mentions, expected) {
+		t.Fatalf("unexpected mentions: %+v", bob.Mentions)
+	}
+	if len(s.Users["alice"].Mentions) != 0 {
+		t.Fatal("authors should not be notified of their own mentions")
+	}
+	if mentions := bob.MentionsBefore(2); !reflect.DeepEqual(mentions, expected[:1]) {
+		t.Fatalf("unexpected mentions before 2: %+v", mentions)
+	}
+	var mentioned []string
+	for _, e := range s.TakeEvents() {
+		if e.Type == EventMention {
+			mentioned = append(mentioned, e.UserName)
+		}
+	}
+	if !reflect.DeepEqual(mentioned, []string{"bob", "bob", "carol"}) {
+		t.Fatalf("unexpected mention events: %v", mentioned)
+	}
+
+	// Users outside a private room are not notified.
+	mustExecute(t, s, InternalRaftCommand{SetRoomVisibility: &SetRoomVisibilityCommand{UserName: "alice", RoomID: id, Private: true}})
+	send("m3", "@carol")
+	if len(carol.Mentions) != 1 {
+		t.Fatalf("non-members of private rooms should not be notified: %+v", carol.Mentions)
+	}
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"errors"
+)
+
+var (
+	ErrMessageNotExists = errors.New("message not exists")
+	ErrMessageDeleted   = errors.New("message is deleted")
+	ErrMessageExists    = errors.New("message already exists")
+)
+
+// Revision is a previous text of an edited message.
+type Revision struct {
+	Text string
+	// TS is when the text was written.
+	TS int
+}
+
+// MessageByID returns the message with the id in the room, messages without
+// id can't be looked up. Ids are unique in a room except for rooms of old
+// versions, the latest message with the id is returned then.
+func (room *Room) MessageByID(id string) (*Message, bool) {
+	if len(id) == 0 {
+		return nil, false
+	}
+	seq, ok := room.storage.messageSeq(room.ID, id)
+	if !ok {
+		return nil, false
+	}
+	msg := room.MessageBySeq(seq)
+	return msg, msg != nil
+}
+
+// isSent returns whether the message with the id has been sent by the
+// author with the text, so that a retry succeeds without sending it again.
+// The text of a deleted message is unknown, any retry of its author
+// succeeds. It returns ErrMessageExists if another message has the id.
+func (room *Room) isSent(id, author, text string) (bool, error) {
+	msg, ok := room.MessageByID(id)
+	if !ok {
+		return false, nil
+	}
+	original := msg.Text
+	if len(msg.Revisions) > 0 {
+		original = msg.Revisions[0].Text
+	}
+	if msg.Author == author && (msg.Deleted || original == text) {
+		return true, nil
+	}
+	return false, ErrMessageExists
+}
+
+// indexID adds the message of the room to the id index if it has an id.
+func (s *Storage) indexID(roomID int, msg *Message) {
+	if len(msg.ID) == 0 {
+		return
+	}
+	s.changes.IDs[MessageIDKey{RoomID: roomID, ID: msg.ID}] = msg.Seq
+}
+
+// unindexID removes the pruned message from the id index.
+func (s *Storage) unindexID(roomID int, msg *Message) {
+	if len(msg.ID) == 0 {
+		return
+	}
+	if seq, ok := s.messageSeq(roomID, msg.ID); ok && seq == msg.Seq {
+		s.changes.IDs[MessageIDKey{RoomID: roomID, ID: msg.ID}] = 0
+	}
+}
+
+// MessageBySeq returns the message with the seq in the room, or nil if it
+// doesn't exist.
+func (room *Room) MessageBySeq(seq uint64) *Message {
+	if seq < room.FirstSeq || seq >= room.NextSeq {
+		return nil
+	}
+	return room.storage.Message(MessageKey{RoomID: room.ID, Seq: seq})
+}
+
+// MessageCount returns the number of kept messages of the room.
+func (room *Room) MessageCount() int {
+	return int(room.NextSeq - room.FirstSeq)
+}
+
+// MessageAt returns the i-th kept message of the room, oldest first.
+func (room *Room) MessageAt(i int) *Message {
+	return room.MessageBySeq(room.FirstSeq + uint64(i))
+}
+
+// checkMessageChange returns the room and the message if the user can edit
+// or delete it, only the author and moderators of the room can do it.
+func (s *Storage) checkMessageChange(name string, roomID int, id string) (*Room, *Message, error) {
+	room, ok := s.Room(roomID)
+	if !ok {
+		return nil, nil, ErrRoomNotExists
+	}
+	if room.Archived {
+		return nil, nil, ErrRoomArchived
+	}
+	msg, ok := room.MessageByID(id)
+	if !ok {
+		return nil, nil, ErrMessageNotExists
+	}
+	if msg.Deleted {
+		return nil, nil, ErrMessageDeleted
+	}
+	if (len(msg.Author) == 0 || msg.Author != name) && !room.CanModerate(name) {
+		return nil, nil, ErrPermissionDenied
+	}
+	return room, msg, nil
+}
+
+// EditMessageCommand replaces the text of a message, the previous text is
+// kept as a revision. TS is given by the proposer.
+type EditMessageCommand struct {
+	UserName string
+	RoomID   int
+	ID       string
+	Text     string
+	TS       int
+}
+
+func (c *EditMessageCommand) Execute(s *Storage) *ExecuteResult {
+	room, msg, err := s.checkMessageChange(c.UserName, c.RoomID, c.ID)
+	if err != nil {
+		return &ExecuteResult{Err: err}
+	}
+	if room.IsMuted(c.UserName, c.TS) {
+		return &ExecuteResult{Err: ErrUserMuted}
+	}
+	ts := msg.TS
+	if msg.EditTS > 0 {
+		ts = msg.EditTS
+	}
+	msg.Revisions = append(msg.Revisions, Revision{Text: msg.Text, TS: ts})
+	msg.Text = c.Text
+	msg.EditTS = c.TS
+	s.putMessage(room.ID, msg)
+	s.emit(EventEdit, room.ID, msg)
+	return &ExecuteResult{}
+}
+
+// DeleteMessageCommand deletes a message and its revisions. The message is
+// left as a tombstone so that pages of the room don't shift. TS is given by
+// the proposer.
+type DeleteMessageCommand struct {
+	UserName string
+	RoomID   int
+	ID       string
+	TS       int
+}
+
+func (c *DeleteMessageCommand) Execute(s *Storage) *ExecuteResult {
+	room, msg, err := s.checkMessageChange(c.UserName, c.RoomID, c.ID)
+	if err != nil {
+		return &ExecuteResult{Err: err}
+	}
+	msg.Text = ""
+	msg.Revisions = nil
+	s.unindexReactions(room.ID, msg)
+	msg.Reactions = nil
+	s.unrefAttachments(room.ID, msg.Attachments)
+	msg.Attachments = nil
+	msg.Deleted = true
+	msg.EditTS = c.TS
+	s.putMessage(room.ID, msg)
+	s.emit(EventDelete, room.ID, msg)
+	return &ExecuteResult{}
+}
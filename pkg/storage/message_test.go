@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestEditAndDeleteMessages(t *testing.T) {
+	s, id := newTestRoom(t, CreateRoomCommand{Name: "lobby", Owner: "alice"}, "bob", "carol")
+	room, _ := s.Room(id)
+	for i, name := range []string{"bob", "carol"} {
+		mustExecute(t, s, InternalRaftCommand{SendMessage: &SendMessageCommand{
+			ID: name, TS: 100 + i, TSMilli: int64(100+i)*1000 + 5, Text: "hi from " + name, UserName: name,
+		}})
+	}
+	if msg := room.MessageAt(1); msg.Author != "carol" || msg.TSMilli != 101005 {
+		t.Fatalf("author and timestamp should be recorded: %+v", msg)
+	}
+	s.TakeEvents()
+
+	edit := InternalRaftCommand{EditMessage: &EditMessageCommand{UserName: "carol", RoomID: id, ID: "bob", Text: "hacked", TS: 200}}
+	if result := edit.Execute(s); result.Err != ErrPermissionDenied {
+		t.Fatalf("expect %v, got %v", ErrPermissionDenied, result.Err)
+	}
+	edit.EditMessage.UserName = "bob"
+	edit.EditMessage.Text = "hello"
+	mustExecute(t, s, edit)
+	msg, _ := room.MessageByID("bob")
+	if msg.Text != "hello" || len(msg.Revisions) != 1 || msg.Revisions[0] != (Revision{Text: "hi from bob", TS: 100}) {
+		t.Fatalf("unexpected message after edit: %+v", msg)
+	}
+
+	// The owner deletes the message of another user, a tombstone is left.
+	mustExecute(t, s, InternalRaftCommand{DeleteMessage: &DeleteMessageCommand{UserName: "alice", RoomID: id, ID: "bob", TS: 300}})
+	if room.MessageCount() != 2 || !msg.Deleted || msg.Text != "" || msg.Revisions != nil {
+		t.Fatalf("unexpected tombstone: %+v", msg)
+	}
+	if result := edit.Execute(s); result.Err != ErrMessageDeleted {
+		t.Fatalf("expect %v, got %v", ErrMessageDeleted, result.Err)
+	}
+
+	events := s.TakeEvents()
+	if len(events) != 2 || events[0].Type != EventEdit || events[1].Type != EventDelete || events[1].RoomID != id {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+	if len(s.TakeEvents()) != 0 {
+		t.Fatal("events should be reset")
+	}
+}
+
+func TestDuplicateMessageIDs(t *testing.T) {
+	s, id := newTestRoom(t, CreateRoomCommand{Name: "lobby", Owner: "alice"}, "alice")
+	send := InternalRaftCommand{SendMessage: &SendMessageCommand{ID: "m", Text: "first", UserName: "alice"}}
+	mustExecute(t, s, send)
+	// Retries succeed without sending the message again, even after it has
+	// been edited.
+	mustExecute(t, s, send)
+	mustExecute(t, s, InternalRaftCommand{EditMessage: &EditMessageCommand{UserName: "alice", RoomID: id, ID: "m", Text: "edited"}})
+	mustExecute(t, s, send)
+	if n := s.Rooms[id].MessageCount(); n != 1 {
+		t.Fatalf("expect 1 message, got %d", n)
+	}
+	send.SendMessage.Text = "second"
+	if result := send.Execute(s); result.Err != ErrMessageExists {
+		t.Fatalf("expect %v, got %v", ErrMessageExists, result.Err)
+	}
+	send.SendMessage.Text = "first"
+	send.SendMessage.UserName = "bob"
+	mustExecute(t, s, InternalRaftCommand{EnterRoom: &EnterRoomCommand{UserName: "bob", RoomID: id}})
+	if result := send.Execute(s); result.Err != ErrMessageExists {
+		t.Fatalf("expect %v, got %v", ErrMessageExists, result.Err)
+	}
+	// Messages without id don't conflict.
+	for i := 0; i < 2; i++ {
+		mustExecute(t, s, InternalRaftCommand{SendMessage: &SendMessageCommand{Text: "anonymous", UserName: "alice"}})
+	}
+	if _, ok := s.Rooms[id].MessageByID(""); ok {
+		t.Fatal("messages without id should not be looked up")
+	}
+
+	// Rooms of old versions may have duplicate ids, the latest message wins.
+	legacy, err := DecodeSnapshot(legacySnapshot(t, s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	room := legacy.Rooms[id]
+	room.Messages = append(room.Messages, &Message{Seq: room.NextSeq, ID: "m", Text: "legacy"})
+	room.NextSeq++
+	data, err := encodeGOB(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recovered := NewStorage()
+	recovered.RecoverFromSnapshot(data)
+	if msg, ok := recovered.Rooms[id].MessageByID("m"); !ok || msg.Text != "legacy" {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"errors"
+)
+
+var (
+	ErrUserBanned = errors.New("user is banned from the room")
+	ErrUserMuted  = errors.New("user is muted in the room")
+)
+
+// CanModerate returns whether the user is the owner or a moderator of the
+// room.
+func (room *Room) CanModerate(name string) bool {
+	return (len(room.Owner) > 0 && room.Owner == name) || containsName(room.Moderators, name)
+}
+
+// canManage returns whether the user can moderate the target user, only the
+// owner can moderate moderators and nobody can moderate the owner.
+func (room *Room) canManage(name, target string) bool {
+	if !room.CanModerate(name) || target == room.Owner {
+		return false
+	}
+	return name == room.Owner || !containsName(room.Moderators, target)
+}
+
+// restricted returns whether the restriction of the user in the map has not
+// expired at now.
+func restricted(m map[string]int, name string, now int) bool {
+	until, ok := m[name]
+	return ok && (until == 0 || now < until)
+}
+
+// IsBanned returns whether the user is banned from the room at now.
+func (room *Room) IsBanned(name string, now int) bool {
+	return restricted(room.Bans, name, now)
+}
+
+// IsMuted returns whether the user is muted in the room at now.
+func (room *Room) IsMuted(name string, now int) bool {
+	return restricted(room.Mutes, name, now)
+}
+
+// Restrictions returns the names of the users restricted in the map at now,
+// with the unix time when their restrictions expire.
+func Restrictions(m map[string]int, now int) map[string]int {
+	result := make(map[string]int)
+	for name, until := range m {
+		if until == 0 || now < until {
+			result[name] = until
+		}
+	}
+	return result
+}
+
+// setRestriction restricts the user until the given time, or lifts the
+// restriction if enabled is false. Expired restrictions are dropped.
+func setRestriction(m map[string]int, name string, enabled bool, until, now int) map[string]int {
+	m = Restrictions(m, now)
+	if enabled {
+		m[name] = until
+	} else {
+		delete(m, name)
+	}
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
+// checkModeration returns the room and an error if the user can't moderate
+// the target in it.
+func (s *Storage) checkModeration(name string, roomID int, target string) (*Room, error) {
+	room, ok := s.Room(roomID)
+	if !ok {
+		return nil, ErrRoomNotExists
+	}
+	if !room.canManage(name, target) {
+		return nil, ErrPermissionDenied
+	}
+	if _, ok := s.Users[target]; !ok {
+		return nil, ErrUserNotExists
+	}
+	return room, nil
+}
+
+// SetModeratorCommand makes the target user a moderator of the room, or
+// revokes it if Moderator is false. Only the owner of the room can do it.
+type SetModeratorCommand struct {
+	UserName  string
+	RoomID    int
+	Target    string
+	Moderator bool
+}
+
+func (c *SetModeratorCommand) Execute(s *Storage) *ExecuteResult {
+	room, ok := s.Room(c.RoomID)
+	if !ok {
+		return &ExecuteResult{Err: ErrRoomNotExists}
+	}
+	if len(room.Owner) == 0 || room.Owner != c.UserName {
+		return &ExecuteResult{Err: ErrPermissionDenied}
+	}
+	if _, ok := s.Users[c.Target]; !ok {
+		return &ExecuteResult{Err: ErrUserNotExists}
+	}
+	if c.Target == room.Owner {
+		return &ExecuteResult{}
+	}
+	if c.Moderator {
+		room.Moderators = insertName(room.Moderators, c.Target)
+	} else {
+		room.Moderators = removeUser(room.Moderators, c.Target)
+	}
+	s.touchRoom(room.ID)
+	return &ExecuteResult{}
+}
+
+// KickUserCommand removes the target user from the members of the room, it
+// can join the room again.
+type KickUserCommand struct {
+	UserName string
+	RoomID   int
+	Target   string
+}
+
+func (c *KickUserCommand) Execute(s *Storage) *ExecuteResult {
+	room, err := s.checkModeration(c.UserName, c.RoomID, c.Target)
+	if err != nil {
+		return &ExecuteResult{Err: err}
+	}
+	if user := s.Users[c.Target]; user.IsMember(room.ID) {
+		s.leaveRoom(user, room)
+	}
+	return &ExecuteResult{}
+}
+
+// BanUserCommand removes the target user from the room and keeps it from
+// joining again until Until, or forever if Until is 0. The ban is lifted if
+// Banned is false. TS is given by the proposer to drop expired bans.
+type BanUserCommand struct {
+	UserName string
+	RoomID   int
+	Target   string
+	Banned   bool
+	Until    int
+	TS       int
+}
+
+func (c *BanUserCommand) Execute(s *Storage) *ExecuteResult {
+	room, err := s.checkModeration(c.UserName, c.RoomID, c.Target)
+	if err != nil {
+		return &ExecuteResult{Err: err}
+	}
+	room.Bans = setRestriction(room.Bans, c.Target, c.Banned, c.Until, c.TS)
+	if c.Banned {
+		room.Invites = removeUser(room.Invites, c.Target)
+		room.JoinRequests = removeUser(room.JoinRequests, c.Target)
+		if user := s.Users[c.Target]; user.IsMember(room.ID) {
+			s.leaveRoom(user, room)
+		}
+	}
+	s.touchRoom(room.ID)
+	return &ExecuteResult{}
+}
+
+// MuteUserCommand keeps the target user from sending messages to the room
+// until Until, or forever if Until is 0. The mute is lifted if Muted is
+// false. TS is given by the proposer to drop expired mutes.
+type MuteUserCommand struct {
+	UserName string
+	RoomID   int
+	Target   string
+	Muted    bool
+	Until    int
+	TS       int
+}
+
+func (c *MuteUserCommand) Execute(s *Storage) *ExecuteResult {
+	room, err := s.checkModeration(c.UserName, c.RoomID, c.Target)
+	if err != nil {
+		return &ExecuteResult{Err: err}
+	}
+	room.Mutes = setRestriction(room.Mutes, c.Target, c.Muted, c.Until, c.TS)
+	s.touchRoom(room.ID)
+	return &ExecuteResult{}
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestModeration(t *testing.T) {
+	s, id := newTestRoom(t, CreateRoomCommand{Name: "lobby", Owner: "alice"}, "bob", "carol")
+	room, _ := s.Room(id)
+
+	kick := InternalRaftCommand{KickUser: &KickUserCommand{UserName: "bob", RoomID: id, Target: "carol"}}
+	if result := kick.Execute(s); result.Err != ErrPermissionDenied {
+		t.Fatalf("expect %v, got %v", ErrPermissionDenied, result.Err)
+	}
+	mustExecute(t, s, InternalRaftCommand{SetModerator: &SetModeratorCommand{
+		UserName: "alice", RoomID: id, Target: "bob", Moderator: true,
+	}})
+	mustExecute(t, s, kick)
+	if s.Users["carol"].IsMember(id) {
+		t.Fatal("kicked user should leave the room")
+	}
+	kickOwner := InternalRaftCommand{KickUser: &KickUserCommand{UserName: "bob", RoomID: id, Target: "alice"}}
+	if result := kickOwner.Execute(s); result.Err != ErrPermissionDenied {
+		t.Fatal("moderators should not moderate the owner")
+	}
+
+	// A ban keeps the user out of the room until it expires.
+	mustExecute(t, s, InternalRaftCommand{BanUser: &BanUserCommand{
+		UserName: "bob", RoomID: id, Target: "carol", Banned: true, Until: 200, TS: 100,
+	}})
+	enter := InternalRaftCommand{EnterRoom: &EnterRoomCommand{UserName: "carol", RoomID: id, TS: 150}}
+	if result := enter.Execute(s); result.Err != ErrUserBanned {
+		t.Fatalf("expect %v, got %v", ErrUserBanned, result.Err)
+	}
+	enter.EnterRoom.TS = 200
+	mustExecute(t, s, enter)
+
+	// A mute keeps the user from sending messages until it is lifted.
+	mute := InternalRaftCommand{MuteUser: &MuteUserCommand{UserName: "alice", RoomID: id, Target: "carol", Muted: true, TS: 300}}
+	mustExecute(t, s, mute)
+	send := InternalRaftCommand{SendMessage: &SendMessageCommand{UserName: "carol", Text: "hi", TS: 400}}
+	if result := send.Execute(s); result.Err != ErrUserMuted {
+		t.Fatalf("expect %v, got %v", ErrUserMuted, result.Err)
+	}
+	mute.MuteUser.Muted = false
+	mustExecute(t, s, mute)
+	mustExecute(t, s, send)
+	if len(room.Mutes) != 0 {
+		t.Fatal("lifted mute should be dropped")
+	}
+
+	// Moderators can rename the room but only the owner can delete it.
+	mustExecute(t, s, InternalRaftCommand{RenameRoom: &RenameRoomCommand{UserName: "bob", RoomID: id, Name: "hall"}})
+	del := InternalRaftCommand{DeleteRoom: &DeleteRoomCommand{UserName: "bob", RoomID: id}}
+	if result := del.Execute(s); result.Err != ErrPermissionDenied {
+		t.Fatalf("expect %v, got %v", ErrPermissionDenied, result.Err)
+	}
+	mustExecute(t, s, InternalRaftCommand{DeleteUser: &DeleteUserCommand{UserName: "bob"}})
+	if len(room.Moderators) != 0 {
+		t.Fatal("deleted user should not be a moderator")
+	}
+}
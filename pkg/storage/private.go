@@ -0,0 +1,215 @@
+package storage
+
+import (
+	"errors"
+	"sort"
+)
+
+var (
+	ErrRoomPrivate       = errors.New("room is private")
+	ErrPermissionDenied  = errors.New("permission denied")
+	ErrNoJoinRequest     = errors.New("join request not exists")
+	ErrAlreadyRoomMember = errors.New("user is already a member of the room")
+)
+
+// containsName returns whether the sorted names contain the name.
+func containsName(names []string, name string) bool {
+	i := sort.SearchStrings(names, name)
+	return i < len(names) && names[i] == name
+}
+
+// insertName inserts the name into the sorted names if it doesn't exist.
+func insertName(names []string, name string) []string {
+	i := sort.SearchStrings(names, name)
+	if i < len(names) && names[i] == name {
+		return names
+	}
+	names = append(names, "")
+	copy(names[i+1:], names[i:])
+	names[i] = name
+	return names
+}
+
+// CanAccess returns whether the user can see the room and its members.
+// Everyone can access public rooms, private rooms are only accessible to
+// their members.
+func (room *Room) CanAccess(user *User) bool {
+	if !room.Private {
+		return true
+	}
+	return user != nil && user.IsMember(room.ID)
+}
+
+// checkJoin returns an error if the user can't join the room at now. Banned
+// users can't join, the owner of a private room can always join, and an
+// invitation of other users is consumed once they join.
+func (s *Storage) checkJoin(user *User, room *Room, now int) error {
+	if room.IsBanned(user.UserName, now) {
+		return ErrUserBanned
+	}
+	if !room.Private || user.IsMember(room.ID) || room.Owner == user.UserName {
+		return nil
+	}
+	if !containsName(room.Invites, user.UserName) {
+		return ErrRoomPrivate
+	}
+	room.Invites = removeUser(room.Invites, user.UserName)
+	room.JoinRequests = removeUser(room.JoinRequests, user.UserName)
+	s.touchRoom(room.ID)
+	return nil
+}
+
+// forgetUser removes the ownership, pending invitations, join requests,
+// roles, restrictions and reactions of the user, so that a user created later
+// with the same name inherits none of them. Rooms owned by the user become
+// ownerless and can only be deleted by admins.
+func (s *Storage) forgetUser(name string) {
+	for _, room := range s.Rooms {
+		_, banned := room.Bans[name]
+		_, muted := room.Mutes[name]
+		if room.Owner == name || containsName(room.Invites, name) || containsName(room.JoinRequests, name) ||
+			containsName(room.Moderators, name) || banned || muted {
+			if room.Owner == name {
+				room.Owner = ""
+			}
+			room.Invites = removeUser(room.Invites, name)
+			room.JoinRequests = removeUser(room.JoinRequests, name)
+			room.Moderators = removeUser(room.Moderators, name)
+			delete(room.Bans, name)
+			delete(room.Mutes, name)
+			s.touchRoom(room.ID)
+		}
+	}
+	s.forgetReactions(name)
+}
+
+// SetRoomVisibilityCommand makes the room private or public, only the owner
+// of the room can do it.
+type SetRoomVisibilityCommand struct {
+	UserName string
+	RoomID   int
+	Private  bool
+}
+
+func (c *SetRoomVisibilityCommand) Execute(s *Storage) *ExecuteResult {
+	room, ok := s.Room(c.RoomID)
+	if !ok {
+		return &ExecuteResult{Err: ErrRoomNotExists}
+	}
+	if room.Owner != c.UserName {
+		return &ExecuteResult{Err: ErrPermissionDenied}
+	}
+	room.Private = c.Private
+	if !room.Private {
+		room.Invites = nil
+		room.JoinRequests = nil
+	}
+	s.touchRoom(room.ID)
+	return &ExecuteResult{}
+}
+
+// InviteCommand invites a user to the private room, the inviter must be a
+// member of the room. The invitee joins the room at once if it has requested
+// to join. TS is given by the proposer to check bans of the room.
+type InviteCommand struct {
+	UserName string
+	RoomID   int
+	Invitee  string
+	TS       int
+}
+
+func (c *InviteCommand) Execute(s *Storage) *ExecuteResult {
+	user, ok := s.Users[c.UserName]
+	if !ok {
+		return &ExecuteResult{Err: ErrUserNotExists}
+	}
+	room, ok := s.Room(c.RoomID)
+	if !ok || !room.CanAccess(user) {
+		return &ExecuteResult{Err: ErrRoomNotExists}
+	}
+	if !room.Private {
+		return &ExecuteResult{}
+	}
+	invitee, ok := s.Users[c.Invitee]
+	if !ok {
+		return &ExecuteResult{Err: ErrUserNotExists}
+	}
+	if invitee.IsMember(room.ID) {
+		return &ExecuteResult{Err: ErrAlreadyRoomMember}
+	}
+	if room.IsBanned(invitee.UserName, c.TS) {
+		return &ExecuteResult{Err: ErrUserBanned}
+	}
+	if containsName(room.JoinRequests, invitee.UserName) {
+		room.JoinRequests = removeUser(room.JoinRequests, invitee.UserName)
+		s.joinRoom(invitee, room)
+		return &ExecuteResult{}
+	}
+	room.Invites = insertName(room.Invites, invitee.UserName)
+	s.touchRoom(room.ID)
+	return &ExecuteResult{}
+}
+
+// RequestJoinCommand requests to join the private room, the user joins at
+// once if it has been invited. TS is given by the proposer to check bans of
+// the room.
+type RequestJoinCommand struct {
+	UserName string
+	RoomID   int
+	TS       int
+}
+
+func (c *RequestJoinCommand) Execute(s *Storage) *ExecuteResult {
+	user, ok := s.Users[c.UserName]
+	if !ok {
+		return &ExecuteResult{Err: ErrUserNotExists}
+	}
+	room, ok := s.Room(c.RoomID)
+	if !ok {
+		return &ExecuteResult{Err: ErrRoomNotExists}
+	}
+	if user.IsMember(room.ID) {
+		return &ExecuteResult{}
+	}
+	if room.IsBanned(user.UserName, c.TS) {
+		return &ExecuteResult{Err: ErrUserBanned}
+	}
+	if !room.Private || containsName(room.Invites, user.UserName) {
+		if err := s.checkJoin(user, room, c.TS); err != nil {
+			return &ExecuteResult{Err: err}
+		}
+		s.joinRoom(user, room)
+		return &ExecuteResult{}
+	}
+	room.JoinRequests = insertName(room.JoinRequests, user.UserName)
+	s.touchRoom(room.ID)
+	return &ExecuteResult{}
+}
+
+// AnswerJoinRequestCommand approves or rejects a join request of the room,
+// only the owner of the room can do it.
+type AnswerJoinRequestCommand struct {
+	UserName  string
+	RoomID    int
+	Requester string
+	Approved  bool
+}
+
+func (c *AnswerJoinRequestCommand) Execute(s *Storage) *ExecuteResult {
+	room, ok := s.Room(c.RoomID)
+	if !ok {
+		return &ExecuteResult{Err: ErrRoomNotExists}
+	}
+	if room.Owner != c.UserName {
+		return &ExecuteResult{Err: ErrPermissionDenied}
+	}
+	if !containsName(room.JoinRequests, c.Requester) {
+		return &ExecuteResult{Err: ErrNoJoinRequest}
+	}
+	room.JoinRequests = removeUser(room.JoinRequests, c.Requester)
+	s.touchRoom(room.ID)
+	if requester, ok := s.Users[c.Requester]; ok && c.Approved {
+		s.joinRoom(requester, room)
+	}
+	return &ExecuteResult{}
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestPrivateRooms(t *testing.T) {
+	s, id := newTestRoom(t, CreateRoomCommand{Name: "secret", Owner: "alice", Private: true})
+	room, _ := s.Room(id)
+	if !s.Users["alice"].IsMember(id) || !room.CanAccess(s.Users["alice"]) {
+		t.Fatal("owner should join the private room")
+	}
+	if room.CanAccess(s.Users["bob"]) || room.CanAccess(nil) {
+		t.Fatal("private room should not be accessible to non-members")
+	}
+
+	enter := InternalRaftCommand{EnterRoom: &EnterRoomCommand{UserName: "bob", RoomID: id}}
+	if result := enter.Execute(s); result.Err != ErrRoomPrivate {
+		t.Fatalf("expect %v, got %v", ErrRoomPrivate, result.Err)
+	}
+
+	// An invitation lets the user enter once.
+	mustExecute(t, s, InternalRaftCommand{Invite: &InviteCommand{UserName: "alice", RoomID: id, Invitee: "bob"}})
+	mustExecute(t, s, InternalRaftCommand{EnterRoom: &EnterRoomCommand{UserName: "bob", RoomID: id}})
+	if !s.Users["bob"].IsMember(id) || len(room.Invites) != 0 {
+		t.Fatal("invitation should be consumed by joining")
+	}
+
+	// A join request is pending until the owner approves it.
+	mustExecute(t, s, InternalRaftCommand{RequestJoin: &RequestJoinCommand{UserName: "carol", RoomID: id}})
+	if s.Users["carol"].IsMember(id) || len(room.JoinRequests) != 1 {
+		t.Fatal("join request should be pending")
+	}
+	answer := InternalRaftCommand{AnswerJoinRequest: &AnswerJoinRequestCommand{
+		UserName: "bob", RoomID: id, Requester: "carol", Approved: true,
+	}}
+	if result := answer.Execute(s); result.Err != ErrPermissionDenied {
+		t.Fatalf("expect %v, got %v", ErrPermissionDenied, result.Err)
+	}
+	answer.AnswerJoinRequest.UserName = "alice"
+	mustExecute(t, s, answer)
+	if !s.Users["carol"].IsMember(id) || len(room.JoinRequests) != 0 {
+		t.Fatal("approved requester should join the room")
+	}
+
+	visibility := InternalRaftCommand{SetRoomVisibility: &SetRoomVisibilityCommand{UserName: "bob", RoomID: id}}
+	if result := visibility.Execute(s); result.Err != ErrPermissionDenied {
+		t.Fatalf("expect %v, got %v", ErrPermissionDenied, result.Err)
+	}
+	visibility.SetRoomVisibility.UserName = "alice"
+	mustExecute(t, s, visibility)
+	if room.Private || !room.CanAccess(nil) {
+		t.Fatal("room should be public")
+	}
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"errors"
+	"unicode/utf8"
+)
+
+// MaxEmojiLength limits the length of a reaction emoji in bytes.
+const MaxEmojiLength = 64
+
+var ErrInvalidEmoji = errors.New("invalid reaction emoji")
+
+// ReactionCounts returns the number of users who reacted with each emoji.
+func (msg *Message) ReactionCounts() map[string]int {
+	if len(msg.Reactions) == 0 {
+		return nil
+	}
+	counts := make(map[string]int, len(msg.Reactions))
+	for emoji, names := range msg.Reactions {
+		counts[emoji] = len(names)
+	}
+	return counts
+}
+
+// copyReactions returns a deep copy of the reactions.
+func copyReactions(reactions map[string][]string) map[string][]string {
+	if reactions == nil {
+		return nil
+	}
+	result := make(map[string][]string, len(reactions))
+	for emoji, names := range reactions {
+		result[emoji] = append([]string(nil), names...)
+	}
+	return result
+}
+
+// checkReaction returns the room and the message if the user can react to
+// it, only members of the room can do it.
+func (s *Storage) checkReaction(name string, roomID int, id, emoji string) (*Room, *Message, error) {
+	if len(emoji) == 0 || len(emoji) > MaxEmojiLength || !utf8.ValidString(emoji) {
+		return nil, nil, ErrInvalidEmoji
+	}
+	user, ok := s.Users[name]
+	if !ok {
+		return nil, nil, ErrUserNotExists
+	}
+	room, ok := s.Room(roomID)
+	if !ok {
+		return nil, nil, ErrRoomNotExists
+	}
+	if !user.IsMember(room.ID) {
+		return nil, nil, ErrNotRoomMember
+	}
+	if room.Archived {
+		return nil, nil, ErrRoomArchived
+	}
+	msg, ok := room.MessageByID(id)
+	if !ok {
+		return nil, nil, ErrMessageNotExists
+	}
+	if msg.Deleted {
+		return nil, nil, ErrMessageDeleted
+	}
+	return room, msg, nil
+}
+
+// removeReactions removes the reactions of the user from the message and
+// returns whether any is removed.
+func (msg *Message) removeReactions(name, emoji string) bool {
+	removed := false
+	for e, names := range msg.Reactions {
+		if (len(emoji) > 0 && e != emoji) || !containsName(names, name) {
+			continue
+		}
+		removed = true
+		if names = removeUser(names, name); len(names) > 0 {
+			msg.Reactions[e] = names
+		} else {
+			delete(msg.Reactions, e)
+		}
+	}
+	if len(msg.Reactions) == 0 {
+		msg.Reactions = nil
+	}
+	return removed
+}
+
+// hasReaction returns whether the user reacts to the message.
+func (msg *Message) hasReaction(name string) bool {
+	for _, names := range msg.Reactions {
+		if containsName(names, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// indexReaction records that the user reacts to the message.
+func (s *Storage) indexReaction(name string, roomID int, seq uint64) {
+	s.changes.Reactions[ReactionKey{UserName: name, MessageKey: MessageKey{RoomID: roomID, Seq: seq}}] = true
+}
+
+// unindexReaction records that the user no longer reacts to the message.
+func (s *Storage) unindexReaction(name string, roomID int, seq uint64) {
+	s.changes.Reactions[ReactionKey{UserName: name, MessageKey: MessageKey{RoomID: roomID, Seq: seq}}] = false
+}
+
+// unindexReactions records that nobody reacts to the message any more.
+func (s *Storage) unindexReactions(roomID int, msg *Message) {
+	for _, names := range msg.Reactions {
+		for _, name := range names {
+			s.unindexReaction(name, roomID, msg.Seq)
+		}
+	}
+}
+
+// forgetReactions removes the reactions of the user from all messages.
+func (s *Storage) forgetReactions(name string) {
+	for _, key := range s.reactedMessages(name) {
+		if msg := s.Message(key); msg != nil && msg.removeReactions(name, "") {
+			s.putMessage(key.RoomID, msg)
+		}
+		s.unindexReaction(name, key.RoomID, key.Seq)
+	}
+}
+
+// AddReactionCommand reacts to a message with the emoji. TS is given by the
+// proposer to check mutes of the room.
+type AddReactionCommand struct {
+	UserName string
+	RoomID   int
+	ID       string
+	Emoji    string
+	TS       int
+}
+
+func (c *AddReactionCommand) Execute(s *Storage) *ExecuteResult {
+	room, msg, err := s.checkReaction(c.UserName, c.RoomID, c.ID, c.Emoji)
+	if err != nil {
+		return &ExecuteResult{Err: err}
+	}
+	if room.IsMuted(c.UserName, c.TS) {
+		return &ExecuteResult{Err: ErrUserMuted}
+	}
+	if containsName(msg.Reactions[c.Emoji], c.UserName) {
+		return &ExecuteResult{}
+	}
+	if msg.Reactions == nil {
+		msg.Reactions = make(map[string][]string)
+	}
+	msg.Reactions[c.Emoji] = insertName(msg.Reactions[c.Emoji], c.UserName)
+	s.indexReaction(c.UserName, room.ID, msg.Seq)
+	s.putMessage(room.ID, msg)
+	s.emit(EventReaction, room.ID, msg)
+	return &ExecuteResult{}
+}
+
+// RemoveReactionCommand removes the reaction of the user with the emoji from
+// a message, muted users can still do it.
+type RemoveReactionCommand struct {
+	UserName string
+	RoomID   int
+	ID       string
+	Emoji    string
+}
+
+func (c *RemoveReactionCommand) Execute(s *Storage) *ExecuteResult {
+	room, msg, err := s.checkReaction(c.UserName, c.RoomID, c.ID, c.Emoji)
+	if err != nil {
+		return &ExecuteResult{Err: err}
+	}
+	if msg.removeReactions(c.UserName, c.Emoji) {
+		if !msg.hasReaction(c.UserName) {
+			s.unindexReaction(c.UserName, room.ID, msg.Seq)
+		}
+		s.putMessage(room.ID, msg)
+		s.emit(EventReaction, room.ID, msg)
+	}
+	return &ExecuteResult{}
+}
@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReactions(t *testing.T) {
+	s, id := newTestRoom(t, CreateRoomCommand{Name: "lobby"}, "alice", "bob")
+	mustExecute(t, s, InternalRaftCommand{SendMessage: &SendMessageCommand{ID: "m", Text: "hi", UserName: "alice"}})
+	react := func(name, emoji string) InternalRaftCommand {
+		return InternalRaftCommand{AddReaction: &AddReactionCommand{UserName: name, RoomID: id, ID: "m", Emoji: emoji}}
+	}
+	for _, cmd := range []InternalRaftCommand{react("alice", "👍"), react("bob", "👍"), react("bob", "👍"), react("bob", "🎉")} {
+		mustExecute(t, s, cmd)
+	}
+	if cmd := react("carol", "👍"); cmd.Execute(s).Err != ErrNotRoomMember {
+		t.Fatal("non-members should not react")
+	}
+	if cmd := react("alice", ""); cmd.Execute(s).Err != ErrInvalidEmoji {
+		t.Fatal("empty emoji should be rejected")
+	}
+	room, _ := s.Room(id)
+	msg, _ := room.MessageByID("m")
+	if counts := msg.ReactionCounts(); !reflect.DeepEqual(counts, map[string]int{"👍": 2, "🎉": 1}) {
+		t.Fatalf("unexpected reaction counts: %v", counts)
+	}
+
+	mustExecute(t, s, InternalRaftCommand{RemoveReaction: &RemoveReactionCommand{UserName: "bob", RoomID: id, ID: "m", Emoji: "🎉"}})
+	events := s.TakeEvents()
+	if last := events[len(events)-1]; last.Type != EventReaction || !reflect.DeepEqual(last.Message.ReactionCounts(), map[string]int{"👍": 2}) {
+		t.Fatalf("unexpected reaction event: %+v", last)
+	}
+
+	// Reactions of deleted users are removed.
+	mustExecute(t, s, InternalRaftCommand{DeleteUser: &DeleteUserCommand{UserName: "bob"}})
+	if counts := msg.ReactionCounts(); !reflect.DeepEqual(counts, map[string]int{"👍": 1}) {
+		t.Fatalf("unexpected reaction counts: %v", counts)
+	}
+}
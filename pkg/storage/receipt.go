@@ -0,0 +1,61 @@
+package storage
+
+// UnreadCount returns the number of messages in the room after the last one
+// read by the user.
+func (room *Room) UnreadCount(user *User) int {
+	first := room.FirstSeq
+	if lastRead := user.LastRead[room.ID]; lastRead >= first {
+		first = lastRead + 1
+	}
+	if first >= room.NextSeq {
+		return 0
+	}
+	return int(room.NextSeq - first)
+}
+
+// markRead moves the last read message of the user in the room forward to
+// seq, and returns whether it is moved.
+func (s *Storage) markRead(user *User, roomID int, seq uint64) bool {
+	if seq <= user.LastRead[roomID] {
+		return false
+	}
+	if user.LastRead == nil {
+		user.LastRead = make(map[int]uint64)
+	}
+	user.LastRead[roomID] = seq
+	s.touchUser(user.UserName)
+	return true
+}
+
+// MarkReadCommand marks the messages of the room up to the message with the
+// id as read by the user, or all messages if ID is empty. The last read
+// message never moves backward.
+type MarkReadCommand struct {
+	UserName string
+	RoomID   int
+	ID       string
+}
+
+func (c *MarkReadCommand) Execute(s *Storage) *ExecuteResult {
+	user, ok := s.Users[c.UserName]
+	if !ok {
+		return &ExecuteResult{Err: ErrUserNotExists}
+	}
+	room, ok := s.Room(c.RoomID)
+	if !ok {
+		return &ExecuteResult{Err: ErrRoomNotExists}
+	}
+	if !user.IsMember(room.ID) {
+		return &ExecuteResult{Err: ErrNotRoomMember}
+	}
+	seq := room.NextSeq - 1
+	if len(c.ID) > 0 {
+		msg, ok := room.MessageByID(c.ID)
+		if !ok {
+			return &ExecuteResult{Err: ErrMessageNotExists}
+		}
+		seq = msg.Seq
+	}
+	s.markRead(user, room.ID, seq)
+	return &ExecuteResult{}
+}
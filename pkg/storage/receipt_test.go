@@ -0,0 +1,41 @@
+package storage
+
+import "testing"
+
+func TestReadReceipts(t *testing.T) {
+	s, id := newTestRoom(t, CreateRoomCommand{Name: "lobby"}, "alice", "bob")
+	for _, msgID := range []string{"m1", "m2", "m3"} {
+		mustExecute(t, s, InternalRaftCommand{SendMessage: &SendMessageCommand{ID: msgID, Text: "hi", UserName: "alice"}})
+	}
+	room, _ := s.Room(id)
+	alice, bob := s.Users["alice"], s.Users["bob"]
+	if n := room.UnreadCount(alice); n != 0 {
+		t.Fatalf("senders should have read their messages, got %d unread", n)
+	}
+	if n := room.UnreadCount(bob); n != 3 {
+		t.Fatalf("expected 3 unread messages, got %d", n)
+	}
+
+	mustExecute(t, s, InternalRaftCommand{MarkRead: &MarkReadCommand{UserName: "bob", RoomID: id, ID: "m2"}})
+	if n := room.UnreadCount(bob); n != 1 {
+		t.Fatalf("expected 1 unread message, got %d", n)
+	}
+	// The last read message never moves backward.
+	mustExecute(t, s, InternalRaftCommand{MarkRead: &MarkReadCommand{UserName: "bob", RoomID: id, ID: "m1"}})
+	if n := room.UnreadCount(bob); n != 1 {
+		t.Fatalf("expected 1 unread message, got %d", n)
+	}
+	mustExecute(t, s, InternalRaftCommand{MarkRead: &MarkReadCommand{UserName: "bob", RoomID: id}})
+	if n := room.UnreadCount(bob); n != 0 {
+		t.Fatalf("expected no unread messages, got %d", n)
+	}
+
+	cmd := InternalRaftCommand{MarkRead: &MarkReadCommand{UserName: "carol", RoomID: id}}
+	if err := cmd.Execute(s).Err; err != ErrNotRoomMember {
+		t.Fatalf("expected ErrNotRoomMember, got %v", err)
+	}
+	cmd = InternalRaftCommand{MarkRead: &MarkReadCommand{UserName: "bob", RoomID: id, ID: "unknown"}}
+	if err := cmd.Execute(s).Err; err != ErrMessageNotExists {
+		t.Fatalf("expected ErrMessageNotExists, got %v", err)
+	}
+}
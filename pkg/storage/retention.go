@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+// Retention limits the messages kept in a room. A zero field means no limit.
+type Retention struct {
+	// MaxAge drops the messages older than it.
+	MaxAge time.Duration
+	// MaxCount drops the oldest messages beyond it.
+	MaxCount int
+}
+
+func (r Retention) Validate() error {
+	if r.MaxAge < 0 {
+		return errors.New("max age must not be negative")
+	}
+	if r.MaxCount < 0 {
+		return errors.New("max count must not be negative")
+	}
+	return nil
+}
+
+// retention returns the retention policy of the room, the room's own policy
+// overrides the global one.
+func (s *Storage) retention(room *Room) Retention {
+	if room.Retention != nil {
+		return *room.Retention
+	}
+	return s.Retention
+}
+
+// expiredMessages returns the number of the oldest messages of the room
+// which should be dropped at now, in unix seconds.
+func (s *Storage) expiredMessages(room *Room, now int) int {
+	r := s.retention(room)
+	n, count := 0, room.MessageCount()
+	if r.MaxAge > 0 {
+		deadline := now - int(r.MaxAge/time.Second)
+		for n < count && room.MessageAt(n).TS < deadline {
+			n++
+		}
+	}
+	if r.MaxCount > 0 && count-n > r.MaxCount {
+		n = count - r.MaxCount
+	}
+	return n
+}
+
+// HasExpiredMessages returns whether a PruneMessagesCommand at now would
+// drop any message.
+func (s *Storage) HasExpiredMessages(now int) bool {
+	for _, room := range s.Rooms {
+		if s.expiredMessages(room, now) > 0 {
+			return true
+		}
+	}
+	return false
+}
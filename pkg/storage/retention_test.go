@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPruneMessages(t *testing.T) {
+	s := NewStorage()
+	mustExecute(t, s, InternalRaftCommand{CreateUser: &CreateUserCommand{UserName: "alice"}})
+	for i := 0; i < 2; i++ {
+		roomID := mustExecute(t, s, InternalRaftCommand{CreateRoom: &CreateRoomCommand{Name: "room"}}).(int)
+		mustExecute(t, s, InternalRaftCommand{EnterRoom: &EnterRoomCommand{UserName: "alice", RoomID: roomID}})
+		for ts := 1; ts <= 10; ts++ {
+			mustExecute(t, s, InternalRaftCommand{SendMessage: &SendMessageCommand{UserName: "alice", TS: ts}})
+		}
+	}
+	mustExecute(t, s, InternalRaftCommand{SetRetention: &SetRetentionCommand{
+		Admin:     true,
+		Retention: &Retention{MaxAge: 5 * time.Second},
+	}})
+	mustExecute(t, s, InternalRaftCommand{SetRetention: &SetRetentionCommand{
+		Admin:     true,
+		RoomID:    2,
+		Retention: &Retention{MaxCount: 3},
+	}})
+	if !s.HasExpiredMessages(0) {
+		t.Fatal("expect room 2 to have expired messages")
+	}
+	if err := (memoryBackend{}).Commit(s); err != nil {
+		t.Fatal(err)
+	}
+
+	pruned := mustExecute(t, s, InternalRaftCommand{PruneMessages: &PruneMessagesCommand{Now: 10}}).(int)
+	if pruned != 4+7 {
+		t.Fatalf("expect 11 messages pruned, got %d", pruned)
+	}
+	for _, c := range []struct {
+		room     int
+		firstSeq uint64
+		count    int
+	}{{1, 5, 6}, {2, 8, 3}} {
+		room := s.Rooms[c.room]
+		if msg := room.MessageAt(0); msg == nil || msg.Seq != c.firstSeq || room.MessageCount() != c.count {
+			t.Fatalf("unexpected messages of room %d, first %+v, count %d", c.room, msg, room.MessageCount())
+		}
+		if room.MessageBySeq(c.firstSeq-1) != nil {
+			t.Fatalf("pruned message of room %d should not be found", c.room)
+		}
+	}
+	if changes := s.TakeChanges(); len(changes.Messages) != pruned {
+		t.Fatalf("expect %d changed messages, got %d", pruned, len(changes.Messages))
+	}
+	if s.HasExpiredMessages(10) {
+		t.Fatal("expect no expired messages after pruning")
+	}
+}
+
+func TestSetRetentionPermission(t *testing.T) {
+	s, id := newTestRoom(t, CreateRoomCommand{Name: "room", Owner: "alice"})
+	mustExecute(t, s, InternalRaftCommand{SetModerator: &SetModeratorCommand{
+		UserName: "alice", RoomID: id, Target: "bob", Moderator: true,
+	}})
+	r := &Retention{MaxCount: 10}
+	for _, c := range []struct {
+		cmd SetRetentionCommand
+		err error
+	}{
+		{SetRetentionCommand{UserName: "alice", Retention: r}, ErrPermissionDenied},
+		{SetRetentionCommand{Admin: true, Retention: r}, nil},
+		{SetRetentionCommand{UserName: "carol", RoomID: id, Retention: r}, ErrPermissionDenied},
+		{SetRetentionCommand{UserName: "alice", RoomID: id, Retention: r}, nil},
+		{SetRetentionCommand{UserName: "bob", RoomID: id}, nil},
+		{SetRetentionCommand{Admin: true, RoomID: id, Retention: r}, nil},
+	} {
+		cmd := c.cmd
+		if result := (&InternalRaftCommand{SetRetention: &cmd}).Execute(s); result.Err != c.err {
+			t.Fatalf("expect %v for %+v, got %v", c.err, cmd, result.Err)
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"encoding/gob"
 	"sort"
+
+	"github.com/gozssky/groupchat/pkg/search"
 )
 
 type User struct {
@@ -13,70 +15,463 @@ type User struct {
 	Email     string
 	Password  string
 	Phone     string
-	RoomID    int
+	// RoomID is the current room of the user used by the single-room API,
+	// it is one of Rooms or 0.
+	RoomID int
+	// Rooms are the ids of the rooms the user is a member of, in ascending
+	// order.
+	Rooms []int
+	// TokenEpoch is embedded in tokens of the user, tokens with another
+	// epoch are revoked. It is set to the raft index of the command which
+	// creates the user or changes the password.
+	TokenEpoch uint64
+	// LastRead maps ids of the rooms the user is a member of to the seq of
+	// the last message read by the user.
+	LastRead map[int]uint64
+	// Mentions are the messages mentioning the user in ascending order of
+	// seq, at most MaxMentions of them are kept.
+	Mentions []Mention
+}
+
+// IsMember returns whether the user is a member of the room.
+func (user *User) IsMember(roomID int) bool {
+	i := sort.SearchInts(user.Rooms, roomID)
+	return i < len(user.Rooms) && user.Rooms[i] == roomID
 }
 
 type Message struct {
-	ID   string
-	TS   int
-	Text string
+	// Seq is the sequence number of the message in its room, it increases
+	// monotonically and is never reused.
+	Seq uint64
+	ID  string
+	// TS and TSMilli are when the message was sent in seconds and
+	// milliseconds, they are assigned by the leader. TSMilli is 0 for
+	// messages sent by old versions.
+	TS      int
+	TSMilli int64
+	Text    string
+	// Author is the name of the sender, it is empty for room messages sent
+	// by old versions.
+	Author string
+	// Revisions are the previous texts of an edited message, oldest first.
+	Revisions []Revision
+	// EditTS is when the message was last edited or deleted.
+	EditTS int
+	// Deleted messages are kept as tombstones without text.
+	Deleted bool
+	// ReplyTo is the seq of the root message of the thread if the message
+	// is a reply.
+	ReplyTo uint64
+	// Replies are the seqs of the replies to the root message of a thread,
+	// in ascending order.
+	Replies []uint64
+	// Reactions maps emojis to the sorted names of users who reacted with
+	// them.
+	Reactions map[string][]string
+	// Attachments are the ids of the attachments of the message.
+	Attachments []string
 }
 
 type Room struct {
-	ID       int
-	Name     string
-	Users    []string
+	ID    int
+	Name  string
+	Users []string
+	// Messages are only set in rooms of snapshots created by old versions,
+	// messages are kept by the message store of the storage otherwise.
 	Messages []*Message
+	// FirstSeq is the sequence number of the first kept message, the
+	// messages in [FirstSeq, NextSeq) are all kept since only the oldest
+	// ones are pruned and deleted ones are kept as tombstones.
+	FirstSeq uint64
+	// NextSeq is the sequence number of the next message.
+	NextSeq uint64
+	// Retention overrides the global retention policy if it is not nil.
+	Retention *Retention
+	// Archived rooms are read-only and hidden from the room list.
+	Archived bool
+	// Direct is true if the room is a direct conversation, whose Users are
+	// the sorted participants. Archived direct conversations are tombstones
+	// left by deleted participants.
+	Direct bool
+	// FormerUsers are the sorted names of the deleted participants of the
+	// direct conversation.
+	FormerUsers []string
+	// Owner is the name of the user who created the room, it is empty for
+	// rooms created by old versions or whose owner has been deleted.
+	Owner string
+	// Private rooms can only be joined by invitation or approved join
+	// request, and are only visible to their members.
+	Private bool
+	// Invites and JoinRequests are the sorted names of users invited to and
+	// requesting to join the private room.
+	Invites      []string
+	JoinRequests []string
+	// Moderators are the sorted names of users who can moderate the room
+	// besides its owner.
+	Moderators []string
+	// Bans and Mutes map the names of banned and muted users to the unix
+	// time when the restriction expires, 0 means it never expires.
+	Bans  map[string]int
+	Mutes map[string]int
+
+	// storage looks up the messages of the room.
+	storage *Storage
 }
 
 type Snapshot struct {
+	// Index is the index of the last applied raft entry. It is set to the
+	// index of the entry being executed before executing each command.
 	Index     uint64
 	Users     map[string]*User
 	Rooms     map[int]*Room
 	SecretKey []byte
+	// Retention is the global retention policy of messages.
+	Retention Retention
+	// NextRoomID is the id of the next room, ids of deleted rooms are never
+	// reused.
+	NextRoomID int
+	// Attachments maps blob ids to the uploaded attachments.
+	Attachments map[string]*Attachment
 }
 
 type Storage struct {
 	Snapshot
-	NextRoomID int
-	RoomList   []*Room
+	// RoomList contains the rooms which are not archived, in the order of id.
+	RoomList []*Room
+
+	// directs maps ids of direct conversations to their rooms.
+	directs map[string]int
+	// tombstones maps ids of direct conversations, including the deleted
+	// participants, to their latest tombstones.
+	tombstones map[string]int
+	// messages keeps the committed messages and their indexes, the changes
+	// not committed yet are looked up in changes first.
+	messages messageStore
+	changes  *Changes
+	// events are the changes pushed to real-time subscribers since the last
+	// call of TakeEvents.
+	events []Event
+}
+
+// Changes records the data modified since the last commit to backend.
+type Changes struct {
+	Meta  bool
+	Users map[string]struct{}
+	Rooms map[int]struct{}
+	// Messages maps keys of the created, modified and pruned messages to
+	// them, pruned messages are nil.
+	Messages map[MessageKey]*Message
+	// IDs maps the ids added to and removed from the id index of messages
+	// to their seqs, removed ids are mapped to 0.
+	IDs map[MessageIDKey]uint64
+	// Reactions records the messages which users start reacting to (true)
+	// or no longer react to (false).
+	Reactions map[ReactionKey]bool
+	// Attachments are the ids of the created or modified attachments.
+	Attachments map[string]struct{}
+}
+
+type MessageKey struct {
+	RoomID int
+	Seq    uint64
+}
+
+// MessageIDKey identifies a message by its room and id.
+type MessageIDKey struct {
+	RoomID int
+	ID     string
+}
+
+// ReactionKey is a message which the user reacts to.
+type ReactionKey struct {
+	UserName string
+	MessageKey
+}
+
+func newChanges() *Changes {
+	return &Changes{
+		Users:       make(map[string]struct{}),
+		Rooms:       make(map[int]struct{}),
+		Messages:    make(map[MessageKey]*Message),
+		IDs:         make(map[MessageIDKey]uint64),
+		Reactions:   make(map[ReactionKey]bool),
+		Attachments: make(map[string]struct{}),
+	}
 }
 
 func NewStorage() *Storage {
 	return &Storage{
 		Snapshot: Snapshot{
-			Users: make(map[string]*User),
-			Rooms: make(map[int]*Room),
+			Users:       make(map[string]*User),
+			Rooms:       make(map[int]*Room),
+			NextRoomID:  1,
+			Attachments: make(map[string]*Attachment),
 		},
-		NextRoomID: 1,
+		directs:    make(map[string]int),
+		tombstones: make(map[string]int),
+		messages:   newMemoryStore(),
+		changes:    newChanges(),
 	}
 }
 
-func (s *Storage) GenSnapshot() []byte {
-	var buf bytes.Buffer
-	if err := gob.NewEncoder(&buf).Encode(&s.Snapshot); err != nil {
-		panic(err)
+func (s *Storage) touchMeta() {
+	s.changes.Meta = true
+}
+
+func (s *Storage) touchUser(name string) {
+	s.changes.Users[name] = struct{}{}
+}
+
+func (s *Storage) touchRoom(id int) {
+	s.changes.Rooms[id] = struct{}{}
+}
+
+// putMessage records the created or modified message.
+func (s *Storage) putMessage(roomID int, msg *Message) {
+	s.changes.Messages[MessageKey{RoomID: roomID, Seq: msg.Seq}] = msg
+}
+
+// dropMessage records the pruned message.
+func (s *Storage) dropMessage(roomID int, seq uint64) {
+	s.changes.Messages[MessageKey{RoomID: roomID, Seq: seq}] = nil
+}
+
+func (s *Storage) touchAttachment(id string) {
+	s.changes.Attachments[id] = struct{}{}
+}
+
+// PendingChanges returns the changes since the last call of TakeChanges
+// without resetting them.
+func (s *Storage) PendingChanges() *Changes {
+	return s.changes
+}
+
+// TakeChanges returns the changes since the last call and resets them, the
+// caller must commit them to the message store.
+func (s *Storage) TakeChanges() *Changes {
+	changes := s.changes
+	s.changes = newChanges()
+	return changes
+}
+
+// Message returns the message with the given key, or nil if it doesn't exist.
+func (s *Storage) Message(key MessageKey) *Message {
+	if msg, ok := s.changes.Messages[key]; ok {
+		return msg
+	}
+	if _, ok := s.Rooms[key.RoomID]; !ok {
+		return nil
+	}
+	return s.messages.message(key)
+}
+
+// messageSeq returns the seq of the message with the id in the room.
+func (s *Storage) messageSeq(roomID int, id string) (uint64, bool) {
+	if seq, ok := s.changes.IDs[MessageIDKey{RoomID: roomID, ID: id}]; ok {
+		return seq, seq != 0
+	}
+	return s.messages.messageSeq(roomID, id)
+}
+
+// reactedMessages returns the messages which the user may react to, some of
+// them may have been deleted or pruned since.
+func (s *Storage) reactedMessages(name string) []MessageKey {
+	var keys []MessageKey
+	for _, key := range s.messages.reactedMessages(name) {
+		if reacted, ok := s.changes.Reactions[ReactionKey{UserName: name, MessageKey: key}]; !ok || reacted {
+			keys = append(keys, key)
+		}
+	}
+	for key, reacted := range s.changes.Reactions {
+		if key.UserName == name && reacted {
+			keys = append(keys, key.MessageKey)
+		}
 	}
-	return buf.Bytes()
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].RoomID != keys[j].RoomID {
+			return keys[i].RoomID < keys[j].RoomID
+		}
+		return keys[i].Seq < keys[j].Seq
+	})
+	j := 0
+	for i, key := range keys {
+		if i == 0 || key != keys[j-1] {
+			keys[j] = key
+			j++
+		}
+	}
+	return keys[:j]
+}
+
+// Search returns the messages of rooms matching the query ranked by
+// relevance, direct messages are not indexed. The index is only updated
+// when the changes are committed to the backend.
+func (s *Storage) Search(query string) []search.Hit {
+	return s.messages.search(query)
 }
 
+// DecodeSnapshot decodes a snapshot created by old versions, which hold the
+// whole state machine in the raft snapshot.
+func DecodeSnapshot(data []byte) (*Snapshot, error) {
+	var snapshot Snapshot
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&snapshot); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// RecoverFromSnapshot recovers an empty storage from a snapshot created by
+// old versions, the messages are recorded as changes to be committed to the
+// backend.
 func (s *Storage) RecoverFromSnapshot(snapshot []byte) {
 	if err := gob.NewDecoder(bytes.NewReader(snapshot)).Decode(&s.Snapshot); err != nil {
 		panic(err)
 	}
+	s.rebuild()
+}
+
+// rebuild rebuilds the derived fields after s.Snapshot is replaced.
+func (s *Storage) rebuild() {
 	if s.Users == nil {
 		s.Users = make(map[string]*User)
 	}
 	if s.Rooms == nil {
 		s.Rooms = make(map[int]*Room)
 	}
+	if s.Attachments == nil {
+		s.Attachments = make(map[string]*Attachment)
+	}
+	// Snapshots created by old versions have no NextRoomID.
+	if s.NextRoomID <= 0 {
+		s.NextRoomID = 1
+	}
+	for _, user := range s.Users {
+		// Users of snapshots created by old versions only have RoomID.
+		if user.RoomID > 0 && !user.IsMember(user.RoomID) {
+			user.Rooms = append(user.Rooms, user.RoomID)
+			sort.Ints(user.Rooms)
+		}
+	}
+	s.RoomList = s.RoomList[:0]
+	s.directs = make(map[string]int)
+	s.tombstones = make(map[string]int)
 	for _, room := range s.Rooms {
+		room.storage = s
 		if room.ID >= s.NextRoomID {
 			s.NextRoomID = room.ID + 1
 		}
-		s.RoomList = append(s.RoomList, room)
+		if room.FirstSeq == 0 {
+			s.migrateMessages(room)
+		}
+		if room.Direct && room.Archived {
+			s.indexTombstone(room)
+		} else if room.Direct {
+			s.directs[DirectID(room.Users)] = room.ID
+		} else if !room.Archived {
+			s.RoomList = append(s.RoomList, room)
+		}
 	}
 	sort.Slice(s.RoomList, func(i, j int) bool {
 		return s.RoomList[i].ID < s.RoomList[j].ID
 	})
 }
+
+// migrateMessages moves the messages of a room of old versions, which are
+// kept in the room, to the message store and indexes them.
+func (s *Storage) migrateMessages(room *Room) {
+	// Messages of snapshots created by old versions have no seq.
+	for i, msg := range room.Messages {
+		if msg.Seq == 0 {
+			msg.Seq = uint64(i + 1)
+		}
+	}
+	if n := len(room.Messages); n > 0 && room.NextSeq <= room.Messages[n-1].Seq {
+		room.NextSeq = room.Messages[n-1].Seq + 1
+	}
+	if room.NextSeq == 0 {
+		room.NextSeq = 1
+	}
+	room.FirstSeq = room.NextSeq
+	if len(room.Messages) > 0 {
+		room.FirstSeq = room.Messages[0].Seq
+	}
+	roots := make(map[uint64]*Message)
+	for _, msg := range room.Messages {
+		// Replies whose root message is pruned are left out of threads.
+		if root, ok := roots[msg.ReplyTo]; ok {
+			root.Replies = append(root.Replies, msg.Seq)
+		}
+		roots[msg.Seq] = msg
+		s.putMessage(room.ID, msg)
+		s.indexID(room.ID, msg)
+		s.refAttachments(room.ID, msg.Attachments)
+		for _, names := range msg.Reactions {
+			for _, name := range names {
+				s.indexReaction(name, room.ID, msg.Seq)
+			}
+		}
+	}
+	room.Messages = nil
+	s.touchRoom(room.ID)
+}
+
+// addRoom adds the created room to s.Rooms.
+func (s *Storage) addRoom(room *Room) {
+	room.storage = s
+	if room.FirstSeq == 0 {
+		room.FirstSeq = room.NextSeq
+	}
+	s.Rooms[room.ID] = room
+}
+
+// joinRoom adds the user to the members of the room.
+func (s *Storage) joinRoom(user *User, room *Room) {
+	if user.IsMember(room.ID) {
+		return
+	}
+	i := sort.SearchInts(user.Rooms, room.ID)
+	user.Rooms = append(user.Rooms, 0)
+	copy(user.Rooms[i+1:], user.Rooms[i:])
+	user.Rooms[i] = room.ID
+	room.Users = append(room.Users, user.UserName)
+	s.touchUser(user.UserName)
+	s.touchRoom(room.ID)
+}
+
+// leaveRoom removes the user from the members of the room.
+func (s *Storage) leaveRoom(user *User, room *Room) {
+	i := sort.SearchInts(user.Rooms, room.ID)
+	if i < len(user.Rooms) && user.Rooms[i] == room.ID {
+		user.Rooms = append(user.Rooms[:i], user.Rooms[i+1:]...)
+	}
+	if user.RoomID == room.ID {
+		user.RoomID = 0
+	}
+	delete(user.LastRead, room.ID)
+	room.Users = removeUser(room.Users, user.UserName)
+	s.touchUser(user.UserName)
+	s.touchRoom(room.ID)
+}
+
+// listRoom adds the room to RoomList in the order of id.
+func (s *Storage) listRoom(room *Room) {
+	i := sort.Search(len(s.RoomList), func(i int) bool {
+		return s.RoomList[i].ID >= room.ID
+	})
+	if i < len(s.RoomList) && s.RoomList[i].ID == room.ID {
+		return
+	}
+	s.RoomList = append(s.RoomList, nil)
+	copy(s.RoomList[i+1:], s.RoomList[i:])
+	s.RoomList[i] = room
+}
+
+// unlistRoom removes the room from RoomList.
+func (s *Storage) unlistRoom(room *Room) {
+	for i, r := range s.RoomList {
+		if r.ID == room.ID {
+			s.RoomList = append(s.RoomList[:i], s.RoomList[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package storage
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+
+	"github.com/gozssky/groupchat/pkg/search"
+)
+
+// messageStore keeps the committed messages of rooms together with the
+// indexes of their ids, reactions and texts, so that the backend decides
+// whether they are kept in memory. Failures of the underlying store are
+// fatal and panic, since the state machine can't go on without its data.
+type messageStore interface {
+	// message returns the message, or nil if it doesn't exist.
+	message(key MessageKey) *Message
+	// messageSeq returns the seq of the message with the id in the room.
+	messageSeq(roomID int, id string) (uint64, bool)
+	// reactedMessages returns the messages which the user reacts to.
+	reactedMessages(name string) []MessageKey
+	search(query string) []search.Hit
+}
+
+// messageWriter applies the changes of messages to a message store.
+type messageWriter interface {
+	putMessage(key MessageKey, msg *Message)
+	deleteMessage(key MessageKey)
+	putID(key MessageIDKey, seq uint64)
+	deleteID(key MessageIDKey)
+	putReaction(key ReactionKey)
+	deleteReaction(key ReactionKey)
+	// deleteRoom deletes the messages and ids of the room.
+	deleteRoom(roomID int)
+	searchIndex() *search.Index
+}
+
+// commitMessages writes the changes of messages to w and updates the search
+// index with them.
+func commitMessages(w messageWriter, s *Storage, changes *Changes) {
+	idx := w.searchIndex()
+	for key, msg := range changes.Messages {
+		room, ok := s.Rooms[key.RoomID]
+		if !ok {
+			continue
+		}
+		if msg != nil {
+			w.putMessage(key, msg)
+		} else {
+			w.deleteMessage(key)
+		}
+		indexMessage(idx, room, key, msg)
+	}
+	for key, seq := range changes.IDs {
+		if _, ok := s.Rooms[key.RoomID]; !ok {
+			continue
+		}
+		if seq != 0 {
+			w.putID(key, seq)
+		} else {
+			w.deleteID(key)
+		}
+	}
+	// Reactions to messages of deleted rooms are left, they are skipped
+	// and dropped when the reactions of the user are forgotten.
+	for key, reacted := range changes.Reactions {
+		if reacted {
+			w.putReaction(key)
+		} else {
+			w.deleteReaction(key)
+		}
+	}
+	for id := range changes.Rooms {
+		if _, ok := s.Rooms[id]; !ok {
+			w.deleteRoom(id)
+			idx.DeleteRoom(id)
+		}
+	}
+}
+
+// indexMessage updates the search index with the message, which is nil if
+// it is pruned. Direct messages and deleted messages are not indexed.
+func indexMessage(idx *search.Index, room *Room, key MessageKey, msg *Message) {
+	id := search.DocID{RoomID: key.RoomID, Seq: key.Seq}
+	if msg == nil || room.Direct || msg.Deleted {
+		idx.Delete(id)
+		return
+	}
+	ts := msg.TSMilli
+	if ts == 0 {
+		ts = int64(msg.TS) * 1000
+	}
+	idx.Put(id, msg.Text, ts)
+}
+
+// memoryStore keeps the messages in maps.
+type memoryStore struct {
+	messages  map[int]map[uint64]*Message
+	ids       map[int]map[string]uint64
+	reactions map[string]map[MessageKey]struct{}
+	index     *search.Index
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		messages:  make(map[int]map[uint64]*Message),
+		ids:       make(map[int]map[string]uint64),
+		reactions: make(map[string]map[MessageKey]struct{}),
+		index:     search.NewIndex(),
+	}
+}
+
+func (m *memoryStore) message(key MessageKey) *Message {
+	return m.messages[key.RoomID][key.Seq]
+}
+
+func (m *memoryStore) messageSeq(roomID int, id string) (uint64, bool) {
+	seq, ok := m.ids[roomID][id]
+	return seq, ok
+}
+
+func (m *memoryStore) reactedMessages(name string) []MessageKey {
+	keys := make([]MessageKey, 0, len(m.reactions[name]))
+	for key := range m.reactions[name] {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (m *memoryStore) search(query string) []search.Hit {
+	return m.index.Search(query)
+}
+
+func (m *memoryStore) putMessage(key MessageKey, msg *Message) {
+	if m.messages[key.RoomID] == nil {
+		m.messages[key.RoomID] = make(map[uint64]*Message)
+	}
+	m.messages[key.RoomID][key.Seq] = msg
+}
+
+func (m *memoryStore) deleteMessage(key MessageKey) {
+	delete(m.messages[key.RoomID], key.Seq)
+}
+
+func (m *memoryStore) putID(key MessageIDKey, seq uint64) {
+	if m.ids[key.RoomID] == nil {
+		m.ids[key.RoomID] = make(map[string]uint64)
+	}
+	m.ids[key.RoomID][key.ID] = seq
+}
+
+func (m *memoryStore) deleteID(key MessageIDKey) {
+	delete(m.ids[key.RoomID], key.ID)
+}
+
+func (m *memoryStore) putReaction(key ReactionKey) {
+	if m.reactions[key.UserName] == nil {
+		m.reactions[key.UserName] = make(map[MessageKey]struct{})
+	}
+	m.reactions[key.UserName][key.MessageKey] = struct{}{}
+}
+
+func (m *memoryStore) deleteReaction(key ReactionKey) {
+	delete(m.reactions[key.UserName], key.MessageKey)
+	if len(m.reactions[key.UserName]) == 0 {
+		delete(m.reactions, key.UserName)
+	}
+}
+
+func (m *memoryStore) deleteRoom(roomID int) {
+	delete(m.messages, roomID)
+	delete(m.ids, roomID)
+}
+
+func (m *memoryStore) searchIndex() *search.Index {
+	return m.index
+}
+
+// forEach calls fn for the messages of every room in seq order.
+func (m *memoryStore) forEach(fn func(key MessageKey, msg *Message)) {
+	for roomID, messages := range m.messages {
+		seqs := make([]uint64, 0, len(messages))
+		for seq := range messages {
+			seqs = append(seqs, seq)
+		}
+		sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+		for _, seq := range seqs {
+			fn(MessageKey{RoomID: roomID, Seq: seq}, messages[seq])
+		}
+	}
+}
+
+// messageCache is an LRU cache of decoded messages. Readers look up messages
+// concurrently under the read lock of the storage, so it has its own lock.
+type messageCache struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[MessageKey]*list.Element
+}
+
+type cacheEntry struct {
+	key MessageKey
+	msg *Message
+}
+
+func newMessageCache(size int) *messageCache {
+	return &messageCache{
+		size:  size,
+		ll:    list.New(),
+		items: make(map[MessageKey]*list.Element),
+	}
+}
+
+func (c *messageCache) get(key MessageKey) (*Message, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*cacheEntry).msg, true
+}
+
+func (c *messageCache) add(key MessageKey, msg *Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		e.Value.(*cacheEntry).msg = msg
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&cacheEntry{key: key, msg: msg})
+	for c.ll.Len() > c.size {
+		e := c.ll.Back()
+		c.ll.Remove(e)
+		delete(c.items, e.Value.(*cacheEntry).key)
+	}
+}
+
+func (c *messageCache) remove(key MessageKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.ll.Remove(e)
+		delete(c.items, key)
+	}
+}
+
+func (c *messageCache) removeRoom(roomID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.items {
+		if key.RoomID == roomID {
+			c.ll.Remove(e)
+			delete(c.items, key)
+		}
+	}
+}
+
+func (c *messageCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = make(map[MessageKey]*list.Element)
+}
+
+func (c *messageCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
@@ -0,0 +1,52 @@
+package storage
+
+// indexReply adds the reply to the thread of its root message.
+func (s *Storage) indexReply(room *Room, msg *Message) {
+	if msg.ReplyTo == 0 {
+		return
+	}
+	// The root message is older than its replies, so it is pruned first.
+	if root := room.MessageBySeq(msg.ReplyTo); root != nil {
+		root.Replies = append(root.Replies, msg.Seq)
+		s.putMessage(room.ID, root)
+	}
+}
+
+// ReplyCount returns the number of replies to the message.
+func (room *Room) ReplyCount(seq uint64) int {
+	if msg := room.MessageBySeq(seq); msg != nil {
+		return len(msg.Replies)
+	}
+	return 0
+}
+
+// Replies returns the replies to the message, oldest first.
+func (room *Room) Replies(seq uint64) []*Message {
+	msg := room.MessageBySeq(seq)
+	if msg == nil {
+		return []*Message{}
+	}
+	replies := make([]*Message, 0, len(msg.Replies))
+	for _, replySeq := range msg.Replies {
+		if reply := room.MessageBySeq(replySeq); reply != nil {
+			replies = append(replies, reply)
+		}
+	}
+	return replies
+}
+
+// threadRoot returns the seq of the thread root for a reply to the message
+// with the id, replies to a reply join the thread of its root.
+func (room *Room) threadRoot(id string) (uint64, error) {
+	msg, ok := room.MessageByID(id)
+	if !ok {
+		return 0, ErrMessageNotExists
+	}
+	if msg.Deleted {
+		return 0, ErrMessageDeleted
+	}
+	if msg.ReplyTo != 0 {
+		return msg.ReplyTo, nil
+	}
+	return msg.Seq, nil
+}
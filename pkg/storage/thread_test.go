@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestThreads(t *testing.T) {
+	s := NewStorage()
+	mustExecute(t, s, InternalRaftCommand{CreateUser: &CreateUserCommand{UserName: "alice"}})
+	id := mustExecute(t, s, InternalRaftCommand{CreateRoom: &CreateRoomCommand{Name: "lobby"}}).(int)
+	mustExecute(t, s, InternalRaftCommand{EnterRoom: &EnterRoomCommand{UserName: "alice", RoomID: id}})
+	send := func(msgID, replyTo string, ts int) *ExecuteResult {
+		cmd := InternalRaftCommand{SendMessage: &SendMessageCommand{
+			ID: msgID, TS: ts, Text: msgID, UserName: "alice", ReplyTo: replyTo,
+		}}
+		return cmd.Execute(s)
+	}
+	for _, m := range []struct{ id, replyTo string }{
+		{"root", ""}, {"r1", "root"}, {"other", ""}, {"r2", "r1"},
+	} {
+		if result := send(m.id, m.replyTo, 100); result.Err != nil {
+			t.Fatal(result.Err)
+		}
+	}
+	if result := send("r3", "missing", 100); result.Err != ErrMessageNotExists {
+		t.Fatalf("expect %v, got %v", ErrMessageNotExists, result.Err)
+	}
+
+	room, _ := s.Room(id)
+	root, _ := room.MessageByID("root")
+	replies := room.Replies(root.Seq)
+	if room.ReplyCount(root.Seq) != 2 || len(replies) != 2 || replies[1].ID != "r2" || replies[1].ReplyTo != root.Seq {
+		t.Fatalf("replies to a reply should join the thread of its root: %+v", replies)
+	}
+
+	// Threads are kept in snapshots and rebuilt from snapshots of old
+	// versions.
+	s2 := recoverStorage(t, s)
+	room2, _ := s2.Room(id)
+	if room2.ReplyCount(root.Seq) != 2 {
+		t.Fatal("threads should be kept in snapshots")
+	}
+	legacy := NewStorage()
+	legacy.RecoverFromSnapshot(legacySnapshot(t, s))
+	if room, _ := legacy.Room(id); room.ReplyCount(root.Seq) != 2 || room.Replies(root.Seq)[1].ID != "r2" {
+		t.Fatal("threads should be rebuilt from snapshots of old versions")
+	}
+
+	// The thread is dropped with its pruned root message.
+	mustExecute(t, s2, InternalRaftCommand{SetRetention: &SetRetentionCommand{Admin: true, RoomID: id, Retention: &Retention{MaxCount: 2}}})
+	mustExecute(t, s2, InternalRaftCommand{PruneMessages: &PruneMessagesCommand{Now: 100}})
+	if room2.ReplyCount(root.Seq) != 0 || len(room2.Replies(root.Seq)) != 0 {
+		t.Fatal("pruned messages should leave the thread")
+	}
+	s3 := NewStorage()
+	s3.RecoverFromSnapshot(legacySnapshot(t, s2))
+	room3, _ := s3.Room(id)
+	if other, _ := room3.MessageByID("other"); room3.ReplyCount(other.Seq) != 0 || len(other.Replies) != 0 {
+		t.Fatal("replies to pruned messages should not be indexed")
+	}
+}